@@ -0,0 +1,486 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Access
+//
+// Every exported transaction is mapped to the roles allowed to call it in
+// accessPolicy. beforeTransaction checks the caller against that map before
+// the transaction reads any state, transactions not in the map are denied.
+// Transactions then check the role against the office or estate they touch
+// (the sub-registrar of that office, the owner of that estate).
+//
+// Denials are returned as Access_Error, whose message is JSON so clients can
+// tell them apart from other failures.
+
+const (
+	Access_Public       = "public"       // any identity
+	Access_SuperAdmin   = "superAdmin"   // bound super admin
+	Access_SubRegistrar = "subRegistrar" // bound sub-registrar of an office
+	Access_Owner        = "owner"        // verified user, co-owner of the estate
+	Access_Buyer        = "buyer"        // verified user
+	Access_Bank         = "bank"         // identity of an active bank's org
+)
+
+const (
+	AccessCode_Unauthenticated   = "UNAUTHENTICATED"     // identity unreadable or not bound
+	AccessCode_Forbidden         = "FORBIDDEN"           // role not allowed for the transaction
+	AccessCode_NotOwner          = "NOT_OWNER"           // caller does not own the estate/request
+	AccessCode_OutOfJurisdiction = "OUT_OF_JURISDICTION" // caller is admin of another office
+)
+
+var accessPolicy = map[string][]string{
+	"InitLedger":       {Access_Public},
+	"Migrate_Identity": {Access_Public},
+	"ChangePassword":   {Access_Public},
+
+	"CreateOrModify_Admin":    {Access_SuperAdmin},
+	"Migrate_Keys":            {Access_SuperAdmin},
+	"Set_CancellationPenalty": {Access_SuperAdmin},
+	"Set_ConsentThreshold":    {Access_SuperAdmin},
+	"Set_ObjectionDays":       {Access_SuperAdmin},
+	"Set_GiftStampDuty":       {Access_SuperAdmin},
+	"CreateOrModify_Bank":     {Access_SuperAdmin},
+
+	"Create_User":        {Access_SuperAdmin, Access_SubRegistrar},
+	"ResetPassword_User": {Access_SuperAdmin, Access_SubRegistrar},
+	"Verify_User":        {Access_SuperAdmin, Access_SubRegistrar},
+	"Modify_User":        {Access_SuperAdmin, Access_SubRegistrar},
+	"Create_Estate":      {Access_SuperAdmin, Access_SubRegistrar},
+	"Modify_Estate":      {Access_SuperAdmin, Access_SubRegistrar},
+	"Verify_Estate":      {Access_SuperAdmin, Access_SubRegistrar},
+	"Add_Transaction":    {Access_SuperAdmin, Access_SubRegistrar},
+	"SetOwners_Estate":   {Access_SuperAdmin, Access_SubRegistrar},
+
+	"RegisterDeath_User":          {Access_SuperAdmin, Access_SubRegistrar},
+	"DeclareHeirs_Estate":         {Access_SuperAdmin, Access_SubRegistrar},
+	"Object_Succession":           {Access_Buyer},
+	"DismissObjection_Succession": {Access_SubRegistrar},
+	"ApproveSuccession_Estate":    {Access_SubRegistrar},
+	"RejectSuccession_Estate":     {Access_SubRegistrar},
+	"GetSuccession":               {Access_SuperAdmin, Access_SubRegistrar, Access_Owner, Access_Buyer},
+
+	"ApproveSell_Estate": {Access_SubRegistrar},
+	"SubdivideEstate":    {Access_SubRegistrar},
+	"AmalgamateEstates":  {Access_SubRegistrar},
+	"RejectSell_Estate":  {Access_SubRegistrar},
+
+	"PurgeExpiredRequests": {Access_SuperAdmin, Access_SubRegistrar},
+
+	"ChangeAvail_Estate":   {Access_Owner},
+	"RequestToBuy_Estate":  {Access_Buyer},
+	"AcceptRequest_Estate": {Access_Owner},
+	"ClearRequests_Estate": {Access_Owner, Access_Buyer},
+
+	"ConsentSale_Estate":   {Access_Owner},
+	"TransferShare_Estate": {Access_Owner},
+	"GiftEstate":           {Access_Owner},
+	"AcceptGiftEstate":     {Access_Buyer},
+
+	"CounterOffer_Estate": {Access_Owner, Access_Buyer},
+	"AcceptOffer_Estate":  {Access_Owner, Access_Buyer},
+
+	"StartAuction_Estate":  {Access_Owner},
+	"PlaceBid_Estate":      {Access_Buyer},
+	"CommitBid_Estate":     {Access_Buyer},
+	"RevealBid_Estate":     {Access_Buyer},
+	"SettleAuction_Estate": {Access_SuperAdmin, Access_SubRegistrar, Access_Owner, Access_Buyer},
+
+	"WithdrawAcceptance_Estate": {Access_Owner},
+	"WithdrawOffer_Estate":      {Access_Buyer},
+
+	"RegisterLease_Estate":  {Access_Owner},
+	"RenewLease_Estate":     {Access_Owner},
+	"TerminateLease_Estate": {Access_Owner, Access_Buyer},
+	"ExpireLease_Estate":    {Access_SuperAdmin, Access_SubRegistrar, Access_Owner, Access_Buyer},
+	"ApproveLease_Estate":   {Access_SubRegistrar},
+	"RejectLease_Estate":    {Access_SubRegistrar},
+	"GetLeaseTerms":         {Access_SuperAdmin, Access_SubRegistrar, Access_Owner, Access_Buyer},
+
+	"Register_Lien":    {Access_Bank},
+	"Modify_Lien":      {Access_Bank},
+	"Release_Lien":     {Access_Bank},
+	"ConsentSale_Lien": {Access_Bank},
+
+	"GetEncumbranceCertificate": {Access_SuperAdmin, Access_SubRegistrar, Access_Owner, Access_Buyer, Access_Bank},
+
+	"GetEstateHistory": {Access_SuperAdmin, Access_SubRegistrar, Access_Owner, Access_Buyer},
+	"QueryEstates":     {Access_SuperAdmin, Access_SubRegistrar, Access_Owner, Access_Buyer},
+	"GetOffers":        {Access_SuperAdmin, Access_SubRegistrar, Access_Owner, Access_Buyer},
+	"GetRequests":      {Access_SuperAdmin, Access_SubRegistrar, Access_Owner, Access_Buyer},
+	"GetAuction":       {Access_SuperAdmin, Access_SubRegistrar, Access_Owner, Access_Buyer},
+
+	"GetTransactionPrice":    {Access_SuperAdmin, Access_SubRegistrar, Access_Owner, Access_Buyer},
+	"VerifyTransactionPrice": {Access_SuperAdmin, Access_SubRegistrar, Access_Owner, Access_Buyer},
+	"GetUserName":            {Access_SuperAdmin, Access_SubRegistrar, Access_Owner, Access_Buyer},
+	"VerifyUserName":         {Access_SuperAdmin, Access_SubRegistrar, Access_Owner, Access_Buyer},
+}
+
+type Access_Error struct {
+	Code     string   `json:"code"`
+	Function string   `json:"function"`
+	Caller   string   `json:"caller"` // enrollment id
+	Allowed  []string `json:"allowed,omitempty"`
+	Message  string   `json:"message"`
+}
+
+func (e *Access_Error) Error() string {
+	marshaled_data, _ := json.Marshal(e)
+	return string(marshaled_data)
+}
+
+// ------------------------------------
+
+// run by contractapi before every transaction, not a transaction itself
+func (s *SmartContract) GetBeforeTransaction() interface{} {
+	return s.beforeTransaction
+}
+
+// ------------------------------------
+
+// Helper Functions - Private
+
+func (s *SmartContract) beforeTransaction(ctx contractapi.TransactionContextInterface) error {
+
+	fname, params := ctx.GetStub().GetFunctionAndParameters()
+
+	// strip contract name, "SmartContract:Create_User"
+	fname = fname[strings.LastIndex(fname, ":")+1:]
+
+	err0 := checkPositionalSecrets(fname, params)
+	if err0 != nil {
+		return err0
+	}
+
+	allowed, ok := accessPolicy[fname]
+	if !ok {
+		return &Access_Error{
+			Code:     AccessCode_Forbidden,
+			Function: fname,
+			Message:  "Transaction is not in the access policy",
+		}
+	}
+
+	if searchArray(allowed, Access_Public) != -1 {
+		return nil
+	}
+
+	caller, err1 := s.getCaller(ctx)
+	if err1 != nil {
+		return &Access_Error{
+			Code:     AccessCode_Unauthenticated,
+			Function: fname,
+			Allowed:  allowed,
+			Message:  err1.Error(),
+		}
+	}
+
+	roles, err2 := s.getRoles(ctx, caller)
+	if err2 != nil {
+		return fmt.Errorf("%s >> %s", fname, err2.Error())
+	}
+
+	for _, role := range roles {
+		if searchArray(allowed, role) != -1 {
+			return nil
+		}
+	}
+
+	// banks are not bound to a record
+	code := AccessCode_Forbidden
+	if caller.Username == "" && caller.Role != Role_Bank {
+		code = AccessCode_Unauthenticated
+	}
+
+	return &Access_Error{
+		Code:     code,
+		Function: fname,
+		Caller:   caller.EnrollmentID,
+		Allowed:  allowed,
+		Message:  fmt.Sprintf("Caller has roles [%s]", strings.Join(roles, ", ")),
+	}
+}
+
+// roles held by the caller, independent of any estate or office
+func (s *SmartContract) getRoles(ctx contractapi.TransactionContextInterface, caller Caller) ([]string, error) {
+
+	roles := []string{}
+
+	if caller.Role == Role_SuperAdmin && caller.Username == "admin_super" {
+		roles = append(roles, Access_SuperAdmin)
+	}
+
+	if caller.Role == Role_SubRegistrar && caller.Office != "" && caller.Username == "admin_"+caller.Office {
+		roles = append(roles, Access_SubRegistrar)
+	}
+
+	if strings.HasPrefix(caller.Username, "user_") {
+
+		// get user data
+		user, err0 := newRepository(ctx).GetUser(strings.TrimPrefix(caller.Username, "user_"))
+		if err0 != nil {
+			return roles, fmt.Errorf("getRoles >> %s", err0.Error())
+		}
+
+		// only verified users can own or buy
+		if user.Status == 1 {
+			roles = append(roles, Access_Owner, Access_Buyer)
+		}
+	}
+
+	if caller.Role == Role_Bank && caller.Bank != "" {
+
+		active, err1 := s.isActiveBank(ctx, caller)
+		if err1 != nil {
+			return roles, fmt.Errorf("getRoles >> %s", err1.Error())
+		}
+
+		if active {
+			roles = append(roles, Access_Bank)
+		}
+	}
+
+	return roles, nil
+}
+
+func (s *SmartContract) authorizeSuperAdmin(ctx contractapi.TransactionContextInterface, fname string) (Caller, error) {
+
+	caller, err0 := s.getCaller(ctx)
+	if err0 != nil {
+		return Caller{}, &Access_Error{Code: AccessCode_Unauthenticated, Function: fname, Message: err0.Error()}
+	}
+
+	if caller.Role != Role_SuperAdmin || caller.Username != "admin_super" {
+		return Caller{}, &Access_Error{
+			Code:     AccessCode_Forbidden,
+			Function: fname,
+			Caller:   caller.EnrollmentID,
+			Allowed:  []string{Access_SuperAdmin},
+			Message:  "Caller is not the super admin",
+		}
+	}
+
+	return caller, nil
+}
+
+// only the sub-registrar of officeCode
+func (s *SmartContract) authorizeSubRegistrar(ctx contractapi.TransactionContextInterface, fname string, officeCode string) (Caller, error) {
+
+	caller, err0 := s.getCaller(ctx)
+	if err0 != nil {
+		return Caller{}, &Access_Error{Code: AccessCode_Unauthenticated, Function: fname, Message: err0.Error()}
+	}
+
+	if caller.Role != Role_SubRegistrar || caller.Office == "" || caller.Username != "admin_"+caller.Office {
+		return Caller{}, &Access_Error{
+			Code:     AccessCode_Forbidden,
+			Function: fname,
+			Caller:   caller.EnrollmentID,
+			Allowed:  []string{Access_SubRegistrar},
+			Message:  "Caller is not a sub-registrar",
+		}
+	}
+
+	if caller.Office != officeCode {
+		return Caller{}, &Access_Error{
+			Code:     AccessCode_OutOfJurisdiction,
+			Function: fname,
+			Caller:   caller.EnrollmentID,
+			Allowed:  []string{Access_SubRegistrar},
+			Message:  fmt.Sprintf("Caller is sub-registrar of %s, not of %s", caller.Office, officeCode),
+		}
+	}
+
+	return caller, nil
+}
+
+// either the super admin or the sub-registrar of officeCode,
+// empty officeCode allows the sub-registrar of any office
+func (s *SmartContract) authorizeAdmin(ctx contractapi.TransactionContextInterface, fname string, officeCode string) (Caller, error) {
+
+	caller, err0 := s.getCaller(ctx)
+	if err0 != nil {
+		return Caller{}, &Access_Error{Code: AccessCode_Unauthenticated, Function: fname, Message: err0.Error()}
+	}
+
+	if caller.Role == Role_SuperAdmin && caller.Username == "admin_super" {
+		return caller, nil
+	}
+
+	if officeCode == "" {
+		officeCode = caller.Office
+	}
+
+	return s.authorizeSubRegistrar(ctx, fname, officeCode)
+}
+
+// identity of an active bank's org, caller.Bank is the bank it acts for
+func (s *SmartContract) authorizeBank(ctx contractapi.TransactionContextInterface, fname string) (Caller, error) {
+
+	caller, err0 := s.getCaller(ctx)
+	if err0 != nil {
+		return Caller{}, &Access_Error{Code: AccessCode_Unauthenticated, Function: fname, Message: err0.Error()}
+	}
+
+	active := false
+	if caller.Role == Role_Bank && caller.Bank != "" {
+		var err1 error
+		active, err1 = s.isActiveBank(ctx, caller)
+		if err1 != nil {
+			return Caller{}, fmt.Errorf("%s >> %s", fname, err1.Error())
+		}
+	}
+
+	if !active {
+		return Caller{}, &Access_Error{
+			Code:     AccessCode_Forbidden,
+			Function: fname,
+			Caller:   caller.EnrollmentID,
+			Allowed:  []string{Access_Bank},
+			Message:  "Caller is not an identity of an active bank",
+		}
+	}
+
+	return caller, nil
+}
+
+// bank of the caller is registered, active and issued the caller's identity
+func (s *SmartContract) isActiveBank(ctx contractapi.TransactionContextInterface, caller Caller) (bool, error) {
+
+	repo := newRepository(ctx)
+
+	exists, err0 := repo.BankExists(caller.Bank)
+	if err0 != nil || !exists {
+		return false, err0
+	}
+
+	bank, err1 := repo.GetBank(caller.Bank)
+	if err1 != nil {
+		return false, err1
+	}
+
+	return bank.Active && bank.MSPID == caller.MSPID, nil
+}
+
+// verified user, returns uid of the caller
+func (s *SmartContract) authorizeUser(ctx contractapi.TransactionContextInterface, fname string) (Caller, string, error) {
+
+	caller, err0 := s.getCaller(ctx)
+	if err0 != nil {
+		return Caller{}, "", &Access_Error{Code: AccessCode_Unauthenticated, Function: fname, Message: err0.Error()}
+	}
+
+	roles, err1 := s.getRoles(ctx, caller)
+	if err1 != nil {
+		return Caller{}, "", fmt.Errorf("%s >> %s", fname, err1.Error())
+	}
+
+	if searchArray(roles, Access_Buyer) == -1 {
+		return Caller{}, "", &Access_Error{
+			Code:     AccessCode_Forbidden,
+			Function: fname,
+			Caller:   caller.EnrollmentID,
+			Allowed:  []string{Access_Owner, Access_Buyer},
+			Message:  "Caller is not a verified user",
+		}
+	}
+
+	return caller, strings.TrimPrefix(caller.Username, "user_"), nil
+}
+
+// verified user owning a share of the estate, returns uid of the caller
+func (s *SmartContract) authorizeOwner(ctx contractapi.TransactionContextInterface, fname string, estate *Estate) (Caller, string, error) {
+
+	caller, uid, err0 := s.authorizeUser(ctx, fname)
+	if err0 != nil {
+		return Caller{}, "", err0
+	}
+
+	if !isOwner(estate, uid) {
+		return Caller{}, "", &Access_Error{
+			Code:     AccessCode_NotOwner,
+			Function: fname,
+			Caller:   caller.EnrollmentID,
+			Allowed:  []string{Access_Owner},
+			Message:  fmt.Sprintf("Estate is not owned by %s", uid),
+		}
+	}
+
+	return caller, uid, nil
+}
+
+// admin of the office or one of the parties (uids) of a record
+func (s *SmartContract) authorizeParty(ctx contractapi.TransactionContextInterface, fname string, officeCode string, parties ...string) (Caller, error) {
+
+	caller, err0 := s.getCaller(ctx)
+	if err0 != nil {
+		return Caller{}, &Access_Error{Code: AccessCode_Unauthenticated, Function: fname, Message: err0.Error()}
+	}
+
+	roles, err1 := s.getRoles(ctx, caller)
+	if err1 != nil {
+		return Caller{}, fmt.Errorf("%s >> %s", fname, err1.Error())
+	}
+
+	if searchArray(roles, Access_SuperAdmin) != -1 {
+		return caller, nil
+	}
+
+	if searchArray(roles, Access_SubRegistrar) != -1 && caller.Office == officeCode {
+		return caller, nil
+	}
+
+	if searchArray(roles, Access_Buyer) != -1 && searchArray(parties, strings.TrimPrefix(caller.Username, "user_")) != -1 {
+		return caller, nil
+	}
+
+	return Caller{}, &Access_Error{
+		Code:     AccessCode_NotOwner,
+		Function: fname,
+		Caller:   caller.EnrollmentID,
+		Allowed:  []string{Access_SuperAdmin, Access_SubRegistrar, Access_Owner, Access_Buyer},
+		Message:  fmt.Sprintf("Caller is neither a party nor an admin of office %s", officeCode),
+	}
+}
+
+// logs out of jurisdiction attempts and returns the error as is, a failed
+// transaction writes nothing to the ledger, so the attempt is kept in the
+// chaincode log of every endorsing peer instead
+func (s *SmartContract) recordViolation(ctx contractapi.TransactionContextInterface, err error, serveyNo string, officeCode string) error {
+
+	accessErr, ok := err.(*Access_Error)
+	if !ok || accessErr.Code != AccessCode_OutOfJurisdiction {
+		return err
+	}
+
+	caller, err0 := s.getCaller(ctx)
+	if err0 != nil {
+		return err
+	}
+
+	txDateTime, err1 := getTxDateTime(ctx)
+	if err1 != nil {
+		return err
+	}
+
+	data := Jurisdiction_Violation{
+		Function:     accessErr.Function,
+		Caller:       caller.EnrollmentID,
+		CallerOffice: caller.Office,
+		OfficeCode:   officeCode,
+		ServeyNo:     serveyNo,
+		DateTime:     txDateTime,
+	}
+
+	marshaled_data, _ := json.Marshal(data)
+	log.Printf("Jurisdiction violation in transaction %s: %s", ctx.GetStub().GetTxID(), string(marshaled_data))
+
+	return err
+}
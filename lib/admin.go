@@ -1,486 +1,802 @@
 package lib
 
 import (
-	"encoding/json"
 	"fmt"
-	"strconv"
-	"time"
 
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 )
 
 // For Admin super
 
-func (s *SmartContract) CreateOrModify_Admin(ctx contractapi.TransactionContextInterface, _username string, _password string, officeCode string, newAdminPassword string, uid string, name string) error {
-
-	verified, err0 := s.verifyPassword(ctx, _username, _password)
+func (s *SmartContract) CreateOrModify_Admin(ctx contractapi.TransactionContextInterface, officeCode string, uid string, name string) error {
 
+	_, err0 := s.authorizeSuperAdmin(ctx, "CreateOrModify_Admin")
 	if err0 != nil {
-		return fmt.Errorf("verifyPassword >> Verify password %s", err0.Error())
-	} else if !verified {
-		return fmt.Errorf("CreateOrModify_Admin >> Password Missmatched for %s", _username)
+		return err0
+	}
+
+	credentials, err1 := getCredentials(ctx, "CreateOrModify_Admin")
+	if err1 != nil {
+		return err1
+	}
+
+	newAdminPassword := credentials.NewPassword
+	if len(newAdminPassword) < minPasswordLength {
+		return fmt.Errorf("CreateOrModify_Admin >> New password must have at least %d characters", minPasswordLength)
 	}
 
 	//=====================================
 
-	key := "admin_" + officeCode
+	repo := newRepository(ctx)
+
+	hashed, err2 := hashPassword(ctx, "admin_"+officeCode, newAdminPassword)
+	if err2 != nil {
+		return fmt.Errorf("CreateOrModify_Admin >> %s", err2.Error())
+	}
+
+	// password set by super admin, has to be changed on first login
 	data := Admin_OfficeCode{
-		Password:  newAdminPassword,
-		UID:       uid,
-		Name:      name,
-		ToApprove: []string{},
+		Password:           hashed,
+		MustChangePassword: true,
+		UID:                uid,
+		Name:               name,
+		ToApprove:          []Approval_Ref{},
 	}
 
-	marshaled_data, _ := json.Marshal(data)
-	err1 := ctx.GetStub().PutState(key, marshaled_data)
-	if err1 != nil {
-		return fmt.Errorf("CreateOrModify_Admin >> Failed to put to world state. %s", err1.Error())
+	eventType := EventType_AdminCreated
+
+	// get existing admin, if any
+	exists, err3 := repo.AdminExists(officeCode)
+	if err3 != nil {
+		return fmt.Errorf("CreateOrModify_Admin >> %s", err3.Error())
 	}
+
+	if exists {
+		admin, err4 := repo.GetAdmin(officeCode)
+		if err4 != nil {
+			return fmt.Errorf("CreateOrModify_Admin >> %s", err4.Error())
+		}
+
+		// keep pending approvals of the office
+		data.ToApprove = admin.ToApprove
+		eventType = EventType_AdminModified
+
+		// new admin has to bind own identity with Migrate_Identity
+		if admin.Identity.EnrollmentID != "" {
+			err5 := repo.DelIdentity(admin.Identity)
+			if err5 != nil {
+				return fmt.Errorf("CreateOrModify_Admin >> %s", err5.Error())
+			}
+		}
+	}
+
+	err6 := repo.PutAdmin(officeCode, &data)
+	if err6 != nil {
+		return fmt.Errorf("CreateOrModify_Admin >> %s", err6.Error())
+	}
+
+	addEvent(ctx, eventType, Admin_Event{
+		OfficeCode: officeCode,
+		UID:        uid,
+		Name:       name,
+	})
+
 	return nil
 }
 
-// For Admin
+// percent of the price owed to the other party when an accepted sale is
+// withdrawn, 0 for no penalty
+func (s *SmartContract) Set_CancellationPenalty(ctx contractapi.TransactionContextInterface, percent int) error {
 
-func (s *SmartContract) Modify_User(ctx contractapi.TransactionContextInterface, uid string, name string, status int) (User, error) {
+	_, err0 := s.authorizeSuperAdmin(ctx, "Set_CancellationPenalty")
+	if err0 != nil {
+		return err0
+	}
 
-	key := "user" + "_" + uid
-	user := new(User)
+	if percent < 0 || percent > 100 {
+		return fmt.Errorf("Set_CancellationPenalty >> percent must be between 0 and 100")
+	}
 
-	// get user data
-	dataAsBytes, err1 := ctx.GetStub().GetState(key)
+	repo := newRepository(ctx)
 
+	config, err1 := repo.GetConfig()
 	if err1 != nil {
-		return User{}, fmt.Errorf("Modify_User >> Failed to read from world state. %s", err1.Error())
+		return fmt.Errorf("Set_CancellationPenalty >> %s", err1.Error())
 	}
 
-	if dataAsBytes == nil {
-		return User{}, fmt.Errorf("Modify_User >> %s does not exist", key)
-	}
+	config.CancellationPenalty = percent
 
-	err2 := json.Unmarshal(dataAsBytes, &user)
+	err2 := repo.PutConfig(config)
 	if err2 != nil {
-		return User{}, fmt.Errorf("Modify_User >> Can't Unmarshal Data")
+		return fmt.Errorf("Set_CancellationPenalty >> %s", err2.Error())
 	}
 
-	//=====================================
-	temp_status := user.Status
-	if status != -1 {
-		temp_status = status
+	addEvent(ctx, EventType_ConfigChanged, *config)
+
+	return nil
+}
+
+// percent of the shares sold that has to consent before a sale of a co-owned
+// estate is submitted, 0 for all co-owners
+func (s *SmartContract) Set_ConsentThreshold(ctx contractapi.TransactionContextInterface, percent int) error {
+
+	_, err0 := s.authorizeSuperAdmin(ctx, "Set_ConsentThreshold")
+	if err0 != nil {
+		return err0
 	}
-	data := User{
-		Password:  user.Password,
-		Name:      name,
-		UID:       user.UID,
-		Status:    temp_status,
-		Owned:     user.Owned,
-		Requested: user.Requested,
+
+	if percent < 0 || percent > 100 {
+		return fmt.Errorf("Set_ConsentThreshold >> percent must be between 0 and 100")
 	}
 
-	marshaled_data, _ := json.Marshal(data)
-	err3 := ctx.GetStub().PutState(key, marshaled_data)
-	if err3 != nil {
-		return User{}, fmt.Errorf("Modify_User >> Failed to put to world state. %s", err3.Error())
+	repo := newRepository(ctx)
+
+	config, err1 := repo.GetConfig()
+	if err1 != nil {
+		return fmt.Errorf("Set_ConsentThreshold >> %s", err1.Error())
 	}
 
-	return data, nil
+	config.ConsentThreshold = percent
+
+	err2 := repo.PutConfig(config)
+	if err2 != nil {
+		return fmt.Errorf("Set_ConsentThreshold >> %s", err2.Error())
+	}
+
+	addEvent(ctx, EventType_ConfigChanged, *config)
+
+	return nil
 }
 
-func (s *SmartContract) Create_Estate(ctx contractapi.TransactionContextInterface, officeCode string, serveyNo string, owner string, location string, area int, purchasedOn string, transactionsCount int) (Estate, error) {
+// days a succession can be objected to after the heirs are declared, 0 for
+// no objection period
+func (s *SmartContract) Set_ObjectionDays(ctx contractapi.TransactionContextInterface, days int) error {
 
-	key := "estate" + "_" + serveyNo
-	temp_dateTime, _ := time.Parse(time.RFC3339, purchasedOn) // purchasedOn => 2021-12-15T20:34:33+05:30
-	data := Estate{
-		Owner:             owner,
-		OfficeCode:        officeCode,
-		Location:          location,
-		Area:              area,
-		Status:            0,
-		PurchasedOn:       temp_dateTime,
-		SaleAvailability:  false,
-		TransactionsCount: transactionsCount,
-		Requests:          []Request{},
-		BeingSold:         false,
+	_, err0 := s.authorizeSuperAdmin(ctx, "Set_ObjectionDays")
+	if err0 != nil {
+		return err0
 	}
 
-	marshaled_data, _ := json.Marshal(data)
-	err1 := ctx.GetStub().PutState(key, marshaled_data)
-	if err1 != nil {
-		return Estate{}, fmt.Errorf("Create_Estate >> Failed to put to world state. %s", err1.Error())
+	if days < 0 {
+		return fmt.Errorf("Set_ObjectionDays >> days can not be negative")
 	}
 
-	//=====================================
+	repo := newRepository(ctx)
 
-	// get data
-	key = "user" + "_" + owner
-	dataAsBytes, err2 := ctx.GetStub().GetState(key)
+	config, err1 := repo.GetConfig()
+	if err1 != nil {
+		return fmt.Errorf("Set_ObjectionDays >> %s", err1.Error())
+	}
+
+	config.ObjectionDays = days
 
+	err2 := repo.PutConfig(config)
 	if err2 != nil {
-		return data, fmt.Errorf("Create_Estate >> Failed to read from world state. %s", err2.Error())
+		return fmt.Errorf("Set_ObjectionDays >> %s", err2.Error())
 	}
 
-	if dataAsBytes == nil {
-		return data, fmt.Errorf("Create_Estate >> %s does not exist", key)
+	addEvent(ctx, EventType_ConfigChanged, *config)
+
+	return nil
+}
+
+// stamp duty of gift deeds, outside and within the family, see gift.go
+func (s *SmartContract) Set_GiftStampDuty(ctx contractapi.TransactionContextInterface, duty int, familyDuty int) error {
+
+	_, err0 := s.authorizeSuperAdmin(ctx, "Set_GiftStampDuty")
+	if err0 != nil {
+		return err0
 	}
 
-	user := new(User)
-	err3 := json.Unmarshal(dataAsBytes, &user)
-	if err3 != nil {
-		return data, fmt.Errorf("GetValue >> Can't Unmarshal Data")
+	if duty < 0 || familyDuty < 0 {
+		return fmt.Errorf("Set_GiftStampDuty >> stamp duty can not be negative")
 	}
 
-	temp_owned := user.Owned
-	i := searchArray(temp_owned, serveyNo)
-	if i != -1 {
-		return data, fmt.Errorf("Create_Estate >> User alredy own estate with serveyNo: %s", serveyNo)
+	repo := newRepository(ctx)
+
+	config, err1 := repo.GetConfig()
+	if err1 != nil {
+		return fmt.Errorf("Set_GiftStampDuty >> %s", err1.Error())
 	}
 
-	temp_owned = append(temp_owned, serveyNo)
-	user.Owned = temp_owned
+	config.GiftStampDuty = duty
+	config.FamilyGiftStampDuty = familyDuty
 
-	key2 := "user" + "_" + owner
-	marshaled_data2, _ := json.Marshal(user)
-	err4 := ctx.GetStub().PutState(key2, marshaled_data2)
-	if err4 != nil {
-		return Estate{}, fmt.Errorf("Create_Estate >> Failed to put to world state. %s", err4.Error())
+	err2 := repo.PutConfig(config)
+	if err2 != nil {
+		return fmt.Errorf("Set_GiftStampDuty >> %s", err2.Error())
 	}
 
-	return data, nil
+	addEvent(ctx, EventType_ConfigChanged, *config)
+
+	return nil
 }
 
-func (s *SmartContract) Modify_Estate(ctx contractapi.TransactionContextInterface, officeCode string, serveyNo string, location string, area int, purchasedOn string, transactionsCount int) (Estate, error) {
+// For Admin
 
-	// get data
-	key := "estate" + "_" + serveyNo
-	dataAsBytes, err1 := ctx.GetStub().GetState(key)
+// name is read from the transient map, see privacy.go
+func (s *SmartContract) Modify_User(ctx contractapi.TransactionContextInterface, uid string, status int) (User, error) {
+
+	repo := newRepository(ctx)
 
+	// get user data
+	user, err1 := repo.GetUser(uid)
 	if err1 != nil {
-		return Estate{}, fmt.Errorf("Modify_Estate >> Failed to read from world state. %s", err1.Error())
+		return User{}, fmt.Errorf("Modify_User >> %s", err1.Error())
 	}
 
-	if dataAsBytes == nil {
-		return Estate{}, fmt.Errorf("Modify_Estate >> %s does not exist", key)
+	// admins of the registering office, legacy users have none
+	caller, err0 := s.authorizeAdmin(ctx, "Modify_User", user.OfficeCode)
+	if err0 != nil {
+		return User{}, err0
 	}
 
-	estate := new(Estate)
-	err2 := json.Unmarshal(dataAsBytes, &estate)
-	if err2 != nil {
-		return Estate{}, fmt.Errorf("GetValue >> Can't Unmarshal Data")
+	private, err3 := getPrivate(ctx, "Modify_User")
+	if err3 != nil {
+		return User{}, err3
+	}
+
+	if private.Name == "" {
+		return User{}, fmt.Errorf("Modify_User >> name is required")
+	}
+
+	// only RegisterDeath_User marks users deceased, for good
+	if status != -1 && user.Status == UserStatus_Deceased {
+		return User{}, fmt.Errorf("Modify_User >> Status of %s can not be changed to or from deceased", uid)
+	}
+
+	if status != -1 {
+		err6 := checkUserStatus("Modify_User", uid, status)
+		if err6 != nil {
+			return User{}, err6
+		}
+	}
+
+	// legacy users have no registering office, the office of the
+	// sub-registrar modifying them keeps the name from now on
+	if user.OfficeCode == "" {
+		if caller.Office == "" {
+			return User{}, fmt.Errorf("Modify_User >> %s has no registering office, it has to be modified by a sub-registrar", uid)
+		}
+		user.OfficeCode = caller.Office
 	}
 
 	//=====================================
 
-	var temp_dateTime time.Time
-	if location == "" {
-		location = estate.Location
+	userKey, err4 := repo.UserKey(uid)
+	if err4 != nil {
+		return User{}, fmt.Errorf("Modify_User >> %s", err4.Error())
 	}
-	if area == -1 {
-		area = estate.Area
+
+	nameHash, err5 := s.putPrivateData(ctx, user.OfficeCode, userKey, Private_Name{Name: private.Name, Salt: private.Salt}, uid)
+	if err5 != nil {
+		return User{}, fmt.Errorf("Modify_User >> %s", err5.Error())
 	}
-	if purchasedOn == "" {
-		temp_dateTime = estate.PurchasedOn
-	} else {
-		temp_dateTime, _ = time.Parse(time.RFC3339, purchasedOn) // purchasedOn => 2021-12-15T20:34:33+05:30
+
+	// legacy name is not kept public
+	user.Name = ""
+	user.NameHash = nameHash
+	if status != -1 {
+		user.Status = status
 	}
-	if transactionsCount == -1 {
-		transactionsCount = estate.TransactionsCount
+
+	err2 := repo.PutUser(uid, user)
+	if err2 != nil {
+		return User{}, fmt.Errorf("Modify_User >> %s", err2.Error())
+	}
+
+	return *user, nil
+}
+
+func (s *SmartContract) Create_Estate(ctx contractapi.TransactionContextInterface, officeCode string, serveyNo string, owner string, location string, area int, purchasedOn string, transactionsCount int) (Estate, error) {
+
+	_, err0 := s.authorizeAdmin(ctx, "Create_Estate", officeCode)
+	if err0 != nil {
+		return Estate{}, err0
+	}
+
+	repo := newRepository(ctx)
+
+	exists, err1 := repo.EstateExists(serveyNo)
+	if err1 != nil {
+		return Estate{}, fmt.Errorf("Create_Estate >> %s", err1.Error())
+	} else if exists {
+		return Estate{}, fmt.Errorf("Create_Estate >> Estate with serveyNo %s already exists", serveyNo)
+	}
+
+	estateKey, err7 := repo.EstateKey(serveyNo)
+	if err7 != nil {
+		return Estate{}, fmt.Errorf("Create_Estate >> %s", err7.Error())
+	}
+
+	// purchasedOn is only given for estates bought before they are registered here
+	temp_dateTime, err2 := s.parseHistoricalDate(ctx, estateKey, "purchasedOn", purchasedOn)
+	if err2 != nil {
+		return Estate{}, fmt.Errorf("Create_Estate >> %s", err2.Error())
+	}
+
+	if purchasedOn == "" {
+		temp_dateTime, err2 = getTxDateTime(ctx)
+		if err2 != nil {
+			return Estate{}, fmt.Errorf("Create_Estate >> %s", err2.Error())
+		}
 	}
 
 	data := Estate{
-		Owner:             estate.Owner,
+		Owner:             owner,
+		Owners:            []Share{{UID: owner, Percent: 100}},
 		OfficeCode:        officeCode,
 		Location:          location,
 		Area:              area,
-		Status:            estate.Status,
 		PurchasedOn:       temp_dateTime,
-		SaleAvailability:  estate.SaleAvailability,
 		TransactionsCount: transactionsCount,
-		Requests:          estate.Requests,
-		BeingSold:         estate.BeingSold,
+		Requests:          []Request{},
 	}
 
-	marshaled_data, _ := json.Marshal(data)
-	err3 := ctx.GetStub().PutState(key, marshaled_data)
+	err6 := transitionEstate(ctx, "Create_Estate", serveyNo, &data, Action_Create)
+	if err6 != nil {
+		return Estate{}, err6
+	}
+
+	err3 := repo.PutEstate(serveyNo, &data)
 	if err3 != nil {
-		return Estate{}, fmt.Errorf("Modify_Estate >> Failed to put to world state. %s", err3.Error())
+		return Estate{}, fmt.Errorf("Create_Estate >> %s", err3.Error())
 	}
 
-	return data, nil
-}
+	//=====================================
 
-func (s *SmartContract) Add_Transaction(ctx contractapi.TransactionContextInterface, serveyNo string, num int, seller string, buyer string, reason string, proposedPrice int, tDateTime string, officeCode string, approvedBy string, aDateTime string) (Transaction, error) {
-	key := "transaction" + "_" + serveyNo + "_" + strconv.Itoa(num)
+	// add to owner's owned
+	user, err4 := repo.GetUser(owner)
+	if err4 != nil {
+		return data, fmt.Errorf("Create_Estate >> %s", err4.Error())
+	}
 
-	temp_tDateTime, _ := time.Parse(time.RFC3339, tDateTime)
-	temp_aDateTime, _ := time.Parse(time.RFC3339, aDateTime)
-	data := Transaction{
-		Seller:              seller,
-		Buyer:               buyer,
-		TransactionDateTime: temp_tDateTime,
-		OfficeCode:          officeCode,
-		ApprovedBy:          approvedBy,
-		ApprovedDateTime:    temp_aDateTime,
-		Price:               proposedPrice,
-		Reason:              reason,
+	i := searchArray(user.Owned, serveyNo)
+	if i != -1 {
+		return data, fmt.Errorf("Create_Estate >> User alredy own estate with serveyNo: %s", serveyNo)
 	}
 
-	marshaled_data0, _ := json.Marshal(data)
-	err0 := ctx.GetStub().PutState(key, marshaled_data0)
-	if err0 != nil {
-		return Transaction{}, fmt.Errorf("Add_Transaction >> failed to put to world state. %s", err0.Error())
+	user.Owned = append(user.Owned, serveyNo)
+
+	err5 := repo.PutUser(owner, user)
+	if err5 != nil {
+		return Estate{}, fmt.Errorf("Create_Estate >> %s", err5.Error())
 	}
 
 	return data, nil
 }
 
-func (s *SmartContract) ApproveSell_Estate(ctx contractapi.TransactionContextInterface, _username string, serveyNo string, dateTime string) (Estate, error) {
+func (s *SmartContract) Modify_Estate(ctx contractapi.TransactionContextInterface, officeCode string, serveyNo string, location string, area int, purchasedOn string, transactionsCount int) (Estate, error) {
 
-	// get data of estate
-	key1 := "estate" + "_" + serveyNo
-	dataAsBytes0, err1 := ctx.GetStub().GetState(key1)
+	repo := newRepository(ctx)
 
+	// get data
+	estate, err1 := repo.GetEstate(serveyNo)
 	if err1 != nil {
-		return Estate{}, fmt.Errorf("ApproveSell_Estate >> Failed to read from world state. %s", err1.Error())
+		return Estate{}, fmt.Errorf("Modify_Estate >> %s", err1.Error())
+	}
+
+	// admin of current office, and of new office if it is moved
+	_, err0 := s.authorizeAdmin(ctx, "Modify_Estate", estate.OfficeCode)
+	if err0 != nil {
+		return Estate{}, err0
 	}
 
-	if dataAsBytes0 == nil {
-		return Estate{}, fmt.Errorf("ApproveSell_Estate >> %s does not exist", key1)
+	// empty keeps the office, like the other fields
+	if officeCode == "" {
+		officeCode = estate.OfficeCode
 	}
 
-	estate := new(Estate)
-	err2 := json.Unmarshal(dataAsBytes0, &estate)
+	_, err2 := s.authorizeAdmin(ctx, "Modify_Estate", officeCode)
 	if err2 != nil {
-		return Estate{}, fmt.Errorf("ApproveSell_Estate >> Can't Unmarshal Data")
+		return Estate{}, err2
 	}
 
-	// get data of transaction
-	key2 := "transaction" + "_" + serveyNo + "_" + strconv.Itoa(estate.TransactionsCount+1)
-	dataAsBytes1, err2 := ctx.GetStub().GetState(key2)
+	err5 := transitionEstate(ctx, "Modify_Estate", serveyNo, estate, Action_Modify)
+	if err5 != nil {
+		return Estate{}, err5
+	}
 
-	if err2 != nil {
-		return Estate{}, fmt.Errorf("ApproveSell_Estate >> Failed to read from world state. %s", err2.Error())
+	//=====================================
+
+	estate.OfficeCode = officeCode
+	if location != "" {
+		estate.Location = location
+	}
+	if area != -1 {
+		estate.Area = area
 	}
+	if purchasedOn != "" {
+		estateKey, err6 := repo.EstateKey(serveyNo)
+		if err6 != nil {
+			return Estate{}, fmt.Errorf("Modify_Estate >> %s", err6.Error())
+		}
 
-	if dataAsBytes1 == nil {
-		return Estate{}, fmt.Errorf("ApproveSell_Estate >> %s does not exist", key2)
+		temp_dateTime, err3 := s.parseHistoricalDate(ctx, estateKey, "purchasedOn", purchasedOn)
+		if err3 != nil {
+			return Estate{}, fmt.Errorf("Modify_Estate >> %s", err3.Error())
+		}
+		estate.PurchasedOn = temp_dateTime
+	}
+	if transactionsCount != -1 {
+		estate.TransactionsCount = transactionsCount
 	}
 
-	transaction := new(Transaction)
-	err3 := json.Unmarshal(dataAsBytes1, &transaction)
-	if err3 != nil {
-		return Estate{}, fmt.Errorf("ApproveSell_Estate >> Can't Unmarshal Data")
+	err4 := repo.PutEstate(serveyNo, estate)
+	if err4 != nil {
+		return Estate{}, fmt.Errorf("Modify_Estate >> %s", err4.Error())
 	}
 
-	// update approvedBy in transaction
-	key3 := _username
-	dataAsBytes2, err3 := ctx.GetStub().GetState(key3)
+	return *estate, nil
+}
 
-	if err3 != nil {
-		return Estate{}, fmt.Errorf("ApproveSell_Estate >> Failed to read from world state. %s", err3.Error())
+// old deed of an estate, one of the transactions counted in its
+// transactionsCount that is not recorded yet. officeCode must be the estate's
+// office. Price is read from the transient map, see privacy.go
+func (s *SmartContract) Add_Transaction(ctx contractapi.TransactionContextInterface, serveyNo string, num int, seller string, buyer string, reason string, tDateTime string, officeCode string, approvedBy string, aDateTime string) (Transaction, error) {
+
+	repo := newRepository(ctx)
+
+	estate, err7 := repo.GetEstate(serveyNo)
+	if err7 != nil {
+		return Transaction{}, fmt.Errorf("Add_Transaction >> %s", err7.Error())
 	}
 
-	if dataAsBytes1 == nil {
-		return Estate{}, fmt.Errorf("ApproveSell_Estate >> %s does not exist", key3)
+	_, err0 := s.authorizeAdmin(ctx, "Add_Transaction", estate.OfficeCode)
+	if err0 != nil {
+		return Transaction{}, err0
 	}
 
-	admin := new(Admin_OfficeCode)
-	err4 := json.Unmarshal(dataAsBytes2, &admin)
-	if err4 != nil {
-		return Estate{}, fmt.Errorf("ApproveSell_Estate >> Can't Unmarshal Data")
+	if officeCode != estate.OfficeCode {
+		return Transaction{}, &Validation_Error{
+			Code:     ValidationCode_OfficeMismatch,
+			Function: "Add_Transaction",
+			ServeyNo: serveyNo,
+			Message:  fmt.Sprintf("Estate resides in %s, not in %s", estate.OfficeCode, officeCode),
+		}
 	}
 
-	temp_dateTime, _ := time.Parse(time.RFC3339, dateTime)
+	// the pending transaction is estate.TransactionsCount+1, recorded ones are never replaced
+	if num < 1 || num > estate.TransactionsCount {
+		return Transaction{}, fmt.Errorf("Add_Transaction >> num must be between 1 and %d, the transactions of the estate", estate.TransactionsCount)
+	}
 
-	transaction.ApprovedBy = admin.UID
-	transaction.ApprovedDateTime = temp_dateTime
+	exists, err8 := repo.TransactionExists(serveyNo, num)
+	if err8 != nil {
+		return Transaction{}, fmt.Errorf("Add_Transaction >> %s", err8.Error())
+	}
 
-	// update owner, purchasedOn of estate
-	estate.Owner = transaction.Buyer
-	estate.PurchasedOn = temp_dateTime
-	estate.SaleAvailability = false
-	estate.TransactionsCount++
-	estate.BeingSold = false
+	if exists {
+		return Transaction{}, fmt.Errorf("Add_Transaction >> Transaction %d of %s is already recorded", num, serveyNo)
+	}
 
-	// update transaction
-	marshaled_data0, _ := json.Marshal(estate)
-	err5 := ctx.GetStub().PutState(key1, marshaled_data0)
+	transactionKey, err5 := repo.TransactionKey(serveyNo, num)
 	if err5 != nil {
-		return Estate{}, fmt.Errorf("ApproveSell_Estate >> Failed to put to world state. %s", err5.Error())
+		return Transaction{}, fmt.Errorf("Add_Transaction >> %s", err5.Error())
 	}
 
-	// update estate
-	marshaled_data1, _ := json.Marshal(transaction)
-	err6 := ctx.GetStub().PutState(key2, marshaled_data1)
-	if err6 != nil {
-		return *estate, fmt.Errorf("ApproveSell_Estate >> Failed to put to world state. %s", err6.Error())
+	// transactions added here are old deeds, both dates are historical
+	if tDateTime == "" || aDateTime == "" {
+		return Transaction{}, fmt.Errorf("Add_Transaction >> transactionDateTime and approvedDateTime are required")
 	}
 
-	//=====================================
+	temp_tDateTime, err1 := s.parseHistoricalDate(ctx, transactionKey, "transactionDateTime", tDateTime)
+	if err1 != nil {
+		return Transaction{}, fmt.Errorf("Add_Transaction >> %s", err1.Error())
+	}
 
-	// remove estate from seller's owned
+	temp_aDateTime, err2 := s.parseHistoricalDate(ctx, transactionKey, "approvedDateTime", aDateTime)
+	if err2 != nil {
+		return Transaction{}, fmt.Errorf("Add_Transaction >> %s", err2.Error())
+	}
 
-	key4 := "user" + "_" + transaction.Seller
-	user0 := new(User)
+	if temp_aDateTime.Before(temp_tDateTime) {
+		return Transaction{}, fmt.Errorf("Add_Transaction >> Transaction can not be approved before it was made")
+	}
 
-	// get user0 data
-	dataAsBytes3, err7 := ctx.GetStub().GetState(key4)
+	private, err4 := getPrivate(ctx, "Add_Transaction")
+	if err4 != nil {
+		return Transaction{}, err4
+	}
 
-	if err7 != nil {
-		return *estate, fmt.Errorf("ApproveSell_Estate >> Failed to read from world state. %s", err7.Error())
+	priceHash, err6 := s.putPrice(ctx, estate.OfficeCode, transactionKey, Private_Price{Price: private.Price, Salt: private.Salt}, seller, buyer)
+	if err6 != nil {
+		return Transaction{}, fmt.Errorf("Add_Transaction >> %s", err6.Error())
 	}
 
-	if dataAsBytes3 == nil {
-		return *estate, fmt.Errorf("ApproveSell_Estate >> %s does not exist", key4)
+	data := Transaction{
+		Seller:              seller,
+		Buyer:               buyer,
+		TransactionDateTime: temp_tDateTime,
+		OfficeCode:          estate.OfficeCode,
+		ApprovedBy:          approvedBy,
+		ApprovedDateTime:    temp_aDateTime,
+		PriceHash:           priceHash,
+		Reason:              reason,
 	}
 
-	err8 := json.Unmarshal(dataAsBytes3, &user0)
-	if err8 != nil {
-		return *estate, fmt.Errorf("ApproveSell_Estate >> Can't Unmarshal Data")
+	err3 := repo.PutTransaction(serveyNo, num, &data)
+	if err3 != nil {
+		return Transaction{}, fmt.Errorf("Add_Transaction >> %s", err3.Error())
 	}
 
-	temp_owned0 := user0.Owned
-	i0 := searchArray(temp_owned0, serveyNo)
-	user0.Owned = append(temp_owned0[:i0], temp_owned0[i0+1:]...)
+	return data, nil
+}
+
+// ApproveSell_Estate and RejectSell_Estate can only be called by the sub-registrar
+// of the office where the estate resides. Attempts by the sub-registrar of another
+// office fail with an OUT_OF_JURISDICTION Access_Error and are logged as a
+// Jurisdiction_Violation.
+
+func (s *SmartContract) ApproveSell_Estate(ctx contractapi.TransactionContextInterface, serveyNo string) (Estate, error) {
+
+	repo := newRepository(ctx)
 
-	marshaled_data2, _ := json.Marshal(user0)
-	err9 := ctx.GetStub().PutState(key4, marshaled_data2)
-	if err9 != nil {
-		return *estate, fmt.Errorf("ApproveSell_Estate >> Failed to put to world state. %s", err9.Error())
+	// get data of estate
+	estate, err0 := repo.GetEstate(serveyNo)
+	if err0 != nil {
+		return Estate{}, fmt.Errorf("ApproveSell_Estate >> %s", err0.Error())
 	}
 
-	// add estate to buyer's owned
+	// only sub-registrar of the estate's office
+	_, err1 := s.authorizeSubRegistrar(ctx, "ApproveSell_Estate", estate.OfficeCode)
+	if err1 != nil {
+		return Estate{}, s.recordViolation(ctx, err1, serveyNo, estate.OfficeCode)
+	}
 
-	key5 := "user" + "_" + transaction.Buyer
-	user1 := new(User)
+	//=====================================
+	// every precondition is checked before anything is written
 
-	// get user1 data
-	dataAsBytes4, err10 := ctx.GetStub().GetState(key5)
+	num := estate.TransactionsCount + 1
+	transaction, admin, err2 := s.getPendingTransaction(ctx, "ApproveSell_Estate", serveyNo, estate)
+	if err2 != nil {
+		return Estate{}, err2
+	}
 
-	if err10 != nil {
-		return *estate, fmt.Errorf("ApproveSell_Estate >> Failed to read from world state. %s", err10.Error())
+	err3 := transitionEstate(ctx, "ApproveSell_Estate", serveyNo, estate, Action_Approve)
+	if err3 != nil {
+		return Estate{}, err3
 	}
 
-	if dataAsBytes4 == nil {
-		return *estate, fmt.Errorf("ApproveSell_Estate >> %s does not exist", key5)
+	// liens registered since the sale was accepted
+	err13 := checkLiens(ctx, "ApproveSell_Estate", serveyNo, transaction.Buyer)
+	if err13 != nil {
+		return Estate{}, err13
 	}
 
-	err11 := json.Unmarshal(dataAsBytes4, &user1)
-	if err11 != nil {
-		return *estate, fmt.Errorf("ApproveSell_Estate >> Can't Unmarshal Data")
+	// sellers still own the shares sold
+	owners, err4 := transferShares(estateOwners(estate), saleShares(transaction), transaction.Buyer)
+	if err4 != nil {
+		return Estate{}, &Validation_Error{
+			Code:     ValidationCode_NotOwned,
+			Function: "ApproveSell_Estate",
+			ServeyNo: serveyNo,
+			Message:  err4.Error(),
+		}
+	}
+
+	// sellers left without a share
+	sellers := []string{}
+	sellers_data := []*User{}
+
+	for _, share := range saleShares(transaction) {
+		if searchShare(owners, share.UID) != -1 {
+			continue
+		}
+
+		seller, err12 := repo.GetUser(share.UID)
+		if err12 != nil {
+			return Estate{}, fmt.Errorf("ApproveSell_Estate >> %s", err12.Error())
+		}
+
+		owned, found := removeString(seller.Owned, serveyNo)
+		if !found {
+			return Estate{}, &Validation_Error{
+				Code:     ValidationCode_NotOwned,
+				Function: "ApproveSell_Estate",
+				ServeyNo: serveyNo,
+				Message:  fmt.Sprintf("Estate is not owned by seller %s", share.UID),
+			}
+		}
+
+		seller.Owned = owned
+		sellers = append(sellers, share.UID)
+		sellers_data = append(sellers_data, seller)
+	}
+
+	// buyer is verified
+	buyer, err5 := repo.GetUser(transaction.Buyer)
+	if err5 != nil {
+		return Estate{}, fmt.Errorf("ApproveSell_Estate >> %s", err5.Error())
 	}
 
-	temp_owned1 := user1.Owned
-	user1.Owned = append(temp_owned1, serveyNo)
+	if buyer.Status != 1 {
+		return Estate{}, &Validation_Error{
+			Code:     ValidationCode_NotVerified,
+			Function: "ApproveSell_Estate",
+			ServeyNo: serveyNo,
+			Message:  fmt.Sprintf("Buyer %s is not verified", transaction.Buyer),
+		}
+	}
+
+	toApprove, _ := removeApproval(admin.ToApprove, serveyNo, num)
 
-	marshaled_data3, _ := json.Marshal(user1)
-	err12 := ctx.GetStub().PutState(key5, marshaled_data3)
-	if err12 != nil {
-		return *estate, fmt.Errorf("ApproveSell_Estate >> Failed to put to world state. %s", err12.Error())
+	temp_dateTime, err6 := getTxDateTime(ctx)
+	if err6 != nil {
+		return Estate{}, fmt.Errorf("ApproveSell_Estate >> %s", err6.Error())
 	}
 
 	//=====================================
+
+	// update approvedBy in transaction
+	transaction.ApprovedBy = admin.UID
+	transaction.ApprovedDateTime = temp_dateTime
+
+	// update owners, purchasedOn of estate
+	estate.Owners = owners
+	estate.Owner = managingOwner(owners, estate.Owner)
+	estate.PurchasedOn = temp_dateTime
+	estate.TransactionsCount++
+
+	// add estate to buyer's owned, buyer may already be a co-owner
+	if searchArray(buyer.Owned, serveyNo) == -1 {
+		buyer.Owned = append(buyer.Owned, serveyNo)
+	}
+
 	// remove from admin toApprove
+	admin.ToApprove = toApprove
 
-	temp_toApprove := admin.ToApprove
-	i1 := searchArray(temp_toApprove, key2)
-	admin.ToApprove = append(temp_toApprove[:i1], temp_toApprove[i1+1:]...)
+	err7 := repo.PutEstate(serveyNo, estate)
+	if err7 != nil {
+		return Estate{}, fmt.Errorf("ApproveSell_Estate >> %s", err7.Error())
+	}
 
-	marshaled_data4, _ := json.Marshal(admin)
-	err13 := ctx.GetStub().PutState(key3, marshaled_data4)
-	if err13 != nil {
-		return *estate, fmt.Errorf("ApproveSell_Estate >> failed to put to world state. %s", err13.Error())
+	err8 := repo.PutTransaction(serveyNo, num, transaction)
+	if err8 != nil {
+		return Estate{}, fmt.Errorf("ApproveSell_Estate >> %s", err8.Error())
+	}
+
+	for i, uid := range sellers {
+		err9 := repo.PutUser(uid, sellers_data[i])
+		if err9 != nil {
+			return Estate{}, fmt.Errorf("ApproveSell_Estate >> %s", err9.Error())
+		}
+	}
+
+	err10 := repo.PutUser(transaction.Buyer, buyer)
+	if err10 != nil {
+		return Estate{}, fmt.Errorf("ApproveSell_Estate >> %s", err10.Error())
+	}
+
+	err11 := repo.PutAdmin(estate.OfficeCode, admin)
+	if err11 != nil {
+		return Estate{}, fmt.Errorf("ApproveSell_Estate >> %s", err11.Error())
 	}
 
+	addEvent(ctx, EventType_SaleApproved, Transaction_Event{
+		ServeyNo:         serveyNo,
+		TransactionCount: num,
+		Transaction:      *transaction,
+	})
+
 	return *estate, nil
 }
 
-func (s *SmartContract) RejectSell_Estate(ctx contractapi.TransactionContextInterface, _username string, serveyNo string) (Estate, error) {
-
-	// get estate data
+func (s *SmartContract) RejectSell_Estate(ctx contractapi.TransactionContextInterface, serveyNo string) (Estate, error) {
 
-	key1 := "estate" + "_" + serveyNo
-	dataAsBytes1, err1 := ctx.GetStub().GetState(key1)
+	repo := newRepository(ctx)
 
-	if err1 != nil {
-		return Estate{}, fmt.Errorf("RejectSell_Estate >> Failed to read from world state. %s", err1.Error())
+	// get estate data
+	estate, err0 := repo.GetEstate(serveyNo)
+	if err0 != nil {
+		return Estate{}, fmt.Errorf("RejectSell_Estate >> %s", err0.Error())
 	}
 
-	if dataAsBytes1 == nil {
-		return Estate{}, fmt.Errorf("RejectSell_Estate >> %s does not exist", key1)
+	// only sub-registrar of the estate's office
+	_, err1 := s.authorizeSubRegistrar(ctx, "RejectSell_Estate", estate.OfficeCode)
+	if err1 != nil {
+		return Estate{}, s.recordViolation(ctx, err1, serveyNo, estate.OfficeCode)
 	}
 
-	estate := new(Estate)
-	err2 := json.Unmarshal(dataAsBytes1, &estate)
+	num := estate.TransactionsCount + 1
+	transaction, admin, err2 := s.getPendingTransaction(ctx, "RejectSell_Estate", serveyNo, estate)
 	if err2 != nil {
-		return Estate{}, fmt.Errorf("RejectSell_Estate >> Can't Unmarshal Data")
+		return Estate{}, err2
 	}
 
-	// get transaction data
-
-	key2 := "transaction" + "_" + serveyNo + "_" + strconv.Itoa(estate.TransactionsCount+1)
-	dataAsBytes2, err3 := ctx.GetStub().GetState(key2)
-
+	// back to listed, the owner can accept another request, or to the state
+	// a share transfer or gift started from
+	err3 := revertEstate(ctx, "RejectSell_Estate", serveyNo, estate, Action_Reject, transaction.FromState)
 	if err3 != nil {
-		return Estate{}, fmt.Errorf("RejectSell_Estate >> Failed to read from world state. %s", err3.Error())
+		return Estate{}, err3
 	}
 
-	if dataAsBytes2 == nil {
-		return Estate{}, fmt.Errorf("RejectSell_Estate >> %s does not exist", key2)
-	}
+	admin.ToApprove, _ = removeApproval(admin.ToApprove, serveyNo, num)
+
+	//=====================================
 
-	transaction := new(Transaction)
-	err4 := json.Unmarshal(dataAsBytes2, &transaction)
+	err4 := repo.PutEstate(serveyNo, estate)
 	if err4 != nil {
-		return Estate{}, fmt.Errorf("RejectSell_Estate >> Can't Unmarshal Data")
+		return Estate{}, fmt.Errorf("RejectSell_Estate >> %s", err4.Error())
 	}
 
-	// get admin data
-
-	key3 := "admin" + "_" + estate.OfficeCode
-	dataAsBytes3, err5 := ctx.GetStub().GetState(key3)
+	// delete transaction
 
+	err5 := repo.DelTransaction(serveyNo, num)
 	if err5 != nil {
-		return Estate{}, fmt.Errorf("RejectSell_Estate >> Failed to read from world state. %s", err5.Error())
+		return Estate{}, fmt.Errorf("RejectSell_Estate >> %s", err5.Error())
 	}
 
-	if dataAsBytes3 == nil {
-		return Estate{}, fmt.Errorf("RejectSell_Estate >> %s does not exist", key3)
-	}
+	// remove from admin toApprove
 
-	admin := new(Admin_OfficeCode)
-	err6 := json.Unmarshal(dataAsBytes3, &admin)
+	err6 := repo.PutAdmin(estate.OfficeCode, admin)
 	if err6 != nil {
-		return Estate{}, fmt.Errorf("RejectSell_Estate >> Can't Unmarshal Data")
+		return Estate{}, fmt.Errorf("RejectSell_Estate >> %s", err6.Error())
 	}
 
-	// change beingSold
+	addEvent(ctx, EventType_SaleRejected, Transaction_Event{
+		ServeyNo:         serveyNo,
+		TransactionCount: num,
+		Transaction:      *transaction,
+	})
+
+	return *estate, nil
 
-	estate.BeingSold = false
+}
 
-	marshaled_data1, _ := json.Marshal(estate)
-	err7 := ctx.GetStub().PutState(key1, marshaled_data1)
-	if err7 != nil {
-		return *estate, fmt.Errorf("RejectSell_Estate >> failed to put to world state. %s", err7.Error())
+// ------------------------------------
+
+// Helper Functions - Private
+
+// transaction accepted by the owner and not approved yet, with the admin
+// record of the estate's office, which holds it in ToApprove once submitted
+func (s *SmartContract) getPendingTransaction(ctx contractapi.TransactionContextInterface, fname string, serveyNo string, estate *Estate) (*Transaction, *Admin_OfficeCode, error) {
+
+	repo := newRepository(ctx)
+	num := estate.TransactionsCount + 1
+
+	notPending := &Validation_Error{
+		Code:     ValidationCode_NotPending,
+		Function: fname,
+		ServeyNo: serveyNo,
+		Message:  fmt.Sprintf("Transaction %d is not waiting for approval", num),
 	}
 
-	// delete transaction
+	if estate.State != State_UnderContract && estate.State != State_PendingRegistration {
+		return nil, nil, notPending
+	}
 
-	err8 := ctx.GetStub().DelState(key2)
-	if err8 != nil {
-		return *estate, fmt.Errorf("RejectSell_Estate >> failed to delete from world state. %s", err8.Error())
+	transaction, err0 := repo.GetTransaction(serveyNo, num)
+	if err0 != nil {
+		return nil, nil, fmt.Errorf("%s >> %s", fname, err0.Error())
 	}
 
-	// remove from admin toApprove
+	if transaction.ApprovedBy != "" {
+		return nil, nil, notPending
+	}
 
-	temp_toApprove := admin.ToApprove
-	i := searchArray(temp_toApprove, key2)
-	admin.ToApprove = append(temp_toApprove[:i], temp_toApprove[i+1:]...)
+	if transaction.OfficeCode != estate.OfficeCode {
+		return nil, nil, &Validation_Error{
+			Code:     ValidationCode_OfficeMismatch,
+			Function: fname,
+			ServeyNo: serveyNo,
+			Message:  fmt.Sprintf("Transaction is registered in %s but estate resides in %s", transaction.OfficeCode, estate.OfficeCode),
+		}
+	}
 
-	marshaled_data2, _ := json.Marshal(admin)
-	err9 := ctx.GetStub().PutState(key3, marshaled_data2)
-	if err9 != nil {
-		return *estate, fmt.Errorf("RejectSell_Estate >> failed to put to world state. %s", err9.Error())
+	admin, err1 := repo.GetAdmin(estate.OfficeCode)
+	if err1 != nil {
+		return nil, nil, fmt.Errorf("%s >> %s", fname, err1.Error())
 	}
 
-	return *estate, nil
+	if estate.State == State_PendingRegistration && searchApproval(admin.ToApprove, serveyNo, num) == -1 {
+		return nil, nil, &Validation_Error{
+			Code:     ValidationCode_MissingEntry,
+			Function: fname,
+			ServeyNo: serveyNo,
+			Message:  fmt.Sprintf("Transaction %d is not in toApprove of %s", num, estate.OfficeCode),
+		}
+	}
 
+	return transaction, admin, nil
 }
@@ -0,0 +1,249 @@
+package lib
+
+import (
+	"crypto/x509"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+)
+
+// client identity without a certificate, getCaller reads hf.EnrollmentID
+type testIdentity struct {
+	mspID string
+	attrs map[string]string
+}
+
+func (i *testIdentity) GetID() (string, error) {
+	return i.attrs["hf.EnrollmentID"], nil
+}
+
+func (i *testIdentity) GetMSPID() (string, error) {
+	return i.mspID, nil
+}
+
+func (i *testIdentity) GetAttributeValue(attrName string) (string, bool, error) {
+	value, found := i.attrs[attrName]
+	return value, found, nil
+}
+
+func (i *testIdentity) AssertAttributeValue(attrName string, attrValue string) error {
+	if i.attrs[attrName] != attrValue {
+		return errors.New("attribute " + attrName + " does not match")
+	}
+	return nil
+}
+
+func (i *testIdentity) GetX509Certificate() (*x509.Certificate, error) {
+	return nil, nil
+}
+
+// context of one transaction on stub, by the identity with the given attributes
+func newTestContext(stub *shimtest.MockStub, attrs map[string]string) *TransactionContext {
+
+	ctx := new(TransactionContext)
+	ctx.SetStub(stub)
+	ctx.SetClientIdentity(&testIdentity{mspID: "Org1MSP", attrs: attrs})
+
+	return ctx
+}
+
+func snapshotState(stub *shimtest.MockStub) map[string]string {
+
+	state := map[string]string{}
+	for key, value := range stub.State {
+		state[key] = string(value)
+	}
+
+	return state
+}
+
+// estate 100 of PNE sold by u1 to u2, submitted and waiting for the
+// sub-registrar sr1, change edits the records before they are written
+func setupPendingSale(t *testing.T, change func(estate *Estate, transaction *Transaction, seller *User, buyer *User, admin *Admin_OfficeCode)) *shimtest.MockStub {
+
+	stub := shimtest.NewMockStub("registry", nil)
+	stub.MockTransactionStart("setup")
+	defer stub.MockTransactionEnd("setup")
+
+	repo := &Repository{stub: stub}
+
+	estate := &Estate{
+		DocType:           "estate",
+		Owner:             "u1",
+		Owners:            []Share{{UID: "u1", Percent: 100}},
+		OfficeCode:        "PNE",
+		State:             State_PendingRegistration,
+		TransactionsCount: 0,
+		Requests:          []Request{},
+	}
+	transaction := &Transaction{
+		Seller:     "u1",
+		Buyer:      "u2",
+		OfficeCode: "PNE",
+		Shares:     []Share{{UID: "u1", Percent: 100}},
+		Consents:   []string{"u1"},
+	}
+	seller := &User{UID: "u1", Status: 1, Owned: []string{"100"}, Requested: []Request_Buyer{}}
+	buyer := &User{UID: "u2", Status: 1, Owned: []string{}, Requested: []Request_Buyer{}}
+	admin := &Admin_OfficeCode{
+		UID:       "9",
+		ToApprove: []Approval_Ref{{ServeyNo: "100", TransactionCount: 1}},
+		Identity:  Identity{MSPID: "Org1MSP", EnrollmentID: "sr1"},
+	}
+
+	if change != nil {
+		change(estate, transaction, seller, buyer, admin)
+	}
+
+	for _, err := range []error{
+		repo.PutEstate("100", estate),
+		repo.PutTransaction("100", 1, transaction),
+		repo.PutUser("u1", seller),
+		repo.PutUser("u2", buyer),
+		repo.PutAdmin("PNE", admin),
+		repo.PutIdentity(admin.Identity, "admin_PNE"),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return stub
+}
+
+var subRegistrarPNE = map[string]string{"hf.EnrollmentID": "sr1", "role": Role_SubRegistrar, "office": "PNE"}
+
+func TestApproveSellEstate(t *testing.T) {
+
+	stub := setupPendingSale(t, nil)
+
+	stub.MockTransactionStart("approve")
+	estate, err := new(SmartContract).ApproveSell_Estate(newTestContext(stub, subRegistrarPNE), "100")
+	stub.MockTransactionEnd("approve")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if estate.State != State_Registered || estate.Owner != "u2" || estate.TransactionsCount != 1 {
+		t.Errorf("estate is %s owned by %s after %d transactions", estate.State, estate.Owner, estate.TransactionsCount)
+	}
+
+	repo := &Repository{stub: stub}
+
+	seller, _ := repo.GetUser("u1")
+	buyer, _ := repo.GetUser("u2")
+	admin, _ := repo.GetAdmin("PNE")
+	transaction, _ := repo.GetTransaction("100", 1)
+
+	if len(seller.Owned) != 0 {
+		t.Errorf("seller still owns %v", seller.Owned)
+	}
+	if !reflect.DeepEqual(buyer.Owned, []string{"100"}) {
+		t.Errorf("buyer owns %v", buyer.Owned)
+	}
+	if len(admin.ToApprove) != 0 {
+		t.Errorf("toApprove is %v", admin.ToApprove)
+	}
+	if transaction.ApprovedBy != "9" {
+		t.Errorf("transaction is approved by %q", transaction.ApprovedBy)
+	}
+}
+
+func TestApproveSellEstatePreconditions(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		change func(estate *Estate, transaction *Transaction, seller *User, buyer *User, admin *Admin_OfficeCode)
+		code   string
+	}{
+		{
+			name: "not submitted",
+			change: func(estate *Estate, transaction *Transaction, seller *User, buyer *User, admin *Admin_OfficeCode) {
+				estate.State = State_Listed
+			},
+			code: ValidationCode_NotPending,
+		},
+		{
+			name: "already approved",
+			change: func(estate *Estate, transaction *Transaction, seller *User, buyer *User, admin *Admin_OfficeCode) {
+				transaction.ApprovedBy = "9"
+			},
+			code: ValidationCode_NotPending,
+		},
+		{
+			name: "other office",
+			change: func(estate *Estate, transaction *Transaction, seller *User, buyer *User, admin *Admin_OfficeCode) {
+				transaction.OfficeCode = "MUM"
+			},
+			code: ValidationCode_OfficeMismatch,
+		},
+		{
+			name: "not in toApprove",
+			change: func(estate *Estate, transaction *Transaction, seller *User, buyer *User, admin *Admin_OfficeCode) {
+				admin.ToApprove = []Approval_Ref{}
+			},
+			code: ValidationCode_MissingEntry,
+		},
+		{
+			name: "share sold twice",
+			change: func(estate *Estate, transaction *Transaction, seller *User, buyer *User, admin *Admin_OfficeCode) {
+				estate.Owners = []Share{{UID: "u1", Percent: 50}, {UID: "u3", Percent: 50}}
+			},
+			code: ValidationCode_NotOwned,
+		},
+		{
+			name: "missing from owned",
+			change: func(estate *Estate, transaction *Transaction, seller *User, buyer *User, admin *Admin_OfficeCode) {
+				seller.Owned = []string{}
+			},
+			code: ValidationCode_NotOwned,
+		},
+		{
+			name: "buyer not verified",
+			change: func(estate *Estate, transaction *Transaction, seller *User, buyer *User, admin *Admin_OfficeCode) {
+				buyer.Status = 2
+			},
+			code: ValidationCode_NotVerified,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			stub := setupPendingSale(t, test.change)
+			before := snapshotState(stub)
+
+			stub.MockTransactionStart("approve")
+			_, err := new(SmartContract).ApproveSell_Estate(newTestContext(stub, subRegistrarPNE), "100")
+			stub.MockTransactionEnd("approve")
+
+			validationErr, ok := err.(*Validation_Error)
+			if !ok {
+				t.Fatalf("got %v, want a Validation_Error", err)
+			}
+			if validationErr.Code != test.code {
+				t.Errorf("got %s, want %s", validationErr.Code, test.code)
+			}
+
+			// nothing is written before every precondition is checked
+			if !reflect.DeepEqual(snapshotState(stub), before) {
+				t.Error("state changed by a failed approval")
+			}
+		})
+	}
+}
+
+func TestApproveSellEstateOtherOffice(t *testing.T) {
+
+	stub := setupPendingSale(t, nil)
+
+	stub.MockTransactionStart("approve")
+	_, err := new(SmartContract).ApproveSell_Estate(newTestContext(stub, map[string]string{"hf.EnrollmentID": "sr1", "role": Role_SubRegistrar, "office": "MUM"}), "100")
+	stub.MockTransactionEnd("approve")
+
+	if _, ok := err.(*Access_Error); !ok {
+		t.Fatalf("got %v, want an Access_Error", err)
+	}
+}
@@ -0,0 +1,509 @@
+package lib
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Auction
+//
+// Instead of accepting a request, the owner of a listed estate can auction it.
+// Bidding starts with the transaction starting the auction and ends after
+// biddingHours, times are always taken from the transaction timestamp.
+//
+// Open auctions take bids with PlaceBid_Estate, each higher than the last.
+// Sealed auctions take commitments with CommitBid_Estate: the price and a salt
+// chosen by the bidder are passed in the transient map, only their hash is
+// public, the price itself is kept in the private data of the bidder's org.
+// After bidding ends, bidders have revealHours to reveal their bid with
+// RevealBid_Estate, unrevealed bids do not take part.
+//
+// SettleAuction_Estate can be called by anyone once the auction is over. The
+// highest bid at or above the reserve price of a verified bidder wins, the
+// earlier one on a tie, and the pending transaction is created and submitted
+// for registration like with AcceptRequest_Estate. Without a winner, or when
+// a lienholder did not consent to the sale to the winner, the auction is
+// closed and the estate is listed again.
+
+const (
+	AuctionType_Open   = "open"
+	AuctionType_Sealed = "sealed"
+)
+
+const (
+	AuctionStatus_Running = "running"
+	AuctionStatus_Settled = "settled"
+	AuctionStatus_Closed  = "closed"
+)
+
+func (s *SmartContract) StartAuction_Estate(ctx contractapi.TransactionContextInterface, serveyNo string, auctionType string, reservePrice int, biddingHours int, revealHours int) (Auction, error) {
+
+	repo := newRepository(ctx)
+
+	estate, err0 := repo.GetEstate(serveyNo)
+	if err0 != nil {
+		return Auction{}, fmt.Errorf("StartAuction_Estate >> %s", err0.Error())
+	}
+
+	// only owner can auction the estate
+	_, seller, err1 := s.authorizeOwner(ctx, "StartAuction_Estate", estate)
+	if err1 != nil {
+		return Auction{}, err1
+	}
+
+	if auctionType != AuctionType_Open && auctionType != AuctionType_Sealed {
+		return Auction{}, fmt.Errorf("StartAuction_Estate >> auctionType must be %s or %s", AuctionType_Open, AuctionType_Sealed)
+	}
+
+	if reservePrice < 0 {
+		return Auction{}, fmt.Errorf("StartAuction_Estate >> reservePrice can not be negative")
+	}
+
+	if biddingHours <= 0 {
+		return Auction{}, fmt.Errorf("StartAuction_Estate >> biddingHours must be greater than 0")
+	}
+
+	if auctionType == AuctionType_Sealed && revealHours <= 0 {
+		return Auction{}, fmt.Errorf("StartAuction_Estate >> revealHours must be greater than 0 for sealed auctions")
+	}
+
+	// no requests can be accepted while the auction runs
+	err2 := transitionEstate(ctx, "StartAuction_Estate", serveyNo, estate, Action_StartAuction)
+	if err2 != nil {
+		return Auction{}, err2
+	}
+
+	temp_dateTime, err3 := getTxDateTime(ctx)
+	if err3 != nil {
+		return Auction{}, fmt.Errorf("StartAuction_Estate >> %s", err3.Error())
+	}
+
+	auction := Auction{
+		ID:           ctx.GetStub().GetTxID(),
+		Type:         auctionType,
+		Seller:       seller,
+		ReservePrice: reservePrice,
+		StartsOn:     temp_dateTime,
+		EndsOn:       temp_dateTime.Add(time.Duration(biddingHours) * time.Hour),
+		Bids:         []Bid{},
+		Status:       AuctionStatus_Running,
+	}
+
+	if auctionType == AuctionType_Sealed {
+		auction.RevealEndsOn = auction.EndsOn.Add(time.Duration(revealHours) * time.Hour)
+	}
+
+	estate.Auction = auction.ID
+
+	//=====================================
+
+	err4 := repo.PutEstate(serveyNo, estate)
+	if err4 != nil {
+		return Auction{}, fmt.Errorf("StartAuction_Estate >> %s", err4.Error())
+	}
+
+	err5 := repo.PutAuction(serveyNo, &auction)
+	if err5 != nil {
+		return Auction{}, fmt.Errorf("StartAuction_Estate >> %s", err5.Error())
+	}
+
+	addEvent(ctx, EventType_AuctionStarted, Auction_Event{
+		ServeyNo: serveyNo,
+		Auction:  auction,
+	})
+
+	return auction, nil
+}
+
+// bid in an open auction, has to be higher than every bid before it
+func (s *SmartContract) PlaceBid_Estate(ctx contractapi.TransactionContextInterface, serveyNo string, price int) (Bid, error) {
+
+	_, bidder, auction, now, err0 := s.getAuctionBidder(ctx, "PlaceBid_Estate", serveyNo, AuctionType_Open)
+	if err0 != nil {
+		return Bid{}, err0
+	}
+
+	if !now.Before(auction.EndsOn) {
+		return Bid{}, fmt.Errorf("PlaceBid_Estate >> Bidding ended on %s", auction.EndsOn.Format(time.RFC3339))
+	}
+
+	if price < auction.ReservePrice {
+		return Bid{}, fmt.Errorf("PlaceBid_Estate >> Bid is below the reserve price %d", auction.ReservePrice)
+	}
+
+	for _, b := range auction.Bids {
+		if price <= b.Price {
+			return Bid{}, fmt.Errorf("PlaceBid_Estate >> Bid has to be higher than %d", b.Price)
+		}
+	}
+
+	bid := Bid{
+		Bidder:   bidder,
+		Price:    price,
+		DateTime: now,
+	}
+
+	auction.Bids = append(auction.Bids, bid)
+
+	err1 := newRepository(ctx).PutAuction(serveyNo, auction)
+	if err1 != nil {
+		return Bid{}, fmt.Errorf("PlaceBid_Estate >> %s", err1.Error())
+	}
+
+	addEvent(ctx, EventType_BidPlaced, Bid_Event{
+		ServeyNo:  serveyNo,
+		AuctionID: auction.ID,
+		Bid:       bid,
+	})
+
+	return bid, nil
+}
+
+// commits to a bid in a sealed auction, price and salt are read from the
+// transient map, committing again replaces the earlier commitment
+func (s *SmartContract) CommitBid_Estate(ctx contractapi.TransactionContextInterface, serveyNo string) (Bid, error) {
+
+	caller, bidder, auction, now, err0 := s.getAuctionBidder(ctx, "CommitBid_Estate", serveyNo, AuctionType_Sealed)
+	if err0 != nil {
+		return Bid{}, err0
+	}
+
+	if !now.Before(auction.EndsOn) {
+		return Bid{}, fmt.Errorf("CommitBid_Estate >> Bidding ended on %s", auction.EndsOn.Format(time.RFC3339))
+	}
+
+	private_bid, err1 := getBid(ctx, "CommitBid_Estate")
+	if err1 != nil {
+		return Bid{}, err1
+	}
+
+	if private_bid.Price <= 0 || private_bid.Salt == "" {
+		return Bid{}, fmt.Errorf("CommitBid_Estate >> %s must have a price greater than 0 and a salt", transientKey_Bid)
+	}
+
+	bid := Bid{
+		Bidder:   bidder,
+		Hash:     sealedBidHash(serveyNo, auction.ID, bidder, &private_bid),
+		DateTime: now,
+	}
+
+	index := searchBid(auction.Bids, bidder)
+	if index == -1 {
+		auction.Bids = append(auction.Bids, bid)
+	} else {
+		auction.Bids[index] = bid
+	}
+
+	//=====================================
+
+	repo := newRepository(ctx)
+
+	err2 := repo.PutPrivateBid(implicitCollection(caller.MSPID), serveyNo, auction.ID, bidder, &private_bid)
+	if err2 != nil {
+		return Bid{}, fmt.Errorf("CommitBid_Estate >> %s", err2.Error())
+	}
+
+	err3 := repo.PutAuction(serveyNo, auction)
+	if err3 != nil {
+		return Bid{}, fmt.Errorf("CommitBid_Estate >> %s", err3.Error())
+	}
+
+	addEvent(ctx, EventType_BidCommitted, Bid_Event{
+		ServeyNo:  serveyNo,
+		AuctionID: auction.ID,
+		Bid:       bid,
+	})
+
+	return bid, nil
+}
+
+// reveals the committed bid of the caller from the private data of its org,
+// has to be endorsed by a peer of that org
+func (s *SmartContract) RevealBid_Estate(ctx contractapi.TransactionContextInterface, serveyNo string) (Bid, error) {
+
+	caller, bidder, auction, now, err0 := s.getAuctionBidder(ctx, "RevealBid_Estate", serveyNo, AuctionType_Sealed)
+	if err0 != nil {
+		return Bid{}, err0
+	}
+
+	if now.Before(auction.EndsOn) || !now.Before(auction.RevealEndsOn) {
+		return Bid{}, fmt.Errorf("RevealBid_Estate >> Bids can be revealed from %s until %s", auction.EndsOn.Format(time.RFC3339), auction.RevealEndsOn.Format(time.RFC3339))
+	}
+
+	index := searchBid(auction.Bids, bidder)
+	if index == -1 {
+		return Bid{}, fmt.Errorf("RevealBid_Estate >> No bid found for given bidder: %s", bidder)
+	}
+
+	if auction.Bids[index].Revealed {
+		return Bid{}, fmt.Errorf("RevealBid_Estate >> Bid of %s is already revealed", bidder)
+	}
+
+	repo := newRepository(ctx)
+
+	private_bid, err1 := repo.GetPrivateBid(implicitCollection(caller.MSPID), serveyNo, auction.ID, bidder)
+	if err1 != nil {
+		return Bid{}, fmt.Errorf("RevealBid_Estate >> %s", err1.Error())
+	}
+
+	if sealedBidHash(serveyNo, auction.ID, bidder, private_bid) != auction.Bids[index].Hash {
+		return Bid{}, fmt.Errorf("RevealBid_Estate >> Bid of %s does not match its commitment", bidder)
+	}
+
+	auction.Bids[index].Price = private_bid.Price
+	auction.Bids[index].Revealed = true
+
+	err2 := repo.PutAuction(serveyNo, auction)
+	if err2 != nil {
+		return Bid{}, fmt.Errorf("RevealBid_Estate >> %s", err2.Error())
+	}
+
+	addEvent(ctx, EventType_BidRevealed, Bid_Event{
+		ServeyNo:  serveyNo,
+		AuctionID: auction.ID,
+		Bid:       auction.Bids[index],
+	})
+
+	return auction.Bids[index], nil
+}
+
+func (s *SmartContract) SettleAuction_Estate(ctx contractapi.TransactionContextInterface, serveyNo string) (Auction, error) {
+
+	repo := newRepository(ctx)
+
+	estate, err0 := repo.GetEstate(serveyNo)
+	if err0 != nil {
+		return Auction{}, fmt.Errorf("SettleAuction_Estate >> %s", err0.Error())
+	}
+
+	if estate.Auction == "" {
+		return Auction{}, fmt.Errorf("SettleAuction_Estate >> No auction is running for %s", serveyNo)
+	}
+
+	auction, err1 := repo.GetAuction(serveyNo, estate.Auction)
+	if err1 != nil {
+		return Auction{}, fmt.Errorf("SettleAuction_Estate >> %s", err1.Error())
+	}
+
+	now, err2 := getTxDateTime(ctx)
+	if err2 != nil {
+		return Auction{}, fmt.Errorf("SettleAuction_Estate >> %s", err2.Error())
+	}
+
+	endsOn := auction.EndsOn
+	if auction.Type == AuctionType_Sealed {
+		endsOn = auction.RevealEndsOn
+	}
+
+	if now.Before(endsOn) {
+		return Auction{}, fmt.Errorf("SettleAuction_Estate >> Auction can be settled from %s", endsOn.Format(time.RFC3339))
+	}
+
+	winner, err3 := s.getWinningBid(ctx, auction)
+	if err3 != nil {
+		return Auction{}, fmt.Errorf("SettleAuction_Estate >> %s", err3.Error())
+	}
+
+	// no sale of an encumbered estate without consent of the lienholder, the
+	// auction is closed as without a winner, it has no other way out
+	if winner != nil {
+		err12 := checkLiens(ctx, "SettleAuction_Estate", serveyNo, winner.Bidder)
+		if _, encumbered := err12.(*Validation_Error); encumbered {
+			winner = nil
+		} else if err12 != nil {
+			return Auction{}, err12
+		}
+	}
+
+	estate.Auction = ""
+
+	//=====================================
+
+	if winner == nil {
+
+		err4 := transitionEstate(ctx, "SettleAuction_Estate", serveyNo, estate, Action_CloseAuction)
+		if err4 != nil {
+			return Auction{}, err4
+		}
+
+		auction.Status = AuctionStatus_Closed
+
+		err5 := repo.PutEstate(serveyNo, estate)
+		if err5 != nil {
+			return Auction{}, fmt.Errorf("SettleAuction_Estate >> %s", err5.Error())
+		}
+
+		err6 := repo.PutAuction(serveyNo, auction)
+		if err6 != nil {
+			return Auction{}, fmt.Errorf("SettleAuction_Estate >> %s", err6.Error())
+		}
+
+		addEvent(ctx, EventType_AuctionClosed, Auction_Event{
+			ServeyNo: serveyNo,
+			Auction:  *auction,
+		})
+
+		return *auction, nil
+	}
+
+	err7 := transitionEstate(ctx, "SettleAuction_Estate", serveyNo, estate, Action_Settle)
+	if err7 != nil {
+		return Auction{}, err7
+	}
+
+	temp_transaction := Transaction{
+		Seller:              auction.Seller,
+		Buyer:               winner.Bidder,
+		TransactionDateTime: now,
+		OfficeCode:          estate.OfficeCode,
+		ApprovedBy:          "",
+		ApprovedDateTime:    time.Time{},
+		Reason:              "auction",
+		Shares:              estateOwners(estate),
+		Consents:            []string{auction.Seller},
+	}
+
+	// bids are public, so is the price
+	num, err8 := s.submitSale(ctx, "SettleAuction_Estate", serveyNo, estate, &temp_transaction, Private_Price{Price: winner.Price})
+	if err8 != nil {
+		return Auction{}, err8
+	}
+
+	auction.Status = AuctionStatus_Settled
+	auction.Winner = winner.Bidder
+	auction.Price = winner.Price
+
+	err9 := repo.PutAuction(serveyNo, auction)
+	if err9 != nil {
+		return Auction{}, fmt.Errorf("SettleAuction_Estate >> %s", err9.Error())
+	}
+
+	// request of the winner, if any, is not needed anymore
+	buyer_data, err10 := repo.GetUser(winner.Bidder)
+	if err10 != nil {
+		return Auction{}, fmt.Errorf("SettleAuction_Estate >> %s", err10.Error())
+	}
+
+	for i, r := range buyer_data.Requested {
+		if r.ServeyNo == serveyNo {
+			buyer_data.Requested = append(buyer_data.Requested[:i], buyer_data.Requested[i+1:]...)
+
+			err11 := repo.PutUser(winner.Bidder, buyer_data)
+			if err11 != nil {
+				return Auction{}, fmt.Errorf("SettleAuction_Estate >> %s", err11.Error())
+			}
+			break
+		}
+	}
+
+	addEvent(ctx, EventType_AuctionSettled, Transaction_Event{
+		ServeyNo:         serveyNo,
+		TransactionCount: num,
+		Transaction:      temp_transaction,
+	})
+
+	return *auction, nil
+}
+
+// ------------------------------------
+
+// Helper Functions - Private
+
+// running auction of the estate and the verified caller bidding in it
+func (s *SmartContract) getAuctionBidder(ctx contractapi.TransactionContextInterface, fname string, serveyNo string, auctionType string) (Caller, string, *Auction, time.Time, error) {
+
+	caller, bidder, err0 := s.authorizeUser(ctx, fname)
+	if err0 != nil {
+		return Caller{}, "", nil, time.Time{}, err0
+	}
+
+	repo := newRepository(ctx)
+
+	estate, err1 := repo.GetEstate(serveyNo)
+	if err1 != nil {
+		return Caller{}, "", nil, time.Time{}, fmt.Errorf("%s >> %s", fname, err1.Error())
+	}
+
+	if isOwner(estate, bidder) {
+		return Caller{}, "", nil, time.Time{}, fmt.Errorf("%s >> Estate is already owned by %s", fname, bidder)
+	}
+
+	// only estates in auction take bids
+	err2 := transitionEstate(ctx, fname, serveyNo, estate, Action_Bid)
+	if err2 != nil {
+		return Caller{}, "", nil, time.Time{}, err2
+	}
+
+	auction, err3 := repo.GetAuction(serveyNo, estate.Auction)
+	if err3 != nil {
+		return Caller{}, "", nil, time.Time{}, fmt.Errorf("%s >> %s", fname, err3.Error())
+	}
+
+	if auction.Type != auctionType {
+		return Caller{}, "", nil, time.Time{}, fmt.Errorf("%s >> Auction of %s is %s", fname, serveyNo, auction.Type)
+	}
+
+	now, err4 := getTxDateTime(ctx)
+	if err4 != nil {
+		return Caller{}, "", nil, time.Time{}, fmt.Errorf("%s >> %s", fname, err4.Error())
+	}
+
+	return caller, bidder, auction, now, nil
+}
+
+// highest bid at or above the reserve price of a verified bidder, the earlier
+// one on a tie, nil if there is none
+func (s *SmartContract) getWinningBid(ctx contractapi.TransactionContextInterface, auction *Auction) (*Bid, error) {
+
+	repo := newRepository(ctx)
+
+	var winner *Bid
+	for i, bid := range auction.Bids {
+
+		if auction.Type == AuctionType_Sealed && !bid.Revealed {
+			continue
+		}
+
+		if bid.Price < auction.ReservePrice {
+			continue
+		}
+
+		if winner != nil && (bid.Price < winner.Price || (bid.Price == winner.Price && !bid.DateTime.Before(winner.DateTime))) {
+			continue
+		}
+
+		bidder, err0 := repo.GetUser(bid.Bidder)
+		if err0 != nil {
+			return nil, err0
+		}
+
+		// suspended since bidding
+		if bidder.Status != 1 {
+			continue
+		}
+
+		winner = &auction.Bids[i]
+	}
+
+	return winner, nil
+}
+
+func sealedBidHash(serveyNo string, auctionID string, bidder string, bid *Private_Bid) string {
+
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%s\x00%s\x00%d\x00%s", serveyNo, auctionID, bidder, bid.Price, bid.Salt)))
+
+	return hex.EncodeToString(hash[:])
+}
+
+func searchBid(arr []Bid, bidder string) int {
+	for i, b := range arr {
+		if b.Bidder == bidder {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,339 @@
+package lib
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Encumbrance
+//
+// Banks registered by the super admin with CreateOrModify_Bank register
+// mortgages and liens on estates, modify them and release them once the loan
+// is repaid. A sale of an estate with an active lien is neither accepted nor
+// approved, unless the lienholder consented to the sale to that buyer with
+// ConsentSale_Lien. Released liens are kept, GetEncumbranceCertificate lists
+// the liens active at any time in a date range.
+
+const (
+	LienKind_Mortgage = "mortgage"
+	LienKind_Lien     = "lien"
+)
+
+// bank and the org its identities are issued by, an inactive bank can not act
+func (s *SmartContract) CreateOrModify_Bank(ctx contractapi.TransactionContextInterface, bankCode string, name string, mspID string, active bool) (Bank, error) {
+
+	_, err0 := s.authorizeSuperAdmin(ctx, "CreateOrModify_Bank")
+	if err0 != nil {
+		return Bank{}, err0
+	}
+
+	if bankCode == "" || mspID == "" {
+		return Bank{}, fmt.Errorf("CreateOrModify_Bank >> bankCode and mspID are required")
+	}
+
+	data := Bank{
+		Code:   bankCode,
+		Name:   name,
+		MSPID:  mspID,
+		Active: active,
+	}
+
+	err1 := newRepository(ctx).PutBank(bankCode, &data)
+	if err1 != nil {
+		return Bank{}, fmt.Errorf("CreateOrModify_Bank >> %s", err1.Error())
+	}
+
+	addEvent(ctx, EventType_BankModified, data)
+
+	return data, nil
+}
+
+func (s *SmartContract) Register_Lien(ctx contractapi.TransactionContextInterface, serveyNo string, kind string, amount int, reference string) (Lien, error) {
+
+	caller, err0 := s.authorizeBank(ctx, "Register_Lien")
+	if err0 != nil {
+		return Lien{}, err0
+	}
+
+	if kind != LienKind_Mortgage && kind != LienKind_Lien {
+		return Lien{}, fmt.Errorf("Register_Lien >> kind must be %s or %s", LienKind_Mortgage, LienKind_Lien)
+	}
+
+	if amount <= 0 {
+		return Lien{}, fmt.Errorf("Register_Lien >> amount must be greater than 0")
+	}
+
+	repo := newRepository(ctx)
+
+	exists, err1 := repo.EstateExists(serveyNo)
+	if err1 != nil {
+		return Lien{}, fmt.Errorf("Register_Lien >> %s", err1.Error())
+	} else if !exists {
+		return Lien{}, fmt.Errorf("Register_Lien >> Estate with serveyNo %s does not exist", serveyNo)
+	}
+
+	temp_dateTime, err2 := getTxDateTime(ctx)
+	if err2 != nil {
+		return Lien{}, fmt.Errorf("Register_Lien >> %s", err2.Error())
+	}
+
+	data := Lien{
+		ID:           ctx.GetStub().GetTxID(),
+		Kind:         kind,
+		Lienholder:   caller.Bank,
+		Amount:       amount,
+		Reference:    reference,
+		RegisteredBy: caller.EnrollmentID,
+		RegisteredOn: temp_dateTime,
+	}
+
+	err3 := repo.PutLien(serveyNo, &data)
+	if err3 != nil {
+		return Lien{}, fmt.Errorf("Register_Lien >> %s", err3.Error())
+	}
+
+	addEvent(ctx, EventType_LienRegistered, Lien_Event{
+		ServeyNo: serveyNo,
+		Lien:     data,
+	})
+
+	return data, nil
+}
+
+// amount -1 and empty reference keep the current values
+func (s *SmartContract) Modify_Lien(ctx contractapi.TransactionContextInterface, serveyNo string, lienID string, amount int, reference string) (Lien, error) {
+
+	lien, err0 := s.getLienOfCaller(ctx, "Modify_Lien", serveyNo, lienID)
+	if err0 != nil {
+		return Lien{}, err0
+	}
+
+	if amount != -1 {
+		if amount <= 0 {
+			return Lien{}, fmt.Errorf("Modify_Lien >> amount must be greater than 0")
+		}
+		lien.Amount = amount
+	}
+	if reference != "" {
+		lien.Reference = reference
+	}
+
+	temp_dateTime, err1 := getTxDateTime(ctx)
+	if err1 != nil {
+		return Lien{}, fmt.Errorf("Modify_Lien >> %s", err1.Error())
+	}
+
+	lien.ModifiedOn = temp_dateTime
+
+	err2 := newRepository(ctx).PutLien(serveyNo, lien)
+	if err2 != nil {
+		return Lien{}, fmt.Errorf("Modify_Lien >> %s", err2.Error())
+	}
+
+	addEvent(ctx, EventType_LienModified, Lien_Event{
+		ServeyNo: serveyNo,
+		Lien:     *lien,
+	})
+
+	return *lien, nil
+}
+
+func (s *SmartContract) Release_Lien(ctx contractapi.TransactionContextInterface, serveyNo string, lienID string) (Lien, error) {
+
+	lien, err0 := s.getLienOfCaller(ctx, "Release_Lien", serveyNo, lienID)
+	if err0 != nil {
+		return Lien{}, err0
+	}
+
+	temp_dateTime, err1 := getTxDateTime(ctx)
+	if err1 != nil {
+		return Lien{}, fmt.Errorf("Release_Lien >> %s", err1.Error())
+	}
+
+	lien.ReleasedOn = temp_dateTime
+	lien.ConsentedBuyer = ""
+
+	err2 := newRepository(ctx).PutLien(serveyNo, lien)
+	if err2 != nil {
+		return Lien{}, fmt.Errorf("Release_Lien >> %s", err2.Error())
+	}
+
+	addEvent(ctx, EventType_LienReleased, Lien_Event{
+		ServeyNo: serveyNo,
+		Lien:     *lien,
+	})
+
+	return *lien, nil
+}
+
+// consent of the lienholder to a sale of the estate to buyer, the lien stays
+// on the estate, empty buyer withdraws the consent
+func (s *SmartContract) ConsentSale_Lien(ctx contractapi.TransactionContextInterface, serveyNo string, lienID string, buyer string) (Lien, error) {
+
+	lien, err0 := s.getLienOfCaller(ctx, "ConsentSale_Lien", serveyNo, lienID)
+	if err0 != nil {
+		return Lien{}, err0
+	}
+
+	repo := newRepository(ctx)
+
+	if buyer != "" {
+		exists, err1 := repo.UserExists(buyer)
+		if err1 != nil {
+			return Lien{}, fmt.Errorf("ConsentSale_Lien >> %s", err1.Error())
+		} else if !exists {
+			return Lien{}, fmt.Errorf("ConsentSale_Lien >> User %s does not exist", buyer)
+		}
+	}
+
+	lien.ConsentedBuyer = buyer
+
+	err2 := repo.PutLien(serveyNo, lien)
+	if err2 != nil {
+		return Lien{}, fmt.Errorf("ConsentSale_Lien >> %s", err2.Error())
+	}
+
+	addEvent(ctx, EventType_LienConsented, Lien_Event{
+		ServeyNo: serveyNo,
+		Lien:     *lien,
+	})
+
+	return *lien, nil
+}
+
+// liens active at any time between from and to, RFC3339 dates, empty for no
+// limit
+func (s *SmartContract) GetEncumbranceCertificate(ctx contractapi.TransactionContextInterface, serveyNo string, from string, to string) (Encumbrance_Certificate, error) {
+
+	certificate := Encumbrance_Certificate{ServeyNo: serveyNo, Liens: []Lien{}}
+
+	var err0 error
+	if from != "" {
+		certificate.From, err0 = time.Parse(time.RFC3339, from)
+		if err0 != nil {
+			return certificate, fmt.Errorf("GetEncumbranceCertificate >> from is not a valid RFC3339 date. %s", err0.Error())
+		}
+	}
+	if to != "" {
+		certificate.To, err0 = time.Parse(time.RFC3339, to)
+		if err0 != nil {
+			return certificate, fmt.Errorf("GetEncumbranceCertificate >> to is not a valid RFC3339 date. %s", err0.Error())
+		}
+	}
+
+	repo := newRepository(ctx)
+
+	exists, err1 := repo.EstateExists(serveyNo)
+	if err1 != nil {
+		return certificate, fmt.Errorf("GetEncumbranceCertificate >> %s", err1.Error())
+	} else if !exists {
+		return certificate, fmt.Errorf("GetEncumbranceCertificate >> Estate with serveyNo %s does not exist", serveyNo)
+	}
+
+	liens, err2 := repo.GetLiens(serveyNo)
+	if err2 != nil {
+		return certificate, fmt.Errorf("GetEncumbranceCertificate >> %s", err2.Error())
+	}
+
+	for _, lien := range liens {
+		if !certificate.To.IsZero() && lien.RegisteredOn.After(certificate.To) {
+			continue
+		}
+		if !certificate.From.IsZero() && !lien.ReleasedOn.IsZero() && lien.ReleasedOn.Before(certificate.From) {
+			continue
+		}
+		certificate.Liens = append(certificate.Liens, lien)
+	}
+
+	certificate.IssuedOn, err0 = getTxDateTime(ctx)
+	if err0 != nil {
+		return certificate, fmt.Errorf("GetEncumbranceCertificate >> %s", err0.Error())
+	}
+
+	return certificate, nil
+}
+
+// ------------------------------------
+
+// Helper Functions - Private
+
+// active lien held by the caller's bank
+func (s *SmartContract) getLienOfCaller(ctx contractapi.TransactionContextInterface, fname string, serveyNo string, lienID string) (*Lien, error) {
+
+	caller, err0 := s.authorizeBank(ctx, fname)
+	if err0 != nil {
+		return nil, err0
+	}
+
+	lien, err1 := newRepository(ctx).GetLien(serveyNo, lienID)
+	if err1 != nil {
+		return nil, fmt.Errorf("%s >> %s", fname, err1.Error())
+	}
+
+	if lien.Lienholder != caller.Bank {
+		return nil, &Access_Error{
+			Code:     AccessCode_NotOwner,
+			Function: fname,
+			Caller:   caller.EnrollmentID,
+			Allowed:  []string{Access_Bank},
+			Message:  fmt.Sprintf("Lien %s is held by %s, not by %s", lienID, lien.Lienholder, caller.Bank),
+		}
+	}
+
+	if !lien.ReleasedOn.IsZero() {
+		return nil, fmt.Errorf("%s >> Lien %s was released on %s", fname, lienID, lien.ReleasedOn.Format(time.RFC3339))
+	}
+
+	return lien, nil
+}
+
+// fails while a lien is active on the estate and its lienholder did not
+// consent to the sale to buyer
+func checkLiens(ctx contractapi.TransactionContextInterface, fname string, serveyNo string, buyer string) error {
+
+	liens, err0 := newRepository(ctx).GetLiens(serveyNo)
+	if err0 != nil {
+		return fmt.Errorf("%s >> %s", fname, err0.Error())
+	}
+
+	for _, lien := range liens {
+		if !lien.ReleasedOn.IsZero() || lien.ConsentedBuyer == buyer {
+			continue
+		}
+
+		return &Validation_Error{
+			Code:     ValidationCode_Encumbered,
+			Function: fname,
+			ServeyNo: serveyNo,
+			Message:  fmt.Sprintf("Estate has a %s %s of %s, which did not consent to the sale to %s", lien.Kind, lien.ID, lien.Lienholder, buyer),
+		}
+	}
+
+	return nil
+}
+
+// fails while any lien is active on the estate, consented or not
+func checkUnencumbered(ctx contractapi.TransactionContextInterface, fname string, serveyNo string) error {
+
+	liens, err0 := newRepository(ctx).GetLiens(serveyNo)
+	if err0 != nil {
+		return fmt.Errorf("%s >> %s", fname, err0.Error())
+	}
+
+	for _, lien := range liens {
+		if !lien.ReleasedOn.IsZero() {
+			continue
+		}
+
+		return &Validation_Error{
+			Code:     ValidationCode_Encumbered,
+			Function: fname,
+			ServeyNo: serveyNo,
+			Message:  fmt.Sprintf("Estate has a %s %s of %s, it has to be released first", lien.Kind, lien.ID, lien.Lienholder),
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,131 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Events
+//
+// Fabric keeps only one event per transaction, so sub-events are collected in
+// the TransactionContext while the transaction runs and are emitted together
+// as one Event_Envelope, named "realEstate", after it succeeds.
+// Version is increased whenever a payload changes incompatibly.
+
+const (
+	Event_Name    = "realEstate"
+	Event_Version = 1
+)
+
+const (
+	EventType_RequestCreated      = "requestCreated"      // Request_Event
+	EventType_RequestUpdated      = "requestUpdated"      // Request_Event
+	EventType_RequestsCleared     = "requestsCleared"     // RequestsCleared_Event
+	EventType_RequestsExpired     = "requestsExpired"     // RequestsCleared_Event
+	EventType_OfferCountered      = "offerCountered"      // Offer_Event
+	EventType_OfferAccepted       = "offerAccepted"       // Offer_Event
+	EventType_RequestAccepted     = "requestAccepted"     // Transaction_Event
+	EventType_SaleConsented       = "saleConsented"       // Transaction_Event
+	EventType_ShareTransferred    = "shareTransferred"    // Transaction_Event
+	EventType_SaleApproved        = "saleApproved"        // Transaction_Event
+	EventType_SaleRejected        = "saleRejected"        // Transaction_Event
+	EventType_AuctionStarted      = "auctionStarted"      // Auction_Event
+	EventType_BidPlaced           = "bidPlaced"           // Bid_Event
+	EventType_BidCommitted        = "bidCommitted"        // Bid_Event
+	EventType_BidRevealed         = "bidRevealed"         // Bid_Event
+	EventType_AuctionSettled      = "auctionSettled"      // Transaction_Event
+	EventType_AuctionClosed       = "auctionClosed"       // Auction_Event
+	EventType_AcceptanceWithdrawn = "acceptanceWithdrawn" // Cancellation_Event
+	EventType_OfferWithdrawn      = "offerWithdrawn"      // Cancellation_Event
+	EventType_GiftOffered         = "giftOffered"         // Transaction_Event
+	EventType_GiftAccepted        = "giftAccepted"        // Transaction_Event
+	EventType_LeaseSubmitted      = "leaseSubmitted"      // Lease_Event
+	EventType_LeaseApproved       = "leaseApproved"       // Lease_Event
+	EventType_LeaseRejected       = "leaseRejected"       // Lease_Event
+	EventType_LeaseTerminated     = "leaseTerminated"     // Lease_Event
+	EventType_LeaseExpired        = "leaseExpired"        // Lease_Event
+	EventType_UserDeceased        = "userDeceased"        // Status_Event
+	EventType_HeirsDeclared       = "heirsDeclared"       // Succession_Event
+	EventType_SuccessionObjected  = "successionObjected"  // Succession_Event
+	EventType_ObjectionDismissed  = "objectionDismissed"  // Succession_Event
+	EventType_SuccessionApproved  = "successionApproved"  // Succession_Event
+	EventType_SuccessionRejected  = "successionRejected"  // Succession_Event
+	EventType_LienRegistered      = "lienRegistered"      // Lien_Event
+	EventType_LienModified        = "lienModified"        // Lien_Event
+	EventType_LienReleased        = "lienReleased"        // Lien_Event
+	EventType_LienConsented       = "lienConsented"       // Lien_Event
+	EventType_ConfigChanged       = "configChanged"       // Config
+	EventType_EstateVerified      = "estateVerified"      // Status_Event
+	EventType_EstateSuspended     = "estateSuspended"     // Status_Event
+	EventType_EstateStateChanged  = "estateStateChanged"  // Estate_State_Event
+	EventType_OwnersChanged       = "ownersChanged"       // Owners_Event
+	EventType_EstateSubdivided    = "estateSubdivided"    // Parcel_Event
+	EventType_EstatesAmalgamated  = "estatesAmalgamated"  // Parcel_Event
+	EventType_UserVerified        = "userVerified"        // Status_Event
+	EventType_AdminCreated        = "adminCreated"        // Admin_Event
+	EventType_AdminModified       = "adminModified"       // Admin_Event
+	EventType_BankModified        = "bankModified"        // Bank
+	EventType_DebugAudit          = "debugAudit"          // Debug_Audit
+)
+
+type TransactionContext struct {
+	contractapi.TransactionContext
+	events []Sub_Event
+}
+
+// ------------------------------------
+
+// contractapi hooks, not transactions themselves
+
+func (s *SmartContract) GetTransactionContextHandler() contractapi.SettableTransactionContextInterface {
+	return new(TransactionContext)
+}
+
+func (s *SmartContract) GetAfterTransaction() interface{} {
+	return flushEvents
+}
+
+// ------------------------------------
+
+// Helper Functions - Private
+
+func addEvent(ctx contractapi.TransactionContextInterface, eventType string, payload interface{}) {
+
+	if tctx, ok := ctx.(*TransactionContext); ok {
+		tctx.events = append(tctx.events, Sub_Event{
+			Type:    eventType,
+			Payload: payload,
+		})
+	}
+}
+
+// only called when the transaction did not return an error
+func flushEvents(ctx contractapi.TransactionContextInterface) error {
+
+	tctx, ok := ctx.(*TransactionContext)
+	if !ok || len(tctx.events) == 0 {
+		return nil
+	}
+
+	txDateTime, err0 := getTxDateTime(ctx)
+	if err0 != nil {
+		return fmt.Errorf("flushEvents >> %s", err0.Error())
+	}
+
+	data := Event_Envelope{
+		Version:  Event_Version,
+		TxID:     ctx.GetStub().GetTxID(),
+		DateTime: txDateTime,
+		Events:   tctx.events,
+	}
+
+	marshaled_data, _ := json.Marshal(data)
+	err1 := ctx.GetStub().SetEvent(Event_Name, marshaled_data)
+	if err1 != nil {
+		return fmt.Errorf("flushEvents >> Failed to set event. %s", err1.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,170 @@
+package lib
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Expiry
+//
+// A request can be made valid for a number of days with RequestToBuy_Estate,
+// it expires with the first transaction at or after ExpiresOn. Expired
+// requests can not be negotiated or accepted and are hidden from queries, but
+// stay in the estate and the buyer's requested until they are purged with
+// PurgeExpiredRequests or renewed by the buyer.
+
+// removes expired requests from the estates of an office and from their
+// buyers, at most pageSize estates per call, called again while More is true
+func (s *SmartContract) PurgeExpiredRequests(ctx contractapi.TransactionContextInterface, officeCode string, pageSize int) (Purge_Result, error) {
+
+	result := Purge_Result{}
+
+	// empty officeCode is the office of the sub-registrar, every office for
+	// the super admin
+	caller, err0 := s.authorizeAdmin(ctx, "PurgeExpiredRequests", officeCode)
+	if err0 != nil {
+		return result, err0
+	}
+
+	if caller.Role != Role_SuperAdmin {
+		officeCode = caller.Office
+	}
+
+	if pageSize <= 0 {
+		return result, fmt.Errorf("PurgeExpiredRequests >> pageSize must be greater than 0")
+	}
+
+	now, err1 := getTxDateTime(ctx)
+	if err1 != nil {
+		return result, fmt.Errorf("PurgeExpiredRequests >> %s", err1.Error())
+	}
+
+	// expiresOn is compared as a string, whole seconds in UTC like the
+	// stored values, see RequestToBuy_Estate
+	selector := map[string]interface{}{
+		"requests": map[string]interface{}{
+			"$elemMatch": map[string]interface{}{
+				"expiresOn": map[string]interface{}{"$gt": time.Time{}, "$lte": now.Truncate(time.Second)},
+			},
+		},
+	}
+	if officeCode != "" {
+		selector["officeCode"] = officeCode
+	}
+
+	repo := newRepository(ctx)
+
+	records, more, err2 := repo.FindEstates(selector, pageSize)
+	if err2 != nil {
+		return result, fmt.Errorf("PurgeExpiredRequests >> %s", err2.Error())
+	}
+
+	result.More = more
+
+	// a buyer can have requests on several estates of the batch, writes are
+	// not visible to reads in the same transaction
+	buyers := map[string]*User{}
+
+	for _, record := range records {
+
+		// query results are not validated at commit, read the estate again
+		estate, err3 := repo.GetEstate(record.ServeyNo)
+		if err3 != nil {
+			return result, fmt.Errorf("PurgeExpiredRequests >> %s", err3.Error())
+		}
+
+		active := []Request{}
+		expired := []string{}
+		for _, request := range estate.Requests {
+			if isExpired(request.ExpiresOn, now) {
+				expired = append(expired, request.Buyer)
+			} else {
+				active = append(active, request)
+			}
+		}
+
+		if len(expired) == 0 {
+			continue
+		}
+
+		estate.Requests = active
+
+		err4 := repo.PutEstate(record.ServeyNo, estate)
+		if err4 != nil {
+			return result, fmt.Errorf("PurgeExpiredRequests >> %s", err4.Error())
+		}
+
+		for _, uid := range expired {
+			buyer, ok := buyers[uid]
+			if !ok {
+				var err5 error
+				buyer, err5 = repo.GetUser(uid)
+				if err5 != nil {
+					return result, fmt.Errorf("PurgeExpiredRequests >> %s", err5.Error())
+				}
+				buyers[uid] = buyer
+			}
+
+			for i, r := range buyer.Requested {
+				if r.ServeyNo == record.ServeyNo {
+					buyer.Requested = append(buyer.Requested[:i], buyer.Requested[i+1:]...)
+					break
+				}
+			}
+		}
+
+		addEvent(ctx, EventType_RequestsExpired, RequestsCleared_Event{
+			ServeyNo: record.ServeyNo,
+			Buyers:   expired,
+		})
+
+		result.Estates++
+		result.Requests += len(expired)
+	}
+
+	for uid, buyer := range buyers {
+		err6 := repo.PutUser(uid, buyer)
+		if err6 != nil {
+			return result, fmt.Errorf("PurgeExpiredRequests >> %s", err6.Error())
+		}
+	}
+
+	return result, nil
+}
+
+// ------------------------------------
+
+// Helper Functions - Private
+
+func isExpired(expiresOn time.Time, now time.Time) bool {
+	return !expiresOn.IsZero() && !now.Before(expiresOn)
+}
+
+// requests not expired at now
+func activeRequests(requests []Request, now time.Time) []Request {
+
+	active := []Request{}
+	for _, request := range requests {
+		if !isExpired(request.ExpiresOn, now) {
+			active = append(active, request)
+		}
+	}
+
+	return active
+}
+
+func checkExpiry(fname string, serveyNo string, request *Request, now time.Time) error {
+
+	if isExpired(request.ExpiresOn, now) {
+		return &Validation_Error{
+			Code:     ValidationCode_Expired,
+			Function: fname,
+			ServeyNo: serveyNo,
+			Message:  fmt.Sprintf("Request of %s expired on %s", request.Buyer, request.ExpiresOn.Format(time.RFC3339)),
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,254 @@
+package lib
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Gift
+//
+// A co-owner gifts a share of the estate, or all of it, to a verified user
+// with GiftEstate, without a request or a price. The gift deed is a pending
+// transaction with reason "gift" and the relationship of the recipient to
+// the donor, no other transaction has a relationship. It is submitted for
+// registration once the recipient accepts it with AcceptGiftEstate, and
+// approved or rejected by the sub-registrar like a sale. Until then the donor withdraws it with WithdrawAcceptance_Estate and
+// the recipient declines it with WithdrawOffer_Estate. Requests for the estate
+// are kept, a rejected, withdrawn or declined gift puts the estate back in the
+// state it was gifted from.
+//
+// Stamp duty owed on a transaction is set when it is put, by the rule for its
+// reason in stampDutyRules. The default gift rule charges the flat duties set
+// with Set_GiftStampDuty, the lower one for gifts within the family.
+
+const (
+	Relationship_Spouse      = "spouse"
+	Relationship_Child       = "child"
+	Relationship_Parent      = "parent"
+	Relationship_Sibling     = "sibling"
+	Relationship_Grandchild  = "grandchild"
+	Relationship_Grandparent = "grandparent"
+	Relationship_Other       = "other"
+)
+
+// relationships counted as family for the stamp duty
+var familyRelationships = []string{
+	Relationship_Spouse,
+	Relationship_Child,
+	Relationship_Parent,
+	Relationship_Sibling,
+	Relationship_Grandchild,
+	Relationship_Grandparent,
+}
+
+// stamp duty owed on a transaction, by reason, transactions with a reason not
+// listed owe none. Deployments replace or add rules to follow the stamp act
+// of their state.
+var stampDutyRules = map[string]func(estate *Estate, transaction *Transaction, config *Config) int{
+	"gift": giftStampDuty,
+}
+
+// gift of percent of the caller's share to recipient
+func (s *SmartContract) GiftEstate(ctx contractapi.TransactionContextInterface, serveyNo string, recipient string, percent int, relationship string) (Transaction, error) {
+
+	repo := newRepository(ctx)
+
+	estate, err0 := repo.GetEstate(serveyNo)
+	if err0 != nil {
+		return Transaction{}, fmt.Errorf("GiftEstate >> %s", err0.Error())
+	}
+
+	_, donor, err1 := s.authorizeOwner(ctx, "GiftEstate", estate)
+	if err1 != nil {
+		return Transaction{}, err1
+	}
+
+	//=====================================
+
+	if relationship != Relationship_Other && searchArray(familyRelationships, relationship) == -1 {
+		return Transaction{}, fmt.Errorf("GiftEstate >> relationship must be one of %v or %s", familyRelationships, Relationship_Other)
+	}
+
+	share := ownerShare(estate, donor)
+	if percent <= 0 || percent > share {
+		return Transaction{}, fmt.Errorf("GiftEstate >> percent must be between 1 and %d, the share of %s", share, donor)
+	}
+
+	if recipient == donor {
+		return Transaction{}, fmt.Errorf("GiftEstate >> Estate can not be gifted to its owner")
+	}
+
+	recipient_data, err2 := repo.GetUser(recipient)
+	if err2 != nil {
+		return Transaction{}, fmt.Errorf("GiftEstate >> %s", err2.Error())
+	}
+
+	if recipient_data.Status != 1 {
+		return Transaction{}, &Validation_Error{
+			Code:     ValidationCode_NotVerified,
+			Function: "GiftEstate",
+			ServeyNo: serveyNo,
+			Message:  fmt.Sprintf("Recipient %s is not verified", recipient),
+		}
+	}
+
+	err3 := checkLiens(ctx, "GiftEstate", serveyNo, recipient)
+	if err3 != nil {
+		return Transaction{}, err3
+	}
+
+	// restored if the gift is rejected, withdrawn or declined
+	fromState := estate.State
+
+	err4 := transitionEstate(ctx, "GiftEstate", serveyNo, estate, Action_Gift)
+	if err4 != nil {
+		return Transaction{}, err4
+	}
+
+	config, err5 := repo.GetConfig()
+	if err5 != nil {
+		return Transaction{}, fmt.Errorf("GiftEstate >> %s", err5.Error())
+	}
+
+	temp_dateTime, err6 := getTxDateTime(ctx)
+	if err6 != nil {
+		return Transaction{}, fmt.Errorf("GiftEstate >> %s", err6.Error())
+	}
+
+	//=====================================
+
+	num := estate.TransactionsCount + 1
+
+	temp_transaction := Transaction{
+		Seller:              donor,
+		Buyer:               recipient,
+		TransactionDateTime: temp_dateTime,
+		OfficeCode:          estate.OfficeCode,
+		Price:               0,
+		PriceHash:           "",
+		Reason:              "gift",
+		Shares:              []Share{{UID: donor, Percent: percent}},
+		Consents:            []string{donor},
+		Relationship:        relationship,
+		FromState:           fromState,
+	}
+
+	temp_transaction.StampDuty = stampDuty(estate, &temp_transaction, config)
+
+	err7 := repo.PutTransaction(serveyNo, num, &temp_transaction)
+	if err7 != nil {
+		return Transaction{}, fmt.Errorf("GiftEstate >> %s", err7.Error())
+	}
+
+	err8 := repo.PutEstate(serveyNo, estate)
+	if err8 != nil {
+		return Transaction{}, fmt.Errorf("GiftEstate >> %s", err8.Error())
+	}
+
+	addEvent(ctx, EventType_GiftOffered, Transaction_Event{
+		ServeyNo:         serveyNo,
+		TransactionCount: num,
+		Transaction:      temp_transaction,
+	})
+
+	return temp_transaction, nil
+}
+
+// acceptance of the pending gift by its recipient, submits it for
+// registration
+func (s *SmartContract) AcceptGiftEstate(ctx contractapi.TransactionContextInterface, serveyNo string) (Transaction, error) {
+
+	repo := newRepository(ctx)
+
+	estate, err0 := repo.GetEstate(serveyNo)
+	if err0 != nil {
+		return Transaction{}, fmt.Errorf("AcceptGiftEstate >> %s", err0.Error())
+	}
+
+	caller, recipient, err1 := s.authorizeUser(ctx, "AcceptGiftEstate")
+	if err1 != nil {
+		return Transaction{}, err1
+	}
+
+	num := estate.TransactionsCount + 1
+	transaction, _, err2 := s.getPendingTransaction(ctx, "AcceptGiftEstate", serveyNo, estate)
+	if err2 != nil {
+		return Transaction{}, err2
+	}
+
+	// only GiftEstate sets a relationship, reason "gift" is reserved too
+	if transaction.Relationship == "" || estate.State != State_UnderContract {
+		return Transaction{}, &Validation_Error{
+			Code:     ValidationCode_NotPending,
+			Function: "AcceptGiftEstate",
+			ServeyNo: serveyNo,
+			Message:  fmt.Sprintf("Transaction %d is not a gift waiting for acceptance", num),
+		}
+	}
+
+	if transaction.Buyer != recipient {
+		return Transaction{}, &Access_Error{
+			Code:     AccessCode_NotOwner,
+			Function: "AcceptGiftEstate",
+			Caller:   caller.EnrollmentID,
+			Allowed:  []string{Access_Buyer},
+			Message:  fmt.Sprintf("Gift %d is not made to %s", num, recipient),
+		}
+	}
+
+	temp_dateTime, err3 := getTxDateTime(ctx)
+	if err3 != nil {
+		return Transaction{}, fmt.Errorf("AcceptGiftEstate >> %s", err3.Error())
+	}
+
+	//=====================================
+
+	transaction.AcceptedOn = temp_dateTime
+
+	err4 := s.submitForApproval(ctx, "AcceptGiftEstate", serveyNo, estate, num)
+	if err4 != nil {
+		return Transaction{}, err4
+	}
+
+	err5 := repo.PutTransaction(serveyNo, num, transaction)
+	if err5 != nil {
+		return Transaction{}, fmt.Errorf("AcceptGiftEstate >> %s", err5.Error())
+	}
+
+	err6 := repo.PutEstate(serveyNo, estate)
+	if err6 != nil {
+		return Transaction{}, fmt.Errorf("AcceptGiftEstate >> %s", err6.Error())
+	}
+
+	addEvent(ctx, EventType_GiftAccepted, Transaction_Event{
+		ServeyNo:         serveyNo,
+		TransactionCount: num,
+		Transaction:      *transaction,
+	})
+
+	return *transaction, nil
+}
+
+// ------------------------------------
+
+// Helper Functions - Private
+
+func stampDuty(estate *Estate, transaction *Transaction, config *Config) int {
+
+	rule, found := stampDutyRules[transaction.Reason]
+	if !found {
+		return 0
+	}
+
+	return rule(estate, transaction, config)
+}
+
+func giftStampDuty(estate *Estate, transaction *Transaction, config *Config) int {
+
+	if searchArray(familyRelationships, transaction.Relationship) != -1 {
+		return config.FamilyGiftStampDuty
+	}
+
+	return config.GiftStampDuty
+}
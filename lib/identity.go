@@ -0,0 +1,202 @@
+package lib
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Identity
+//
+// Callers are identified by their Fabric CA enrollment certificate instead of
+// a username/password passed as chaincode arguments. Certificates for admins
+// carry the attributes role=superadmin, or role=subregistrar and office=<officeCode>.
+// Certificates for banks carry role=bank and bank=<bankCode>, they are not
+// bound to a record, the bank registered with CreateOrModify_Bank names the
+// org its identities are issued by.
+//
+// Every admin_super / admin_<officeCode> / user_<uid> record is bound to exactly
+// one identity (MSP ID + enrollment ID). Records created before identities were
+// used are bound once by their owner through Migrate_Identity, with the
+// password, passed in the transient map, as proof of ownership. Legacy users
+// hold their uid as cleartext password, which anyone can know, they are
+// issued a fresh password with ResetPassword_User before they can be bound.
+
+const (
+	Role_SuperAdmin   = "superadmin"
+	Role_SubRegistrar = "subregistrar"
+	Role_Bank         = "bank"
+)
+
+type Caller struct {
+	Identity
+	Role     string // certificate attribute "role"
+	Office   string // certificate attribute "office", only for sub-registrars
+	Bank     string // certificate attribute "bank", only for banks
+	Username string // key of the bound record, empty if not bound yet
+}
+
+// ------------------------------------
+
+func (s *SmartContract) Migrate_Identity(ctx contractapi.TransactionContextInterface) error {
+
+	credentials, err7 := getCredentials(ctx, "Migrate_Identity")
+	if err7 != nil {
+		return err7
+	}
+
+	_username := credentials.Username
+	_password := credentials.Password
+
+	caller, err0 := s.getCaller(ctx)
+	if err0 != nil {
+		return fmt.Errorf("Migrate_Identity >> %s", err0.Error())
+	}
+
+	if caller.Username != "" {
+		return fmt.Errorf("Migrate_Identity >> Identity is already bound to %s", caller.Username)
+	}
+
+	err8 := checkClaim(caller, "Migrate_Identity", _username)
+	if err8 != nil {
+		return err8
+	}
+
+	verified, err1 := s.verifyPassword(ctx, _username, _password)
+
+	if err1 != nil {
+		return fmt.Errorf("verifyPassword >> Verify password %s", err1.Error())
+	} else if !verified {
+		return fmt.Errorf("Migrate_Identity >> Password Missmatched for %s", _username)
+	}
+
+	// first login, default password has to be rotated first
+	mustChange, err6 := s.mustChangePassword(ctx, _username)
+	if err6 != nil {
+		return fmt.Errorf("Migrate_Identity >> %s", err6.Error())
+	} else if mustChange {
+		return fmt.Errorf("Migrate_Identity >> Password of %s must be changed with ChangePassword first", _username)
+	}
+
+	//=====================================
+
+	repo := newRepository(ctx)
+
+	// get data
+	data, err2 := repo.GetRecord(_username)
+	if err2 != nil {
+		return fmt.Errorf("Migrate_Identity >> %s", err2.Error())
+	}
+
+	if recordIdentity(data).EnrollmentID != "" {
+		return fmt.Errorf("Migrate_Identity >> %s is already bound to an identity", _username)
+	}
+
+	data["identity"] = caller.Identity
+
+	err4 := repo.PutRecord(_username, data)
+	if err4 != nil {
+		return fmt.Errorf("Migrate_Identity >> %s", err4.Error())
+	}
+
+	err5 := repo.PutIdentity(caller.Identity, _username)
+	if err5 != nil {
+		return fmt.Errorf("Migrate_Identity >> %s", err5.Error())
+	}
+
+	return nil
+}
+
+// ------------------------------------
+
+// Helper Functions - Private
+
+// admin records are only claimed by identities with the matching certificate
+// attributes
+func checkClaim(caller Caller, fname string, username string) error {
+
+	if username == "admin_super" {
+		if caller.Role != Role_SuperAdmin {
+			return fmt.Errorf("%s >> Identity does not have role %s", fname, Role_SuperAdmin)
+		}
+	} else if strings.HasPrefix(username, "admin_") {
+		if caller.Role != Role_SubRegistrar || "admin_"+caller.Office != username {
+			return fmt.Errorf("%s >> Identity is not the sub-registrar of %s", fname, strings.TrimPrefix(username, "admin_"))
+		}
+	} else if !strings.HasPrefix(username, "user_") {
+		return fmt.Errorf("%s >> %s can not be bound to an identity", fname, username)
+	}
+
+	return nil
+}
+
+// identity a record is bound to, empty if not bound yet
+func recordIdentity(data map[string]interface{}) Identity {
+
+	bound, _ := data["identity"].(map[string]interface{})
+	mspID, _ := bound["mspId"].(string)
+	enrollmentID, _ := bound["enrollmentId"].(string)
+
+	return Identity{MSPID: mspID, EnrollmentID: enrollmentID}
+}
+
+func (s *SmartContract) getCaller(ctx contractapi.TransactionContextInterface) (Caller, error) {
+
+	clientIdentity := ctx.GetClientIdentity()
+	if clientIdentity == nil {
+		return Caller{}, fmt.Errorf("getCaller >> Client identity is not available")
+	}
+
+	mspID, err0 := clientIdentity.GetMSPID()
+	if err0 != nil {
+		return Caller{}, fmt.Errorf("getCaller >> Can't read MSP ID. %s", err0.Error())
+	}
+
+	// set by Fabric CA in every enrollment certificate
+	enrollmentID, found, err1 := clientIdentity.GetAttributeValue("hf.EnrollmentID")
+	if err1 != nil {
+		return Caller{}, fmt.Errorf("getCaller >> Can't read certificate attributes. %s", err1.Error())
+	}
+
+	if !found {
+		cert, err2 := clientIdentity.GetX509Certificate()
+		if err2 != nil || cert == nil {
+			return Caller{}, fmt.Errorf("getCaller >> Can't read X.509 certificate")
+		}
+		enrollmentID = cert.Subject.CommonName
+	}
+
+	role, _, _ := clientIdentity.GetAttributeValue("role")
+	office, _, _ := clientIdentity.GetAttributeValue("office")
+	bank, _, _ := clientIdentity.GetAttributeValue("bank")
+
+	caller := Caller{
+		Identity: Identity{
+			MSPID:        mspID,
+			EnrollmentID: enrollmentID,
+		},
+		Role:   role,
+		Office: office,
+		Bank:   bank,
+	}
+
+	// find the record bound to this identity
+	username, err3 := newRepository(ctx).GetIdentity(caller.Identity)
+	if err3 != nil {
+		return Caller{}, fmt.Errorf("getCaller >> %s", err3.Error())
+	}
+
+	// ledger not migrated to composite keys yet, see Migrate_Keys
+	if username == "" {
+		dataAsBytes, err4 := ctx.GetStub().GetState(legacyIdentityKey(caller.Identity))
+		if err4 != nil {
+			return Caller{}, fmt.Errorf("getCaller >> Failed to read from world state. %s", err4.Error())
+		}
+		username = string(dataAsBytes)
+	}
+
+	caller.Username = username
+
+	return caller, nil
+}
@@ -12,42 +12,94 @@ type SmartContract struct {
 	contractapi.Contract
 }
 
+// contracts only compiled in with build tags, see testing.go
+var extraContracts []contractapi.ContractInterface
+
+// all contracts of the chaincode, SmartContract is the default one
+func Contracts() []contractapi.ContractInterface {
+	return append([]contractapi.ContractInterface{new(SmartContract)}, extraContracts...)
+}
+
 // ------------------------------------
 
+// Identity: Fabric client identity a record is bound to
+type Identity struct {
+	MSPID        string `json:"mspId"`
+	EnrollmentID string `json:"enrollmentId"`
+}
+
 type Admin_Super struct {
-	Password string `json:"password"`
-	UID      string `json:"uid"`  //  Inspector General of Registration
-	Name     string `json:"name"` //  Inspector General of Registration
+	Password           string   `json:"password"` // scrypt hash
+	MustChangePassword bool     `json:"mustChangePassword"`
+	UID                string   `json:"uid"`  //  Inspector General of Registration
+	Name               string   `json:"name"` //  Inspector General of Registration
+	Identity           Identity `json:"identity"`
 }
 
 // OfficeCode: Tri letter unique code give to each Sub-Registrar's office
 type Admin_OfficeCode struct {
-	Password  string   `json:"password"`
-	UID       string   `json:"uid"`       // Sub-Registrar
-	Name      string   `json:"name"`      // Sub-Registrar
-	ToApprove []string `json:"toApprove"` // transactions to approve
+	Password           string         `json:"password"` // scrypt hash
+	MustChangePassword bool           `json:"mustChangePassword"`
+	UID                string         `json:"uid"`       // Sub-Registrar
+	Name               string         `json:"name"`      // Sub-Registrar
+	ToApprove          []Approval_Ref `json:"toApprove"` // transactions to approve
+	Identity           Identity       `json:"identity"`
+}
+
+// transaction or lease waiting for approval of the sub-registrar
+type Approval_Ref struct {
+	ServeyNo         string `json:"serveyNo"`
+	TransactionCount int    `json:"transactionCount"` // 0 for leases
+	LeaseID          string `json:"leaseId"`          // empty for transactions
 }
 
 type User struct {
-	Password  string          `json:"password"`
-	UID       string          `json:"uid"`
-	Name      string          `json:"name"`
-	Status    int             `json:"status"` // 0/1/2 - Not verified/Verified/Suspended
-	Owned     []string        `json:"owned"`
-	Requested []Request_Buyer `json:"requested"`
+	Password           string          `json:"password"` // scrypt hash
+	MustChangePassword bool            `json:"mustChangePassword"`
+	UID                string          `json:"uid"`
+	Name               string          `json:"name"`       // empty, name is private, except for legacy users
+	NameHash           string          `json:"nameHash"`   // hash of the private name, see privacy.go
+	OfficeCode         string          `json:"officeCode"` // registering office, keeps the private name
+	Status             int             `json:"status"`     // 0/1/2/3 - Not verified/Verified/Suspended/Deceased
+	Owned              []string        `json:"owned"`
+	Requested          []Request_Buyer `json:"requested"`
+	Identity           Identity        `json:"identity"`
+	DeceasedOn         time.Time       `json:"deceasedOn"`       // zero unless deceased, see succession.go
+	DeathCertificate   string          `json:"deathCertificate"` // hash of the death certificate
 }
 
 type Request struct {
 	Buyer         string    `json:"buyer"`
-	Name          string    `json:"name"`
-	ProposedPrice int       `json:"proposedPrice"`
+	Name          string    `json:"name"`          // empty, name is private, except for legacy requests
+	ProposedPrice int       `json:"proposedPrice"` // 0, price is private, except for legacy requests
+	PriceHash     string    `json:"priceHash"`     // hash of the private price of the latest offer
 	DateTime      time.Time `json:"dateTime"`
+	ID            string    `json:"id"`         // txId that created the request, offers are stored under it
+	Rounds        int       `json:"rounds"`     // offers made till now
+	OfferedBy     string    `json:"offeredBy"`  // buyer/seller, party of the latest offer
+	Conditions    string    `json:"conditions"` // conditions of the latest offer
+	Agreed        bool      `json:"agreed"`     // latest offer accepted by the other party
+	ExpiresOn     time.Time `json:"expiresOn"`  // zero if the request does not expire
+}
+
+// one round of the negotiation on a request, never modified once stored
+type Offer struct {
+	Round      int       `json:"round"`
+	Kind       string    `json:"kind"`  // request/counter/accept
+	Party      string    `json:"party"` // buyer/seller
+	By         string    `json:"by"`    // uid
+	Price      int       `json:"price"` // 0 in state, price is private
+	PriceHash  string    `json:"priceHash"`
+	Conditions string    `json:"conditions"`
+	DateTime   time.Time `json:"dateTime"`
 }
 
 type Request_Buyer struct {
 	ServeyNo      string    `json:"serveyNo"`
-	ProposedPrice int       `json:"proposedPrice"`
+	ProposedPrice int       `json:"proposedPrice"` // 0, price is private, except for legacy requests
+	PriceHash     string    `json:"priceHash"`
 	DateTime      time.Time `json:"dateTime"`
+	ExpiresOn     time.Time `json:"expiresOn"` // zero if the request does not expire
 }
 
 type Transaction struct {
@@ -57,50 +109,434 @@ type Transaction struct {
 	OfficeCode          string    `json:"officeCode"`          // Where estate resides
 	ApprovedBy          string    `json:"approvedBy"`          // uid
 	ApprovedDateTime    time.Time `json:"approvedDateTime"`
-	Price               int       `json:"price"`  // accepted buy seller/owner
-	Reason              string    `json:"reason"` // sell, inheritance, gift
+	Price               int       `json:"price"`        // 0, price is private, except for legacy transactions
+	PriceHash           string    `json:"priceHash"`    // hash of the private price accepted by seller/owner
+	Conditions          string    `json:"conditions"`   // agreed in the negotiation
+	Reason              string    `json:"reason"`       // sell, inheritance, gift
+	Shares              []Share   `json:"shares"`       // shares sold, empty for legacy transactions of the whole estate
+	Consents            []string  `json:"consents"`     // uids of the sellers who consented, see ownership.go
+	Heirs               []Share   `json:"heirs"`        // percent of the estate each heir inherited, inheritance only
+	Relationship        string    `json:"relationship"` // of the buyer to the seller, gift only, see gift.go
+	AcceptedOn          time.Time `json:"acceptedOn"`   // gift accepted by the buyer, gift only
+	StampDuty           int       `json:"stampDuty"`    // owed to the state, see stampDutyRules
+	FromState           string    `json:"fromState"`    // restored on reject or withdraw, empty for sales, set for share transfers and gifts, see lifecycle.go
 }
 
 type Estate struct {
-	Owner             string    `json:"owner"`             // uid
+	DocType           string    `json:"docType"`           // "estate", for CouchDB queries
+	Owner             string    `json:"owner"`             // uid, managing owner, see ownership.go
+	Owners            []Share   `json:"owners"`            // co-owners, empty for legacy estates owned by Owner alone
 	OfficeCode        string    `json:"officeCode"`        // Where estate resides
 	Location          string    `json:"location"`          // address
 	Area              int       `json:"area"`              // in sq mtr
-	Status            int       `json:"status"`            // 0/1/2 - Not verified/Verified/Suspended
+	State             string    `json:"state"`             // Draft/Verified/Listed/..., see lifecycle.go
 	PurchasedOn       time.Time `json:"purchasedOn"`       // current owner since
-	SaleAvailability  bool      `json:"saleAvailability"`  // bool
 	TransactionsCount int       `json:"transactionsCount"` // total transactions till now
 	Requests          []Request `json:"requests"`          // all request from buyers
-	BeingSold         bool      `json:"beingSold"`         // true when a request from buyer is accepted
+	Auction           string    `json:"auction"`           // id of the running auction, empty if none
+	Succession        string    `json:"succession"`        // id of the pending succession, empty if none
+	Parents           []string  `json:"parents"`           // serveyNos the estate was created from, see parcel.go
+	Children          []string  `json:"children"`          // serveyNos the estate was retired into
+}
+
+// lease or leave and license agreement on an estate, see lease.go
+type Lease struct {
+	ID               string    `json:"id"`   // txId that submitted the lease
+	Kind             string    `json:"kind"` // lease/leaveAndLicense
+	Lessor           string    `json:"lessor"`
+	Lessee           string    `json:"lessee"`
+	OfficeCode       string    `json:"officeCode"` // Where estate resides
+	StartsOn         time.Time `json:"startsOn"`
+	EndsOn           time.Time `json:"endsOn"`
+	TermsHash        string    `json:"termsHash"` // hash of the private rent and deposit
+	Status           string    `json:"status"`    // pending/active/rejected/terminated/expired
+	RenewalOf        string    `json:"renewalOf"` // id of the lease renewed, empty if none
+	SubmittedOn      time.Time `json:"submittedOn"`
+	ApprovedBy       string    `json:"approvedBy"` // uid
+	ApprovedDateTime time.Time `json:"approvedDateTime"`
+	EndedOn          time.Time `json:"endedOn"` // terminated or expired, zero till then
+}
+
+// transfer of the share of a deceased co-owner to the legal heirs, see
+// succession.go
+type Succession struct {
+	ID               string      `json:"id"` // txId that declared the heirs
+	Deceased         string      `json:"deceased"`
+	Heirs            []Share     `json:"heirs"`      // percent of the deceased's share, totals 100
+	OfficeCode       string      `json:"officeCode"` // Where estate resides
+	DeclaredBy       string      `json:"declaredBy"` // enrollment id of the admin
+	DeclaredOn       time.Time   `json:"declaredOn"`
+	ObjectionsUntil  time.Time   `json:"objectionsUntil"` // end of the objection period
+	Objections       []Objection `json:"objections"`
+	Status           string      `json:"status"`     // pending/approved/rejected
+	ApprovedBy       string      `json:"approvedBy"` // uid
+	ApprovedDateTime time.Time   `json:"approvedDateTime"`
+	TransactionCount int         `json:"transactionCount"` // transaction recording the transfer, 0 until approved
+}
+
+// claim or objection against a succession
+type Objection struct {
+	Objector    string    `json:"objector"` // uid
+	Reason      string    `json:"reason"`
+	FiledOn     time.Time `json:"filedOn"`
+	DismissedOn time.Time `json:"dismissedOn"` // zero until dismissed by the sub-registrar
+}
+
+// part of an estate being subdivided, see parcel.go
+type Estate_Part struct {
+	Area     int    `json:"area"`     // in sq mtr
+	Location string `json:"location"` // empty for the location of the estate
+}
+
+// share of a co-owner in an estate, or sold in a transaction
+type Share struct {
+	UID     string `json:"uid"`
+	Percent int    `json:"percent"`
+}
+
+// auction of a listed estate, see auction.go
+type Auction struct {
+	ID           string    `json:"id"`   // txId that started the auction
+	Type         string    `json:"type"` // open/sealed
+	Seller       string    `json:"seller"`
+	ReservePrice int       `json:"reservePrice"`
+	StartsOn     time.Time `json:"startsOn"`
+	EndsOn       time.Time `json:"endsOn"`       // end of bidding
+	RevealEndsOn time.Time `json:"revealEndsOn"` // end of revealing, sealed only
+	Bids         []Bid     `json:"bids"`
+	Status       string    `json:"status"` // running/settled/closed
+	Winner       string    `json:"winner"` // uid, empty if no bid won
+	Price        int       `json:"price"`  // winning bid
+}
+
+// bid in an auction, sealed bids have only the hash until they are revealed
+type Bid struct {
+	Bidder   string    `json:"bidder"` // uid
+	Price    int       `json:"price"`
+	Hash     string    `json:"hash"`     // sealed only, hex sha256 of the bid
+	Revealed bool      `json:"revealed"` // sealed only
+	DateTime time.Time `json:"dateTime"`
+}
+
+// amount of a sealed bid, kept in the private data of the bidder's org
+type Private_Bid struct {
+	Price int    `json:"price"`
+	Salt  string `json:"salt"`
+}
+
+// private data, see privacy.go, the public record keeps the hash of its JSON
+
+type Private_Price struct {
+	Price int    `json:"price"`
+	Salt  string `json:"salt"`
+}
+
+// monthly rent and deposit of a lease
+type Private_Lease struct {
+	Rent    int    `json:"rent"`
+	Deposit int    `json:"deposit"`
+	Salt    string `json:"salt"`
+}
+
+type Private_Name struct {
+	Name string `json:"name"`
+	Salt string `json:"salt"`
+}
+
+// accepted sale withdrawn by the seller or the buyer before registration
+type Cancellation struct {
+	TransactionCount int         `json:"transactionCount"`
+	Transaction      Transaction `json:"transaction"` // deleted pending transaction
+	WithdrawnBy      string      `json:"withdrawnBy"` // uid
+	Party            string      `json:"party"`       // seller/buyer
+	Penalty          int         `json:"penalty"`     // 0, penalty is private
+	PenaltyHash      string      `json:"penaltyHash"` // hash of the private penalty owed by withdrawnBy to the other party
+	PayableTo        string      `json:"payableTo"`   // uid
+	DateTime         time.Time   `json:"dateTime"`
+}
+
+// lender registered by the super admin, see encumbrance.go
+type Bank struct {
+	Code   string `json:"code"`
+	Name   string `json:"name"`
+	MSPID  string `json:"mspId"`  // org of the bank, identities of other orgs can not act for it
+	Active bool   `json:"active"` // inactive banks can not register liens
+}
+
+// mortgage or lien of a bank on an estate, kept after it is released
+type Lien struct {
+	ID             string    `json:"id"`         // txId that registered the lien
+	Kind           string    `json:"kind"`       // mortgage/lien
+	Lienholder     string    `json:"lienholder"` // bankCode
+	Amount         int       `json:"amount"`     // secured amount
+	Reference      string    `json:"reference"`  // loan account or deed number at the bank
+	RegisteredBy   string    `json:"registeredBy"`
+	RegisteredOn   time.Time `json:"registeredOn"`
+	ModifiedOn     time.Time `json:"modifiedOn"`     // zero if never modified
+	ReleasedOn     time.Time `json:"releasedOn"`     // zero while the lien is active
+	ConsentedBuyer string    `json:"consentedBuyer"` // uid the estate may be sold to, empty if the lienholder did not consent
+}
+
+// settings of the registry, changed by the super admin
+type Config struct {
+	CancellationPenalty int `json:"cancellationPenalty"` // percent of the price, 0 for no penalty
+	ConsentThreshold    int `json:"consentThreshold"`    // percent of the shares sold that has to consent, 0 for all co-owners
+	ObjectionDays       int `json:"objectionDays"`       // objection period of successions, 0 for none
+	GiftStampDuty       int `json:"giftStampDuty"`       // stamp duty of a gift deed
+	FamilyGiftStampDuty int `json:"familyGiftStampDuty"` // stamp duty of a gift deed within the family
+}
+
+// attempt by an admin to act on an estate of another office, logged by
+// recordViolation, only legacy attempts are kept as violation records
+type Jurisdiction_Violation struct {
+	Function     string    `json:"function"`
+	Caller       string    `json:"caller"`       // enrollment id
+	CallerOffice string    `json:"callerOffice"` // office of the admin
+	OfficeCode   string    `json:"officeCode"`   // Where estate resides
+	ServeyNo     string    `json:"serveyNo"`
+	DateTime     time.Time `json:"dateTime"`
+}
+
+// date in the past supplied by an admin instead of the transaction timestamp,
+// e.g. purchase date of an old deed being migrated
+type Historical_Date struct {
+	Key        string    `json:"key"`   // composite key of the record the date is stored in, see repository.go
+	Field      string    `json:"field"` // json field of the record
+	Value      time.Time `json:"value"`
+	RecordedBy string    `json:"recordedBy"` // enrollment id
+	RecordedOn time.Time `json:"recordedOn"` // transaction timestamp
 }
 
 // struct for events
-/*
+
+// one per transaction, carries every sub-event the transaction produced
+type Event_Envelope struct {
+	Version  int         `json:"version"`
+	TxID     string      `json:"txId"`
+	DateTime time.Time   `json:"dateTime"`
+	Events   []Sub_Event `json:"events"`
+}
+
+type Sub_Event struct {
+	Type    string      `json:"type"`
+	Payload interface{} `json:"payload"`
+}
+
+type Request_Event struct {
+	ServeyNo string  `json:"serveyNo"`
+	Request  Request `json:"request"`
+}
+
+type Offer_Event struct {
+	ServeyNo string `json:"serveyNo"`
+	Buyer    string `json:"buyer"`
+	Offer    Offer  `json:"offer"`
+}
+
+type Auction_Event struct {
+	ServeyNo string  `json:"serveyNo"`
+	Auction  Auction `json:"auction"`
+}
+
+type Bid_Event struct {
+	ServeyNo  string `json:"serveyNo"`
+	AuctionID string `json:"auctionId"`
+	Bid       Bid    `json:"bid"`
+}
+
+type RequestsCleared_Event struct {
+	ServeyNo string   `json:"serveyNo"`
+	Buyers   []string `json:"buyers"`
+}
+
+type Lease_Event struct {
+	ServeyNo string `json:"serveyNo"`
+	Lease    Lease  `json:"lease"`
+}
+
+type Succession_Event struct {
+	ServeyNo   string     `json:"serveyNo"`
+	Succession Succession `json:"succession"`
+}
+
+type Lien_Event struct {
+	ServeyNo string `json:"serveyNo"`
+	Lien     Lien   `json:"lien"`
+}
+
+// estates retired (from) and created (to) by a subdivision or amalgamation
+type Parcel_Event struct {
+	From []string `json:"from"`
+	To   []string `json:"to"`
+}
+
+type Owners_Event struct {
+	ServeyNo string  `json:"serveyNo"`
+	Owner    string  `json:"owner"`
+	Owners   []Share `json:"owners"`
+}
+
 type Transaction_Event struct {
-	ServeyNo         string `json:"serveyNo"`
-	TransactionCount int    `json:"transactionCount"`
-	Transaction      Transaction
+	ServeyNo         string      `json:"serveyNo"`
+	TransactionCount int         `json:"transactionCount"`
+	Transaction      Transaction `json:"transaction"`
+}
+
+type Estate_State_Event struct {
+	ServeyNo string `json:"serveyNo"`
+	Action   string `json:"action"`
+	From     string `json:"from"`
+	To       string `json:"to"`
+}
+
+type Cancellation_Event struct {
+	ServeyNo     string       `json:"serveyNo"`
+	Cancellation Cancellation `json:"cancellation"`
+}
+
+type Status_Event struct {
+	Key    string `json:"key"` // serveyNo or uid
+	Status int    `json:"status"`
+}
+
+type Admin_Event struct {
+	OfficeCode string `json:"officeCode"`
+	UID        string `json:"uid"`
+	Name       string `json:"name"`
+}
+
+// struct for queries
+
+// registered transfer in the chain of title of an estate
+type Title_Record struct {
+	TransactionCount int         `json:"transactionCount"`
+	Transaction      Transaction `json:"transaction"`
+	TxID             string      `json:"txId"`      // ledger transaction the transfer took effect in
+	Timestamp        time.Time   `json:"timestamp"` // block timestamp of txId
+}
+
+type Estate_History struct {
+	ServeyNo string         `json:"serveyNo"`
+	Titles   []Title_Record `json:"titles"`   // oldest first
+	Leases   []Lease        `json:"leases"`   // approved leases, on the first page only
+	Bookmark string         `json:"bookmark"` // next page, empty on the last page
+}
+
+// filter of QueryEstates, passed as JSON, unset fields match every estate
+type Estate_Filter struct {
+	OfficeCode string `json:"officeCode,omitempty"`
+	Owner      string `json:"owner,omitempty"` // owner or co-owner
+	State      string `json:"state,omitempty"`
+	MinArea    *int   `json:"minArea,omitempty"`
+	MaxArea    *int   `json:"maxArea,omitempty"`
+}
+
+type Estate_Record struct {
+	ServeyNo string `json:"serveyNo"`
+	Estate   Estate `json:"estate"`
+}
+
+type Estate_Query_Result struct {
+	Records  []Estate_Record `json:"records"`
+	Bookmark string          `json:"bookmark"` // next page, empty on the last page
+}
+
+// liens of an estate active at any time between From and To
+type Encumbrance_Certificate struct {
+	ServeyNo string    `json:"serveyNo"`
+	From     time.Time `json:"from"` // zero if not limited
+	To       time.Time `json:"to"`   // zero if not limited
+	Liens    []Lien    `json:"liens"`
+	IssuedOn time.Time `json:"issuedOn"`
+}
+
+type Purge_Result struct {
+	Estates  int  `json:"estates"`  // estates cleaned
+	Requests int  `json:"requests"` // expired requests removed
+	More     bool `json:"more"`     // more estates with expired requests are left
+}
+
+// estate as written by one ledger transaction
+type Estate_Version struct {
+	TxID      string    `json:"txId"`
+	Timestamp time.Time `json:"timestamp"`
+	IsDelete  bool      `json:"isDelete"`
+	Estate    Estate    `json:"estate"`
 }
-*/
 
 // ------------------------------------
 
 func (s *SmartContract) InitLedger(ctx contractapi.TransactionContextInterface) error {
 
-	// put super user name and password
-	key := "admin_super"
-	data := Admin_Super{
-		Password: "123456",
-		Name:     "FName MName LName",
-		UID:      "123456789012",
+	caller, err0 := s.getCaller(ctx)
+	if err0 != nil {
+		return fmt.Errorf("InitLedger >> %s", err0.Error())
 	}
 
-	marshaled_data, _ := json.Marshal(data)
+	// super admin is bound to the initiating identity, it can not be left
+	// for anyone to claim
+	if caller.Role != Role_SuperAdmin {
+		return &Access_Error{
+			Code:     AccessCode_Forbidden,
+			Function: "InitLedger",
+			Caller:   caller.EnrollmentID,
+			Allowed:  []string{Access_SuperAdmin},
+			Message:  fmt.Sprintf("Identity does not have role %s", Role_SuperAdmin),
+		}
+	}
 
-	err := ctx.GetStub().PutState(key, marshaled_data)
+	credentials, err5 := getCredentials(ctx, "InitLedger")
+	if err5 != nil {
+		return err5
+	}
+
+	if len(credentials.NewPassword) < minPasswordLength {
+		return fmt.Errorf("InitLedger >> New password must have at least %d characters", minPasswordLength)
+	}
+
+	repo := newRepository(ctx)
+
+	// can only be initiated once, it would reset the super admin
+	exists, err3 := repo.SuperAdminExists()
+	if err3 != nil {
+		return fmt.Errorf("InitLedger >> %s", err3.Error())
+	}
 
+	if exists {
+		return fmt.Errorf("InitLedger >> Ledger is already initiated")
+	}
+
+	legacyAsBytes, err4 := ctx.GetStub().GetState("admin_super")
+	if err4 != nil {
+		return fmt.Errorf("InitLedger >> Failed to read from world state. %s", err4.Error())
+	}
+
+	if legacyAsBytes != nil {
+		return fmt.Errorf("InitLedger >> Ledger is already initiated, keys have to be migrated with Migrate_Keys")
+	}
+
+	hashed, err2 := hashPassword(ctx, "admin_super", credentials.NewPassword)
+	if err2 != nil {
+		return fmt.Errorf("InitLedger >> %s", err2.Error())
+	}
+
+	// initial password, has to be changed before it can be used
+	data := Admin_Super{
+		Password:           hashed,
+		MustChangePassword: true,
+		Name:               "FName MName LName",
+		UID:                "123456789012",
+		Identity:           caller.Identity,
+	}
+
+	err1 := repo.PutIdentity(caller.Identity, "admin_super")
+	if err1 != nil {
+		return fmt.Errorf("InitLedger >> %s", err1.Error())
+	}
+
+	err := repo.PutSuperAdmin(&data)
 	if err != nil {
-		return fmt.Errorf("InitLedger >> failed to put to world state. %s", err.Error())
+		return fmt.Errorf("InitLedger >> %s", err.Error())
 	}
 
 	fmt.Println("=====================================")
@@ -117,35 +553,165 @@ func (s *SmartContract) InitLedger(ctx contractapi.TransactionContextInterface)
 func (s *SmartContract) verifyPassword(ctx contractapi.TransactionContextInterface, _username string, _password string) (bool, error) {
 
 	// get data
-	dataAsBytes, err0 := ctx.GetStub().GetState(_username)
+	data, err0 := newRepository(ctx).GetRecord(_username)
+	if err0 != nil {
+		return false, fmt.Errorf("GetPassword >> %s", err0.Error())
+	}
 
+	// extract password
+	password, ok := data["password"].(string)
+	if !ok {
+		// password is not a string
+		return false, fmt.Errorf("GetPassword >> Password is not a string")
+	}
+
+	return checkPassword(password, _password)
+}
+
+// true for default passwords and legacy cleartext passwords
+func (s *SmartContract) mustChangePassword(ctx contractapi.TransactionContextInterface, _username string) (bool, error) {
+
+	// get data
+	data, err0 := newRepository(ctx).GetRecord(_username)
 	if err0 != nil {
-		return false, fmt.Errorf("GetPassword >> Failed to read from world state. %s", err0.Error())
+		return false, fmt.Errorf("mustChangePassword >> %s", err0.Error())
 	}
 
-	if dataAsBytes == nil {
-		return false, fmt.Errorf("GetPassword >> %s does not exist", _username)
+	password, _ := data["password"].(string)
+	mustChange, _ := data["mustChangePassword"].(bool)
+
+	return mustChange || !isPasswordHashed(password), nil
+}
+
+// same on every endorsing peer, unlike time.Now()
+func getTxDateTime(ctx contractapi.TransactionContextInterface) (time.Time, error) {
+
+	txTimestamp, err0 := ctx.GetStub().GetTxTimestamp()
+	if err0 != nil {
+		return time.Time{}, fmt.Errorf("getTxDateTime >> Can't read transaction timestamp. %s", err0.Error())
 	}
 
-	// extract password
-	// this is to handle the data from unknown/misc structs
-	data := make(map[string]interface{})
-	err1 := json.Unmarshal(dataAsBytes, &data)
+	return time.Unix(txTimestamp.Seconds, int64(txTimestamp.Nanos)).UTC(), nil
+}
+
+// validates a historical RFC3339 date (2021-12-15T20:34:33+05:30) and keeps
+// who supplied it in a Historical_Date record, empty value means no date
+func (s *SmartContract) parseHistoricalDate(ctx contractapi.TransactionContextInterface, key string, field string, value string) (time.Time, error) {
+
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	dateTime, err0 := time.Parse(time.RFC3339, value)
+	if err0 != nil {
+		return time.Time{}, fmt.Errorf("parseHistoricalDate >> %s is not a valid RFC3339 date. %s", field, err0.Error())
+	}
+
+	txDateTime, err1 := getTxDateTime(ctx)
 	if err1 != nil {
-		return false, fmt.Errorf("GetPassword >> Can't Unmarshal Data")
+		return time.Time{}, err1
 	}
 
-	password, ok := data["password"].(string)
-	if !ok {
-		// password is not a string
-		return false, fmt.Errorf("GetPassword >> Password is not a string")
+	if dateTime.After(txDateTime) {
+		return time.Time{}, fmt.Errorf("parseHistoricalDate >> %s can not be in the future", field)
+	}
+
+	caller, err2 := s.getCaller(ctx)
+	if err2 != nil {
+		return time.Time{}, err2
 	}
 
-	if _password == password {
-		return true, nil
+	data := Historical_Date{
+		Key:        key,
+		Field:      field,
+		Value:      dateTime.UTC(),
+		RecordedBy: caller.EnrollmentID,
+		RecordedOn: txDateTime,
 	}
 
-	return false, nil
+	err3 := newRepository(ctx).PutHistoricalDate(ctx.GetStub().GetTxID(), &data)
+	if err3 != nil {
+		return time.Time{}, fmt.Errorf("parseHistoricalDate >> %s", err3.Error())
+	}
+
+	return dateTime.UTC(), nil
+}
+
+// contractapi validates the returned estate even when an error is returned,
+// a null requests array would hide the error behind a schema mismatch
+func (e Estate) MarshalJSON() ([]byte, error) {
+
+	type estate Estate
+	if e.Requests == nil {
+		e.Requests = []Request{}
+	}
+	if e.Owners == nil {
+		e.Owners = []Share{}
+	}
+	if e.Parents == nil {
+		e.Parents = []string{}
+	}
+	if e.Children == nil {
+		e.Children = []string{}
+	}
+
+	return json.Marshal(estate(e))
+}
+
+// same as for Estate, for the bids
+func (a Auction) MarshalJSON() ([]byte, error) {
+
+	type auction Auction
+	if a.Bids == nil {
+		a.Bids = []Bid{}
+	}
+
+	return json.Marshal(auction(a))
+}
+
+// same as for Estate, for transactions stored before co-ownership was added
+func (t Transaction) MarshalJSON() ([]byte, error) {
+
+	type transaction Transaction
+	if t.Shares == nil {
+		t.Shares = []Share{}
+	}
+	if t.Consents == nil {
+		t.Consents = []string{}
+	}
+	if t.Heirs == nil {
+		t.Heirs = []Share{}
+	}
+
+	return json.Marshal(transaction(t))
+}
+
+// same as for Estate, for errors of the functions returning a user
+func (u User) MarshalJSON() ([]byte, error) {
+
+	type user User
+	if u.Owned == nil {
+		u.Owned = []string{}
+	}
+	if u.Requested == nil {
+		u.Requested = []Request_Buyer{}
+	}
+
+	return json.Marshal(user(u))
+}
+
+// same as for Estate, for the heirs and objections
+func (s Succession) MarshalJSON() ([]byte, error) {
+
+	type succession Succession
+	if s.Heirs == nil {
+		s.Heirs = []Share{}
+	}
+	if s.Objections == nil {
+		s.Objections = []Objection{}
+	}
+
+	return json.Marshal(succession(s))
 }
 
 // remove this function in future and use builtin methods
@@ -158,3 +724,39 @@ func searchArray(arr []string, val string) int {
 	}
 	return -1
 }
+
+func searchApproval(arr []Approval_Ref, serveyNo string, transactionCount int) int {
+	for i, ref := range arr {
+		if ref.ServeyNo == serveyNo && ref.TransactionCount == transactionCount && ref.LeaseID == "" {
+			return i
+		}
+	}
+	return -1
+}
+
+func searchLeaseApproval(arr []Approval_Ref, serveyNo string, leaseID string) int {
+	for i, ref := range arr {
+		if ref.ServeyNo == serveyNo && ref.LeaseID == leaseID {
+			return i
+		}
+	}
+	return -1
+}
+
+func searchShare(arr []Share, uid string) int {
+	for i, share := range arr {
+		if share.UID == uid {
+			return i
+		}
+	}
+	return -1
+}
+
+func searchRequest(arr []Request, buyer string) int {
+	for i, r := range arr {
+		if r.Buyer == buyer {
+			return i
+		}
+	}
+	return -1
+}
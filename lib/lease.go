@@ -0,0 +1,550 @@
+package lib
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Lease
+//
+// A co-owner lets a verified, listed or registered estate to a verified user
+// with RegisterLease_Estate, under a lease or a leave and license agreement.
+// Rent and deposit are private like prices, see privacy.go. The lease waits
+// in toApprove of the sub-registrar of the estate's office like a sale, and
+// is active once approved, a lease does not change the owners or the state of
+// the estate.
+//
+//	pending --approve--> active --terminate--> terminated
+//	pending --reject-->  rejected      active --expire--> expired
+//
+// Terms of an estate's leases do not overlap. A renewal is a new lease that
+// starts when the renewed one ends, it is approved like any other lease.
+
+const (
+	LeaseKind_Lease           = "lease"
+	LeaseKind_LeaveAndLicense = "leaveAndLicense"
+)
+
+const (
+	LeaseStatus_Pending    = "pending"
+	LeaseStatus_Active     = "active"
+	LeaseStatus_Rejected   = "rejected"
+	LeaseStatus_Terminated = "terminated"
+	LeaseStatus_Expired    = "expired"
+)
+
+// startsOn is an RFC3339 date, rent and deposit are read from the transient
+// map, see privacy.go
+func (s *SmartContract) RegisterLease_Estate(ctx contractapi.TransactionContextInterface, serveyNo string, lessee string, kind string, startsOn string, months int) (Lease, error) {
+
+	repo := newRepository(ctx)
+
+	estate, err0 := repo.GetEstate(serveyNo)
+	if err0 != nil {
+		return Lease{}, fmt.Errorf("RegisterLease_Estate >> %s", err0.Error())
+	}
+
+	_, lessor, err1 := s.authorizeOwner(ctx, "RegisterLease_Estate", estate)
+	if err1 != nil {
+		return Lease{}, err1
+	}
+
+	if kind != LeaseKind_Lease && kind != LeaseKind_LeaveAndLicense {
+		return Lease{}, fmt.Errorf("RegisterLease_Estate >> kind must be %s or %s", LeaseKind_Lease, LeaseKind_LeaveAndLicense)
+	}
+
+	temp_startsOn, err2 := time.Parse(time.RFC3339, startsOn)
+	if err2 != nil {
+		return Lease{}, fmt.Errorf("RegisterLease_Estate >> startsOn is not a valid RFC3339 date. %s", err2.Error())
+	}
+
+	if isOwner(estate, lessee) {
+		return Lease{}, fmt.Errorf("RegisterLease_Estate >> Estate can not be let to its owner %s", lessee)
+	}
+
+	data := Lease{
+		ID:         ctx.GetStub().GetTxID(),
+		Kind:       kind,
+		Lessor:     lessor,
+		Lessee:     lessee,
+		OfficeCode: estate.OfficeCode,
+		StartsOn:   temp_startsOn.UTC(),
+	}
+
+	err3 := s.submitLease(ctx, "RegisterLease_Estate", serveyNo, estate, &data, months)
+	if err3 != nil {
+		return Lease{}, err3
+	}
+
+	return data, nil
+}
+
+// new lease to the same lessee starting when leaseID ends, rent and deposit
+// are read from the transient map
+func (s *SmartContract) RenewLease_Estate(ctx contractapi.TransactionContextInterface, serveyNo string, leaseID string, months int) (Lease, error) {
+
+	repo := newRepository(ctx)
+
+	estate, err0 := repo.GetEstate(serveyNo)
+	if err0 != nil {
+		return Lease{}, fmt.Errorf("RenewLease_Estate >> %s", err0.Error())
+	}
+
+	_, lessor, err1 := s.authorizeOwner(ctx, "RenewLease_Estate", estate)
+	if err1 != nil {
+		return Lease{}, err1
+	}
+
+	lease, err2 := repo.GetLease(serveyNo, leaseID)
+	if err2 != nil {
+		return Lease{}, fmt.Errorf("RenewLease_Estate >> %s", err2.Error())
+	}
+
+	if lease.Status != LeaseStatus_Active {
+		return Lease{}, fmt.Errorf("RenewLease_Estate >> Lease %s is %s, only active leases are renewed", leaseID, lease.Status)
+	}
+
+	data := Lease{
+		ID:         ctx.GetStub().GetTxID(),
+		Kind:       lease.Kind,
+		Lessor:     lessor,
+		Lessee:     lease.Lessee,
+		OfficeCode: estate.OfficeCode,
+		StartsOn:   lease.EndsOn,
+		RenewalOf:  leaseID,
+	}
+
+	err3 := s.submitLease(ctx, "RenewLease_Estate", serveyNo, estate, &data, months)
+	if err3 != nil {
+		return Lease{}, err3
+	}
+
+	return data, nil
+}
+
+// ApproveLease_Estate and RejectLease_Estate, like ApproveSell_Estate, fail and
+// log attempts by the sub-registrar of another office
+
+func (s *SmartContract) ApproveLease_Estate(ctx contractapi.TransactionContextInterface, serveyNo string, leaseID string) (Lease, error) {
+
+	repo := newRepository(ctx)
+
+	estate, lease, admin, err0 := s.getPendingLease(ctx, "ApproveLease_Estate", serveyNo, leaseID)
+	if err0 != nil {
+		return Lease{}, err0
+	}
+
+	// only sub-registrar of the office the lease was submitted in
+	caller, err1 := s.authorizeSubRegistrar(ctx, "ApproveLease_Estate", lease.OfficeCode)
+	if err1 != nil {
+		return Lease{}, s.recordViolation(ctx, err1, serveyNo, lease.OfficeCode)
+	}
+
+	//=====================================
+
+	err2 := transitionEstate(ctx, "ApproveLease_Estate", serveyNo, estate, Action_Lease)
+	if err2 != nil {
+		return Lease{}, err2
+	}
+
+	if !isOwner(estate, lease.Lessor) {
+		return Lease{}, &Validation_Error{
+			Code:     ValidationCode_NotOwned,
+			Function: "ApproveLease_Estate",
+			ServeyNo: serveyNo,
+			Message:  fmt.Sprintf("Estate is not owned by lessor %s", lease.Lessor),
+		}
+	}
+
+	lessee, err3 := repo.GetUser(lease.Lessee)
+	if err3 != nil {
+		return Lease{}, fmt.Errorf("ApproveLease_Estate >> %s", err3.Error())
+	}
+
+	if lessee.Status != 1 {
+		return Lease{}, &Validation_Error{
+			Code:     ValidationCode_NotVerified,
+			Function: "ApproveLease_Estate",
+			ServeyNo: serveyNo,
+			Message:  fmt.Sprintf("Lessee %s is not verified", lease.Lessee),
+		}
+	}
+
+	temp_dateTime, err4 := getTxDateTime(ctx)
+	if err4 != nil {
+		return Lease{}, fmt.Errorf("ApproveLease_Estate >> %s", err4.Error())
+	}
+
+	if !temp_dateTime.Before(lease.EndsOn) {
+		return Lease{}, &Validation_Error{
+			Code:     ValidationCode_Expired,
+			Function: "ApproveLease_Estate",
+			ServeyNo: serveyNo,
+			Message:  fmt.Sprintf("Lease %s ended on %s", leaseID, lease.EndsOn.Format(time.RFC3339)),
+		}
+	}
+
+	//=====================================
+
+	admin, _ = s.removeLease(admin, serveyNo, leaseID)
+
+	lease.Status = LeaseStatus_Active
+	lease.ApprovedBy = admin.UID
+	lease.ApprovedDateTime = temp_dateTime
+
+	err5 := repo.PutLease(serveyNo, lease)
+	if err5 != nil {
+		return Lease{}, fmt.Errorf("ApproveLease_Estate >> %s", err5.Error())
+	}
+
+	err6 := repo.PutAdmin(caller.Office, admin)
+	if err6 != nil {
+		return Lease{}, fmt.Errorf("ApproveLease_Estate >> %s", err6.Error())
+	}
+
+	addEvent(ctx, EventType_LeaseApproved, Lease_Event{
+		ServeyNo: serveyNo,
+		Lease:    *lease,
+	})
+
+	return *lease, nil
+}
+
+func (s *SmartContract) RejectLease_Estate(ctx contractapi.TransactionContextInterface, serveyNo string, leaseID string) (Lease, error) {
+
+	repo := newRepository(ctx)
+
+	_, lease, admin, err0 := s.getPendingLease(ctx, "RejectLease_Estate", serveyNo, leaseID)
+	if err0 != nil {
+		return Lease{}, err0
+	}
+
+	caller, err1 := s.authorizeSubRegistrar(ctx, "RejectLease_Estate", lease.OfficeCode)
+	if err1 != nil {
+		return Lease{}, s.recordViolation(ctx, err1, serveyNo, lease.OfficeCode)
+	}
+
+	temp_dateTime, err2 := getTxDateTime(ctx)
+	if err2 != nil {
+		return Lease{}, fmt.Errorf("RejectLease_Estate >> %s", err2.Error())
+	}
+
+	admin, _ = s.removeLease(admin, serveyNo, leaseID)
+
+	lease.Status = LeaseStatus_Rejected
+	lease.EndedOn = temp_dateTime
+
+	err3 := repo.PutLease(serveyNo, lease)
+	if err3 != nil {
+		return Lease{}, fmt.Errorf("RejectLease_Estate >> %s", err3.Error())
+	}
+
+	err4 := repo.PutAdmin(caller.Office, admin)
+	if err4 != nil {
+		return Lease{}, fmt.Errorf("RejectLease_Estate >> %s", err4.Error())
+	}
+
+	addEvent(ctx, EventType_LeaseRejected, Lease_Event{
+		ServeyNo: serveyNo,
+		Lease:    *lease,
+	})
+
+	return *lease, nil
+}
+
+// early termination of an active lease by a co-owner or the lessee
+func (s *SmartContract) TerminateLease_Estate(ctx contractapi.TransactionContextInterface, serveyNo string, leaseID string) (Lease, error) {
+
+	repo := newRepository(ctx)
+
+	estate, err0 := repo.GetEstate(serveyNo)
+	if err0 != nil {
+		return Lease{}, fmt.Errorf("TerminateLease_Estate >> %s", err0.Error())
+	}
+
+	caller, uid, err1 := s.authorizeUser(ctx, "TerminateLease_Estate")
+	if err1 != nil {
+		return Lease{}, err1
+	}
+
+	lease, err2 := repo.GetLease(serveyNo, leaseID)
+	if err2 != nil {
+		return Lease{}, fmt.Errorf("TerminateLease_Estate >> %s", err2.Error())
+	}
+
+	if !isOwner(estate, uid) && lease.Lessee != uid {
+		return Lease{}, &Access_Error{
+			Code:     AccessCode_NotOwner,
+			Function: "TerminateLease_Estate",
+			Caller:   caller.EnrollmentID,
+			Allowed:  []string{Access_Owner, Access_Buyer},
+			Message:  fmt.Sprintf("Neither estate nor lease is held by %s", uid),
+		}
+	}
+
+	temp_dateTime, err3 := getTxDateTime(ctx)
+	if err3 != nil {
+		return Lease{}, fmt.Errorf("TerminateLease_Estate >> %s", err3.Error())
+	}
+
+	if lease.Status != LeaseStatus_Active || !temp_dateTime.Before(lease.EndsOn) {
+		return Lease{}, fmt.Errorf("TerminateLease_Estate >> Lease %s is not active", leaseID)
+	}
+
+	lease.Status = LeaseStatus_Terminated
+	lease.EndedOn = temp_dateTime
+
+	err4 := repo.PutLease(serveyNo, lease)
+	if err4 != nil {
+		return Lease{}, fmt.Errorf("TerminateLease_Estate >> %s", err4.Error())
+	}
+
+	addEvent(ctx, EventType_LeaseTerminated, Lease_Event{
+		ServeyNo: serveyNo,
+		Lease:    *lease,
+	})
+
+	return *lease, nil
+}
+
+// marks an active lease past its end as expired, by a party or an admin
+func (s *SmartContract) ExpireLease_Estate(ctx contractapi.TransactionContextInterface, serveyNo string, leaseID string) (Lease, error) {
+
+	repo := newRepository(ctx)
+
+	lease, err0 := repo.GetLease(serveyNo, leaseID)
+	if err0 != nil {
+		return Lease{}, fmt.Errorf("ExpireLease_Estate >> %s", err0.Error())
+	}
+
+	estate, err1 := repo.GetEstate(serveyNo)
+	if err1 != nil {
+		return Lease{}, fmt.Errorf("ExpireLease_Estate >> %s", err1.Error())
+	}
+
+	_, err2 := s.authorizeParty(ctx, "ExpireLease_Estate", lease.OfficeCode, append(estateOwnerUIDs(estate), lease.Lessee)...)
+	if err2 != nil {
+		return Lease{}, err2
+	}
+
+	temp_dateTime, err3 := getTxDateTime(ctx)
+	if err3 != nil {
+		return Lease{}, fmt.Errorf("ExpireLease_Estate >> %s", err3.Error())
+	}
+
+	if lease.Status != LeaseStatus_Active {
+		return Lease{}, fmt.Errorf("ExpireLease_Estate >> Lease %s is %s, not active", leaseID, lease.Status)
+	}
+
+	if temp_dateTime.Before(lease.EndsOn) {
+		return Lease{}, fmt.Errorf("ExpireLease_Estate >> Lease %s ends on %s", leaseID, lease.EndsOn.Format(time.RFC3339))
+	}
+
+	lease.Status = LeaseStatus_Expired
+	lease.EndedOn = lease.EndsOn
+
+	err4 := repo.PutLease(serveyNo, lease)
+	if err4 != nil {
+		return Lease{}, fmt.Errorf("ExpireLease_Estate >> %s", err4.Error())
+	}
+
+	addEvent(ctx, EventType_LeaseExpired, Lease_Event{
+		ServeyNo: serveyNo,
+		Lease:    *lease,
+	})
+
+	return *lease, nil
+}
+
+// rent and deposit of a lease, only for its parties and the admins of its office
+func (s *SmartContract) GetLeaseTerms(ctx contractapi.TransactionContextInterface, serveyNo string, leaseID string) (Private_Lease, error) {
+
+	repo := newRepository(ctx)
+
+	lease, err0 := repo.GetLease(serveyNo, leaseID)
+	if err0 != nil {
+		return Private_Lease{}, fmt.Errorf("GetLeaseTerms >> %s", err0.Error())
+	}
+
+	_, err1 := s.authorizeParty(ctx, "GetLeaseTerms", lease.OfficeCode, lease.Lessor, lease.Lessee)
+	if err1 != nil {
+		return Private_Lease{}, err1
+	}
+
+	key, err2 := repo.LeaseKey(serveyNo, leaseID)
+	if err2 != nil {
+		return Private_Lease{}, fmt.Errorf("GetLeaseTerms >> %s", err2.Error())
+	}
+
+	terms := Private_Lease{}
+	err3 := s.getPrivateData(ctx, lease.OfficeCode, key, lease.TermsHash, &terms)
+	if err3 != nil {
+		return Private_Lease{}, fmt.Errorf("GetLeaseTerms >> %s", err3.Error())
+	}
+
+	return terms, nil
+}
+
+// ------------------------------------
+
+// Helper Functions - Private
+
+// completes a new lease with its term and private rent and deposit, and puts
+// it in toApprove of the admin of its office
+func (s *SmartContract) submitLease(ctx contractapi.TransactionContextInterface, fname string, serveyNo string, estate *Estate, lease *Lease, months int) error {
+
+	err0 := transitionEstate(ctx, fname, serveyNo, estate, Action_Lease)
+	if err0 != nil {
+		return err0
+	}
+
+	if months <= 0 {
+		return fmt.Errorf("%s >> months must be greater than 0", fname)
+	}
+
+	private, err1 := getPrivate(ctx, fname)
+	if err1 != nil {
+		return err1
+	}
+
+	if private.Rent < 0 || private.Deposit < 0 {
+		return fmt.Errorf("%s >> rent and deposit can not be negative", fname)
+	}
+
+	repo := newRepository(ctx)
+
+	lessee, err2 := repo.GetUser(lease.Lessee)
+	if err2 != nil {
+		return fmt.Errorf("%s >> %s", fname, err2.Error())
+	}
+
+	if lessee.Status != 1 {
+		return &Validation_Error{
+			Code:     ValidationCode_NotVerified,
+			Function: fname,
+			ServeyNo: serveyNo,
+			Message:  fmt.Sprintf("Lessee %s is not verified", lease.Lessee),
+		}
+	}
+
+	temp_dateTime, err3 := getTxDateTime(ctx)
+	if err3 != nil {
+		return fmt.Errorf("%s >> %s", fname, err3.Error())
+	}
+
+	lease.EndsOn = lease.StartsOn.AddDate(0, months, 0)
+	lease.Status = LeaseStatus_Pending
+	lease.SubmittedOn = temp_dateTime
+
+	if !temp_dateTime.Before(lease.EndsOn) {
+		return fmt.Errorf("%s >> Lease would end on %s, before it is submitted", fname, lease.EndsOn.Format(time.RFC3339))
+	}
+
+	// pending and active leases do not overlap
+	leases, err4 := repo.GetLeases(serveyNo)
+	if err4 != nil {
+		return fmt.Errorf("%s >> %s", fname, err4.Error())
+	}
+
+	for _, other := range leases {
+		if other.Status != LeaseStatus_Pending && other.Status != LeaseStatus_Active {
+			continue
+		}
+		if lease.StartsOn.Before(other.EndsOn) && other.StartsOn.Before(lease.EndsOn) {
+			return fmt.Errorf("%s >> Term overlaps lease %s from %s to %s", fname, other.ID, other.StartsOn.Format(time.RFC3339), other.EndsOn.Format(time.RFC3339))
+		}
+	}
+
+	//=====================================
+
+	key, err5 := repo.LeaseKey(serveyNo, lease.ID)
+	if err5 != nil {
+		return fmt.Errorf("%s >> %s", fname, err5.Error())
+	}
+
+	termsHash, err6 := s.putPrivateData(ctx, lease.OfficeCode, key, Private_Lease{Rent: private.Rent, Deposit: private.Deposit, Salt: private.Salt}, lease.Lessor, lease.Lessee)
+	if err6 != nil {
+		return fmt.Errorf("%s >> %s", fname, err6.Error())
+	}
+
+	lease.TermsHash = termsHash
+
+	err7 := repo.PutLease(serveyNo, lease)
+	if err7 != nil {
+		return fmt.Errorf("%s >> %s", fname, err7.Error())
+	}
+
+	admin, err8 := repo.GetAdmin(lease.OfficeCode)
+	if err8 != nil {
+		return fmt.Errorf("%s >> %s", fname, err8.Error())
+	}
+
+	admin.ToApprove = append(admin.ToApprove, Approval_Ref{
+		ServeyNo: serveyNo,
+		LeaseID:  lease.ID,
+	})
+
+	err9 := repo.PutAdmin(lease.OfficeCode, admin)
+	if err9 != nil {
+		return fmt.Errorf("%s >> %s", fname, err9.Error())
+	}
+
+	addEvent(ctx, EventType_LeaseSubmitted, Lease_Event{
+		ServeyNo: serveyNo,
+		Lease:    *lease,
+	})
+
+	return nil
+}
+
+// lease waiting for approval, with the estate and the admin record of the
+// office holding it in ToApprove
+func (s *SmartContract) getPendingLease(ctx contractapi.TransactionContextInterface, fname string, serveyNo string, leaseID string) (*Estate, *Lease, *Admin_OfficeCode, error) {
+
+	repo := newRepository(ctx)
+
+	estate, err0 := repo.GetEstate(serveyNo)
+	if err0 != nil {
+		return nil, nil, nil, fmt.Errorf("%s >> %s", fname, err0.Error())
+	}
+
+	lease, err1 := repo.GetLease(serveyNo, leaseID)
+	if err1 != nil {
+		return nil, nil, nil, fmt.Errorf("%s >> %s", fname, err1.Error())
+	}
+
+	if lease.Status != LeaseStatus_Pending {
+		return nil, nil, nil, &Validation_Error{
+			Code:     ValidationCode_NotPending,
+			Function: fname,
+			ServeyNo: serveyNo,
+			Message:  fmt.Sprintf("Lease %s is %s, not waiting for approval", leaseID, lease.Status),
+		}
+	}
+
+	admin, err2 := repo.GetAdmin(lease.OfficeCode)
+	if err2 != nil {
+		return nil, nil, nil, fmt.Errorf("%s >> %s", fname, err2.Error())
+	}
+
+	if searchLeaseApproval(admin.ToApprove, serveyNo, leaseID) == -1 {
+		return nil, nil, nil, &Validation_Error{
+			Code:     ValidationCode_MissingEntry,
+			Function: fname,
+			ServeyNo: serveyNo,
+			Message:  fmt.Sprintf("Lease %s is not in toApprove of %s", leaseID, lease.OfficeCode),
+		}
+	}
+
+	return estate, lease, admin, nil
+}
+
+func (s *SmartContract) removeLease(admin *Admin_OfficeCode, serveyNo string, leaseID string) (*Admin_OfficeCode, bool) {
+
+	toApprove, found := removeLeaseApproval(admin.ToApprove, serveyNo, leaseID)
+	admin.ToApprove = toApprove
+
+	return admin, found
+}
@@ -0,0 +1,271 @@
+package lib
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Lifecycle
+//
+// An estate is always in exactly one State. Every transaction acting on an
+// estate names its action, estateTransitions maps the action to the states it
+// is allowed from and the state it leads to. Actions not changing the state
+// (request, modify, clearRequests, lease) map a state to itself.
+//
+//	Draft               --verify-->  Verified  --list-->    Listed
+//	Listed              --accept-->  UnderContract
+//	Verified, Listed, Registered --transferShare--> UnderContract
+//	Verified, Listed, Registered --gift-->          UnderContract
+//	UnderContract       --submit-->  PendingRegistration, once co-owners consented
+//	                                 or the recipient accepted the gift
+//	PendingRegistration --approve--> Registered --list--> Listed
+//	PendingRegistration --reject-->  Listed     --delist--> Verified
+//	UnderContract, PendingRegistration --withdraw--> Listed
+//	                                 share transfers and gifts go back to the
+//	                                 state they started from on reject or withdraw
+//	Listed              --startAuction--> Auction --settle-->       UnderContract
+//	                                      Auction --closeAuction--> Listed
+//	Verified, Listed, Registered --declareHeirs--> PendingSuccession
+//	PendingSuccession   --approveSuccession--> Registered
+//	PendingSuccession   --rejectSuccession-->  Verified
+//	Verified, Registered --subdivide-->  Retired, the parts are created Verified
+//	Verified, Registered --amalgamate--> Retired, the merged estate is created Verified
+//
+// Draft, Verified, Listed and Registered estates can be frozen, frozen
+// estates have to be verified again.
+
+const (
+	State_Draft               = "Draft"               // created, not verified
+	State_Verified            = "Verified"            // verified, not for sale
+	State_Listed              = "Listed"              // for sale, takes requests
+	State_UnderContract       = "UnderContract"       // request accepted by a co-owner, waiting for consent
+	State_PendingRegistration = "PendingRegistration" // waiting for the sub-registrar
+	State_Registered          = "Registered"          // last sale registered
+	State_Frozen              = "Frozen"              // suspended
+	State_Auction             = "Auction"             // takes bids until the auction is settled
+	State_PendingSuccession   = "PendingSuccession"   // heirs declared, waiting for the sub-registrar
+	State_Retired             = "Retired"             // subdivided or amalgamated, kept for its history
+)
+
+const (
+	Action_Create        = "create"
+	Action_Modify        = "modify"
+	Action_Verify        = "verify"
+	Action_Unverify      = "unverify"
+	Action_Freeze        = "freeze"
+	Action_List          = "list"
+	Action_Delist        = "delist"
+	Action_Request       = "request"
+	Action_ClearRequests = "clearRequests"
+	Action_Accept        = "accept"
+	Action_Submit        = "submit"
+	Action_Approve       = "approve"
+	Action_Reject        = "reject"
+	Action_Withdraw      = "withdraw"
+	Action_StartAuction  = "startAuction"
+	Action_Bid           = "bid"
+	Action_Settle        = "settle"
+	Action_CloseAuction  = "closeAuction"
+	Action_TransferShare = "transferShare"
+	Action_Lease         = "lease"
+	Action_Gift          = "gift"
+
+	Action_DeclareHeirs      = "declareHeirs"
+	Action_ApproveSuccession = "approveSuccession"
+	Action_RejectSuccession  = "rejectSuccession"
+
+	Action_Subdivide  = "subdivide"
+	Action_Amalgamate = "amalgamate"
+)
+
+// action -> from state -> to state
+var estateTransitions = map[string]map[string]string{
+	Action_Create: {
+		"": State_Draft,
+	},
+	Action_Modify: {
+		State_Draft:      State_Draft,
+		State_Verified:   State_Verified,
+		State_Registered: State_Registered,
+		State_Frozen:     State_Frozen,
+	},
+	Action_Verify: {
+		State_Draft:  State_Verified,
+		State_Frozen: State_Verified,
+	},
+	Action_Unverify: {
+		State_Verified:   State_Draft,
+		State_Registered: State_Draft,
+		State_Frozen:     State_Draft,
+	},
+	Action_Freeze: {
+		State_Draft:      State_Frozen,
+		State_Verified:   State_Frozen,
+		State_Listed:     State_Frozen,
+		State_Registered: State_Frozen,
+	},
+	Action_List: {
+		State_Verified:   State_Listed,
+		State_Registered: State_Listed,
+	},
+	Action_Delist: {
+		State_Listed: State_Verified,
+	},
+	Action_Request: {
+		State_Listed: State_Listed,
+	},
+	Action_ClearRequests: {
+		State_Draft:             State_Draft,
+		State_Verified:          State_Verified,
+		State_Listed:            State_Listed,
+		State_Registered:        State_Registered,
+		State_Frozen:            State_Frozen,
+		State_PendingSuccession: State_PendingSuccession,
+	},
+	Action_Accept: {
+		State_Listed: State_UnderContract,
+	},
+	Action_Submit: {
+		State_UnderContract: State_PendingRegistration,
+	},
+	Action_Approve: {
+		State_PendingRegistration: State_Registered,
+	},
+	Action_Reject: {
+		State_PendingRegistration: State_Listed,
+	},
+	Action_Withdraw: {
+		State_UnderContract:       State_Listed,
+		State_PendingRegistration: State_Listed,
+	},
+	Action_StartAuction: {
+		State_Listed: State_Auction,
+	},
+	Action_Bid: {
+		State_Auction: State_Auction,
+	},
+	Action_Settle: {
+		State_Auction: State_UnderContract,
+	},
+	Action_CloseAuction: {
+		State_Auction: State_Listed,
+	},
+	Action_TransferShare: {
+		State_Verified:   State_UnderContract,
+		State_Listed:     State_UnderContract,
+		State_Registered: State_UnderContract,
+	},
+	Action_Gift: {
+		State_Verified:   State_UnderContract,
+		State_Listed:     State_UnderContract,
+		State_Registered: State_UnderContract,
+	},
+	Action_Lease: {
+		State_Verified:   State_Verified,
+		State_Listed:     State_Listed,
+		State_Registered: State_Registered,
+	},
+	Action_DeclareHeirs: {
+		State_Verified:   State_PendingSuccession,
+		State_Listed:     State_PendingSuccession,
+		State_Registered: State_PendingSuccession,
+	},
+	Action_ApproveSuccession: {
+		State_PendingSuccession: State_Registered,
+	},
+	Action_RejectSuccession: {
+		State_PendingSuccession: State_Verified,
+	},
+	Action_Subdivide: {
+		"":               State_Verified,
+		State_Verified:   State_Retired,
+		State_Registered: State_Retired,
+	},
+	Action_Amalgamate: {
+		"":               State_Verified,
+		State_Verified:   State_Retired,
+		State_Registered: State_Retired,
+	},
+}
+
+// ------------------------------------
+
+// Helper Functions - Private
+
+// moves the estate to the state the action leads to, the caller still has to
+// put the estate
+func transitionEstate(ctx contractapi.TransactionContextInterface, fname string, serveyNo string, estate *Estate, action string) error {
+
+	to, ok := estateTransitions[action][estate.State]
+	if !ok {
+		return invalidTransition(fname, serveyNo, estate, action)
+	}
+
+	setEstateState(ctx, serveyNo, estate, action, to)
+
+	return nil
+}
+
+// like transitionEstate, but back to fromState, the state the pending
+// transaction started from, if it is set (Transaction.FromState)
+func revertEstate(ctx contractapi.TransactionContextInterface, fname string, serveyNo string, estate *Estate, action string, fromState string) error {
+
+	to, ok := estateTransitions[action][estate.State]
+	if !ok {
+		return invalidTransition(fname, serveyNo, estate, action)
+	}
+
+	if fromState != "" {
+		to = fromState
+	}
+
+	setEstateState(ctx, serveyNo, estate, action, to)
+
+	return nil
+}
+
+func invalidTransition(fname string, serveyNo string, estate *Estate, action string) error {
+	return &Validation_Error{
+		Code:     ValidationCode_InvalidState,
+		Function: fname,
+		ServeyNo: serveyNo,
+		Message:  fmt.Sprintf("Estate is %s, %s is only allowed when it is %s", estateStateName(estate.State), action, strings.Join(allowedStates(action), " or ")),
+	}
+}
+
+func setEstateState(ctx contractapi.TransactionContextInterface, serveyNo string, estate *Estate, action string, to string) {
+
+	if to != estate.State {
+		addEvent(ctx, EventType_EstateStateChanged, Estate_State_Event{
+			ServeyNo: serveyNo,
+			Action:   action,
+			From:     estate.State,
+			To:       to,
+		})
+	}
+
+	estate.State = to
+}
+
+// sorted, map order is random and the states end up in the error returned
+// to the client. Writes need no order, Fabric keeps them by key
+func allowedStates(action string) []string {
+
+	states := []string{}
+	for from := range estateTransitions[action] {
+		states = append(states, estateStateName(from))
+	}
+	sort.Strings(states)
+
+	return states
+}
+
+func estateStateName(state string) string {
+	if state == "" {
+		return "not created"
+	}
+	return state
+}
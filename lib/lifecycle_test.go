@@ -0,0 +1,161 @@
+package lib
+
+import (
+	"reflect"
+	"testing"
+)
+
+var estateStates = []string{
+	State_Draft,
+	State_Verified,
+	State_Listed,
+	State_UnderContract,
+	State_PendingRegistration,
+	State_Registered,
+	State_Frozen,
+	State_Auction,
+	State_PendingSuccession,
+	State_Retired,
+}
+
+func TestEstateTransitionsUseKnownStates(t *testing.T) {
+
+	for action, transitions := range estateTransitions {
+		for from, to := range transitions {
+			if from != "" && searchArray(estateStates, from) == -1 {
+				t.Errorf("%s is allowed from unknown state %q", action, from)
+			}
+			if searchArray(estateStates, to) == -1 {
+				t.Errorf("%s leads to unknown state %q", action, to)
+			}
+		}
+	}
+}
+
+func TestSaleLifecycle(t *testing.T) {
+
+	steps := []struct {
+		action string
+		to     string
+	}{
+		{Action_Create, State_Draft},
+		{Action_Verify, State_Verified},
+		{Action_List, State_Listed},
+		{Action_Request, State_Listed},
+		{Action_Accept, State_UnderContract},
+		{Action_Submit, State_PendingRegistration},
+		{Action_Approve, State_Registered},
+		{Action_List, State_Listed},
+		{Action_Delist, State_Verified},
+	}
+
+	ctx := new(TransactionContext)
+	estate := &Estate{}
+
+	for _, step := range steps {
+		err := transitionEstate(ctx, "TestSaleLifecycle", "100", estate, step.action)
+		if err != nil {
+			t.Fatalf("%s: %s", step.action, err.Error())
+		}
+		if estate.State != step.to {
+			t.Fatalf("%s led to %s, want %s", step.action, estate.State, step.to)
+		}
+	}
+
+	// request and list of a listed estate do not change the state
+	changed := 0
+	for _, event := range ctx.events {
+		if event.Type == EventType_EstateStateChanged {
+			changed++
+		}
+	}
+	if changed != len(steps)-1 {
+		t.Errorf("%d state changes emitted, want %d", changed, len(steps)-1)
+	}
+}
+
+func TestTransitionEstateInvalid(t *testing.T) {
+
+	tests := []struct {
+		state  string
+		action string
+	}{
+		{State_Draft, Action_List},
+		{State_Verified, Action_Accept},
+		{State_Listed, Action_Submit},
+		{State_UnderContract, Action_Approve},
+		{State_PendingRegistration, Action_Accept},
+		{State_Frozen, Action_List},
+		{State_Auction, Action_Request},
+		{State_Retired, Action_Verify},
+	}
+
+	for _, test := range tests {
+		ctx := new(TransactionContext)
+		estate := &Estate{State: test.state}
+
+		err := transitionEstate(ctx, "TestTransitionEstateInvalid", "100", estate, test.action)
+
+		validationErr, ok := err.(*Validation_Error)
+		if !ok || validationErr.Code != ValidationCode_InvalidState {
+			t.Errorf("%s from %s: got %v, want %s", test.action, test.state, err, ValidationCode_InvalidState)
+		}
+		if estate.State != test.state {
+			t.Errorf("%s from %s changed the state to %s", test.action, test.state, estate.State)
+		}
+		if len(ctx.events) != 0 {
+			t.Errorf("%s from %s emitted %d events", test.action, test.state, len(ctx.events))
+		}
+	}
+}
+
+func TestRevertEstate(t *testing.T) {
+
+	tests := []struct {
+		state     string
+		action    string
+		fromState string
+		to        string
+	}{
+		// sales go back to listed
+		{State_PendingRegistration, Action_Reject, "", State_Listed},
+		{State_UnderContract, Action_Withdraw, "", State_Listed},
+		{State_PendingRegistration, Action_Withdraw, "", State_Listed},
+		// share transfers and gifts go back to the state they started from
+		{State_PendingRegistration, Action_Reject, State_Registered, State_Registered},
+		{State_UnderContract, Action_Withdraw, State_Verified, State_Verified},
+	}
+
+	for _, test := range tests {
+		estate := &Estate{State: test.state}
+
+		err := revertEstate(new(TransactionContext), "TestRevertEstate", "100", estate, test.action, test.fromState)
+		if err != nil {
+			t.Fatalf("%s from %s: %s", test.action, test.state, err.Error())
+		}
+		if estate.State != test.to {
+			t.Errorf("%s from %s back to %q led to %s, want %s", test.action, test.state, test.fromState, estate.State, test.to)
+		}
+	}
+
+	// the action must still be allowed from the current state
+	estate := &Estate{State: State_Listed}
+	err := revertEstate(new(TransactionContext), "TestRevertEstate", "100", estate, Action_Reject, State_Registered)
+	if err == nil || estate.State != State_Listed {
+		t.Errorf("reject of a listed estate led to %s, err %v", estate.State, err)
+	}
+}
+
+func TestAllowedStates(t *testing.T) {
+
+	got := allowedStates(Action_Freeze)
+	want := []string{State_Draft, State_Listed, State_Registered, State_Verified}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if got := allowedStates(Action_Create); !reflect.DeepEqual(got, []string{"not created"}) {
+		t.Errorf("got %v for create", got)
+	}
+}
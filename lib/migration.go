@@ -0,0 +1,340 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Migration
+//
+// Ledgers written before composite keys were introduced keep their records
+// under plain keys (admin_super, admin_<officeCode>, user_<uid>, estate_<serveyNo>,
+// transaction_<serveyNo>_<n>, identity_<mspId>_<enrollmentId>, violation_<txId>,
+// historical_<txId>_<field>). Migrate_Keys moves them to the composite keys of
+// the Repository, one page at a time, and deletes the plain keys.
+//
+// The legacy identity index is deleted last: identity_ keys sort before
+// user_ keys, so they are passed over until every other key is migrated, the
+// last page of that pass returns NextStartKey "identity_" and the following
+// pages delete the index. The index is rebuilt from the migrated records,
+// until a record is migrated its identity is still found through the legacy
+// index.
+
+type Migration_Result struct {
+	Migrated     int      `json:"migrated"`
+	Skipped      []string `json:"skipped"`      // keys not belonging to any record type
+	NextStartKey string   `json:"nextStartKey"` // empty when every key is migrated
+}
+
+// admin record before ToApprove held Approval_Ref
+type legacy_Admin_OfficeCode struct {
+	Password           string   `json:"password"`
+	MustChangePassword bool     `json:"mustChangePassword"`
+	UID                string   `json:"uid"`
+	Name               string   `json:"name"`
+	ToApprove          []string `json:"toApprove"` // transaction_<serveyNo>_<n>
+	Identity           Identity `json:"identity"`
+}
+
+// estate fields replaced by Estate.State
+type legacy_Estate_Flags struct {
+	Status           int  `json:"status"` // 0/1/2 - Not verified/Verified/Suspended
+	SaleAvailability bool `json:"saleAvailability"`
+	BeingSold        bool `json:"beingSold"`
+}
+
+// estates written before the lifecycle have no state, it is derived from the
+// legacy flags wherever an estate is read, current state or history
+func (e *Estate) UnmarshalJSON(data []byte) error {
+
+	type estate Estate
+	err0 := json.Unmarshal(data, (*estate)(e))
+	if err0 != nil {
+		return err0
+	}
+
+	if e.State != "" {
+		return nil
+	}
+
+	legacy := legacy_Estate_Flags{}
+	err1 := json.Unmarshal(data, &legacy)
+	if err1 != nil {
+		return err1
+	}
+
+	switch {
+	case legacy.Status == 2:
+		e.State = State_Frozen
+	case legacy.Status != 1:
+		e.State = State_Draft
+	case legacy.BeingSold:
+		// accepted requests were submitted right away
+		e.State = State_PendingRegistration
+	case legacy.SaleAvailability:
+		e.State = State_Listed
+	default:
+		e.State = State_Verified
+	}
+
+	return nil
+}
+
+// ------------------------------------
+
+// run again with NextStartKey until it is empty
+func (s *SmartContract) Migrate_Keys(ctx contractapi.TransactionContextInterface, startKey string, pageSize int) (Migration_Result, error) {
+
+	result := Migration_Result{Skipped: []string{}}
+
+	_, err0 := s.authorizeSuperAdmin(ctx, "Migrate_Keys")
+	if err0 != nil {
+		return result, err0
+	}
+
+	if pageSize <= 0 {
+		return result, fmt.Errorf("Migrate_Keys >> pageSize must be greater than 0")
+	}
+
+	// the legacy identity index is only deleted once the records are migrated
+	identityPass := strings.HasPrefix(startKey, legacyIdentityPrefix)
+	endKey := ""
+	if identityPass {
+		endKey = legacyIdentityEnd
+	}
+
+	// range queries do not return composite keys, only legacy keys are visited
+	resultsIterator, err1 := ctx.GetStub().GetStateByRange(startKey, endKey)
+	if err1 != nil {
+		return result, fmt.Errorf("Migrate_Keys >> Failed to read from world state. %s", err1.Error())
+	}
+	defer resultsIterator.Close()
+
+	repo := newRepository(ctx)
+
+	for resultsIterator.HasNext() {
+		queryResponse, err2 := resultsIterator.Next()
+		if err2 != nil {
+			return result, fmt.Errorf("Migrate_Keys >> %s", err2.Error())
+		}
+
+		if !identityPass && strings.HasPrefix(queryResponse.Key, legacyIdentityPrefix) {
+			continue
+		}
+
+		if result.Migrated+len(result.Skipped) == pageSize {
+			result.NextStartKey = queryResponse.Key
+			break
+		}
+
+		migrated, err3 := migrateKey(repo, queryResponse.Key, queryResponse.Value)
+		if err3 != nil {
+			return result, fmt.Errorf("Migrate_Keys >> %s", err3.Error())
+		}
+
+		if !migrated {
+			result.Skipped = append(result.Skipped, queryResponse.Key)
+			continue
+		}
+
+		err4 := ctx.GetStub().DelState(queryResponse.Key)
+		if err4 != nil {
+			return result, fmt.Errorf("Migrate_Keys >> Failed to delete from world state. %s", err4.Error())
+		}
+
+		result.Migrated++
+	}
+
+	if !identityPass && result.NextStartKey == "" {
+		result.NextStartKey = legacyIdentityPrefix
+	}
+
+	return result, nil
+}
+
+// ------------------------------------
+
+// Helper Functions - Private
+
+const (
+	legacyIdentityPrefix = "identity_"
+	legacyIdentityEnd    = "identity`" // "`" sorts right after "_"
+)
+
+func legacyIdentityKey(identity Identity) string {
+	return legacyIdentityPrefix + identity.MSPID + "_" + identity.EnrollmentID
+}
+
+// writes the record of a legacy key under its composite key,
+// false for keys that do not belong to any record type
+func migrateKey(repo *Repository, key string, value []byte) (bool, error) {
+
+	switch {
+	case key == "admin_super":
+		admin := new(Admin_Super)
+		err0 := json.Unmarshal(value, admin)
+		if err0 != nil {
+			return false, fmt.Errorf("%s Can't Unmarshal Data", key)
+		}
+
+		err1 := repo.PutSuperAdmin(admin)
+		if err1 != nil {
+			return false, err1
+		}
+
+		return true, migrateIdentity(repo, admin.Identity, key)
+
+	case strings.HasPrefix(key, "admin_"):
+		legacy := new(legacy_Admin_OfficeCode)
+		err0 := json.Unmarshal(value, legacy)
+		if err0 != nil {
+			return false, fmt.Errorf("%s Can't Unmarshal Data", key)
+		}
+
+		admin := Admin_OfficeCode{
+			Password:           legacy.Password,
+			MustChangePassword: legacy.MustChangePassword,
+			UID:                legacy.UID,
+			Name:               legacy.Name,
+			ToApprove:          []Approval_Ref{},
+			Identity:           legacy.Identity,
+		}
+
+		for _, transactionKey := range legacy.ToApprove {
+			serveyNo, num, ok := splitLegacyTransactionKey(transactionKey)
+			if !ok {
+				return false, fmt.Errorf("%s has malformed toApprove entry %s", key, transactionKey)
+			}
+			admin.ToApprove = append(admin.ToApprove, Approval_Ref{ServeyNo: serveyNo, TransactionCount: num})
+		}
+
+		err1 := repo.PutAdmin(strings.TrimPrefix(key, "admin_"), &admin)
+		if err1 != nil {
+			return false, err1
+		}
+
+		return true, migrateIdentity(repo, admin.Identity, key)
+
+	case strings.HasPrefix(key, "user_"):
+		user := new(User)
+		err0 := json.Unmarshal(value, user)
+		if err0 != nil {
+			return false, fmt.Errorf("%s Can't Unmarshal Data", key)
+		}
+
+		err1 := repo.PutUser(strings.TrimPrefix(key, "user_"), user)
+		if err1 != nil {
+			return false, err1
+		}
+
+		return true, migrateIdentity(repo, user.Identity, key)
+
+	case strings.HasPrefix(key, "estate_"):
+		estate := new(Estate)
+		err0 := json.Unmarshal(value, estate)
+		if err0 != nil {
+			return false, fmt.Errorf("%s Can't Unmarshal Data", key)
+		}
+
+		return true, repo.PutEstate(strings.TrimPrefix(key, "estate_"), estate)
+
+	case strings.HasPrefix(key, "transaction_"):
+		serveyNo, num, ok := splitLegacyTransactionKey(key)
+		if !ok {
+			return false, nil
+		}
+
+		transaction := new(Transaction)
+		err0 := json.Unmarshal(value, transaction)
+		if err0 != nil {
+			return false, fmt.Errorf("%s Can't Unmarshal Data", key)
+		}
+
+		return true, repo.PutTransaction(serveyNo, num, transaction)
+
+	case strings.HasPrefix(key, legacyIdentityPrefix):
+		// rebuilt from the identity of the migrated records
+		return true, nil
+
+	case strings.HasPrefix(key, "violation_"):
+		violation := new(Jurisdiction_Violation)
+		err0 := json.Unmarshal(value, violation)
+		if err0 != nil {
+			return false, fmt.Errorf("%s Can't Unmarshal Data", key)
+		}
+
+		return true, repo.PutViolation(strings.TrimPrefix(key, "violation_"), violation)
+
+	case strings.HasPrefix(key, "historical_"):
+		historical := new(Historical_Date)
+		err0 := json.Unmarshal(value, historical)
+		if err0 != nil {
+			return false, fmt.Errorf("%s Can't Unmarshal Data", key)
+		}
+
+		// historical_<txId>_<field>, tx ids are hex
+		txID := strings.TrimSuffix(strings.TrimPrefix(key, "historical_"), "_"+historical.Field)
+
+		// the record the date is stored in is migrated too
+		recordKey, err1 := migratedRecordKey(repo, historical.Key)
+		if err1 != nil {
+			return false, err1
+		}
+		historical.Key = recordKey
+
+		return true, repo.PutHistoricalDate(txID, historical)
+	}
+
+	return false, nil
+}
+
+// composite key of the record under a legacy key, keys of other records are
+// kept as they are
+func migratedRecordKey(repo *Repository, key string) (string, error) {
+
+	switch {
+	case strings.HasPrefix(key, "estate_"):
+		return repo.EstateKey(strings.TrimPrefix(key, "estate_"))
+
+	case strings.HasPrefix(key, "user_"):
+		return repo.UserKey(strings.TrimPrefix(key, "user_"))
+
+	case strings.HasPrefix(key, "transaction_"):
+		serveyNo, num, ok := splitLegacyTransactionKey(key)
+		if ok {
+			return repo.TransactionKey(serveyNo, num)
+		}
+	}
+
+	return key, nil
+}
+
+func migrateIdentity(repo *Repository, identity Identity, username string) error {
+
+	if identity.EnrollmentID == "" {
+		return nil
+	}
+
+	return repo.PutIdentity(identity, username)
+}
+
+// transaction_<serveyNo>_<n>, serveyNo may itself contain "_"
+func splitLegacyTransactionKey(key string) (string, int, bool) {
+
+	rest := strings.TrimPrefix(key, "transaction_")
+	i := strings.LastIndex(rest, "_")
+	if i <= 0 {
+		return "", 0, false
+	}
+
+	num, err0 := strconv.Atoi(rest[i+1:])
+	if err0 != nil {
+		return "", 0, false
+	}
+
+	return rest[:i], num, true
+}
@@ -0,0 +1,292 @@
+package lib
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Negotiation
+//
+// A request is negotiated in rounds. RequestToBuy_Estate makes the buyer's
+// offer, CounterOffer_Estate answers the other party's offer with a price and
+// conditions, AcceptOffer_Estate agrees to it. Parties take turns, only the
+// offer of the other party can be countered or accepted.
+//
+// Every round is stored as an Offer under the request, the Request itself only
+// keeps the latest offer. Prices are read from the transient map and kept in
+// private data, see privacy.go. AcceptRequest_Estate takes the latest offer, the
+// seller's own counter-offer only once the buyer has accepted it.
+
+const (
+	Party_Buyer  = "buyer"
+	Party_Seller = "seller"
+)
+
+const (
+	OfferKind_Request = "request" // by the buyer, with RequestToBuy_Estate
+	OfferKind_Counter = "counter"
+	OfferKind_Accept  = "accept"
+)
+
+func (s *SmartContract) CounterOffer_Estate(ctx contractapi.TransactionContextInterface, serveyNo string, buyer string, conditions string) (Request, error) {
+
+	private, err4 := getPrivate(ctx, "CounterOffer_Estate")
+	if err4 != nil {
+		return Request{}, err4
+	}
+
+	if private.Price <= 0 {
+		return Request{}, fmt.Errorf("CounterOffer_Estate >> price must be greater than 0")
+	}
+
+	estate, request, party, uid, err0 := s.getNegotiation(ctx, "CounterOffer_Estate", serveyNo, buyer)
+	if err0 != nil {
+		return Request{}, err0
+	}
+
+	err1 := checkTurn("CounterOffer_Estate", serveyNo, request, party)
+	if err1 != nil {
+		return Request{}, err1
+	}
+
+	offer, err2 := s.addOffer(ctx, serveyNo, estate, request, OfferKind_Counter, party, uid, Private_Price{Price: private.Price, Salt: private.Salt}, conditions)
+	if err2 != nil {
+		return Request{}, fmt.Errorf("CounterOffer_Estate >> %s", err2.Error())
+	}
+
+	err3 := s.putNegotiation(ctx, serveyNo, estate, request)
+	if err3 != nil {
+		return Request{}, fmt.Errorf("CounterOffer_Estate >> %s", err3.Error())
+	}
+
+	addEvent(ctx, EventType_OfferCountered, Offer_Event{
+		ServeyNo: serveyNo,
+		Buyer:    buyer,
+		Offer:    offer,
+	})
+
+	return *request, nil
+}
+
+func (s *SmartContract) AcceptOffer_Estate(ctx contractapi.TransactionContextInterface, serveyNo string, buyer string) (Request, error) {
+
+	estate, request, party, uid, err0 := s.getNegotiation(ctx, "AcceptOffer_Estate", serveyNo, buyer)
+	if err0 != nil {
+		return Request{}, err0
+	}
+
+	err1 := checkTurn("AcceptOffer_Estate", serveyNo, request, party)
+	if err1 != nil {
+		return Request{}, err1
+	}
+
+	price, err4 := s.latestPrice(ctx, serveyNo, estate.OfficeCode, request)
+	if err4 != nil {
+		return Request{}, fmt.Errorf("AcceptOffer_Estate >> %s", err4.Error())
+	}
+
+	offer, err2 := s.addOffer(ctx, serveyNo, estate, request, OfferKind_Accept, party, uid, price, request.Conditions)
+	if err2 != nil {
+		return Request{}, fmt.Errorf("AcceptOffer_Estate >> %s", err2.Error())
+	}
+
+	err3 := s.putNegotiation(ctx, serveyNo, estate, request)
+	if err3 != nil {
+		return Request{}, fmt.Errorf("AcceptOffer_Estate >> %s", err3.Error())
+	}
+
+	addEvent(ctx, EventType_OfferAccepted, Offer_Event{
+		ServeyNo: serveyNo,
+		Buyer:    buyer,
+		Offer:    offer,
+	})
+
+	return *request, nil
+}
+
+// ------------------------------------
+
+// Helper Functions - Private
+
+// estate and request being negotiated, and the party of the caller in it
+func (s *SmartContract) getNegotiation(ctx contractapi.TransactionContextInterface, fname string, serveyNo string, buyer string) (*Estate, *Request, string, string, error) {
+
+	estate, err0 := newRepository(ctx).GetEstate(serveyNo)
+	if err0 != nil {
+		return nil, nil, "", "", fmt.Errorf("%s >> %s", fname, err0.Error())
+	}
+
+	// owner negotiates as seller, buyer only own request
+	caller, uid, err1 := s.authorizeUser(ctx, fname)
+	if err1 != nil {
+		return nil, nil, "", "", err1
+	}
+
+	party := ""
+	if isOwner(estate, uid) {
+		party = Party_Seller
+	} else if buyer == uid {
+		party = Party_Buyer
+	} else {
+		return nil, nil, "", "", &Access_Error{
+			Code:     AccessCode_NotOwner,
+			Function: fname,
+			Caller:   caller.EnrollmentID,
+			Allowed:  []string{Access_Owner, Access_Buyer},
+			Message:  fmt.Sprintf("Neither estate nor request is owned by %s", uid),
+		}
+	}
+
+	// only listed estates are negotiated
+	err2 := transitionEstate(ctx, fname, serveyNo, estate, Action_Request)
+	if err2 != nil {
+		return nil, nil, "", "", err2
+	}
+
+	index := searchRequest(estate.Requests, buyer)
+	if index == -1 {
+		return nil, nil, "", "", fmt.Errorf("%s >> No request found for given buyer: %s", fname, buyer)
+	}
+
+	now, err3 := getTxDateTime(ctx)
+	if err3 != nil {
+		return nil, nil, "", "", fmt.Errorf("%s >> %s", fname, err3.Error())
+	}
+
+	err4 := checkExpiry(fname, serveyNo, &estate.Requests[index], now)
+	if err4 != nil {
+		return nil, nil, "", "", err4
+	}
+
+	return estate, &estate.Requests[index], party, uid, nil
+}
+
+// only the offer of the other party can be countered or accepted
+func checkTurn(fname string, serveyNo string, request *Request, party string) error {
+
+	if offeredBy(request) == party {
+		return &Validation_Error{
+			Code:     ValidationCode_OutOfTurn,
+			Function: fname,
+			ServeyNo: serveyNo,
+			Message:  fmt.Sprintf("Latest offer on the request of %s is made by the %s", request.Buyer, party),
+		}
+	}
+
+	if request.Agreed {
+		return &Validation_Error{
+			Code:     ValidationCode_OutOfTurn,
+			Function: fname,
+			ServeyNo: serveyNo,
+			Message:  fmt.Sprintf("Latest offer on the request of %s is already accepted", request.Buyer),
+		}
+	}
+
+	return nil
+}
+
+// requests made before negotiations were added have only the buyer's offer
+func offeredBy(request *Request) string {
+	if request.OfferedBy == "" {
+		return Party_Buyer
+	}
+	return request.OfferedBy
+}
+
+// stores the next round of the request and makes it the latest offer, the
+// caller still has to put the estate
+func (s *SmartContract) addOffer(ctx contractapi.TransactionContextInterface, serveyNo string, estate *Estate, request *Request, kind string, party string, uid string, price Private_Price, conditions string) (Offer, error) {
+
+	temp_dateTime, err0 := getTxDateTime(ctx)
+	if err0 != nil {
+		return Offer{}, err0
+	}
+
+	// requests made before negotiations were added have no id yet
+	if request.ID == "" {
+		request.ID = ctx.GetStub().GetTxID()
+	}
+
+	repo := newRepository(ctx)
+
+	offerKey, err2 := repo.OfferKey(serveyNo, request.Buyer, request.ID, request.Rounds+1)
+	if err2 != nil {
+		return Offer{}, err2
+	}
+
+	// both sides of the negotiation keep the price
+	priceHash, err3 := s.putPrice(ctx, estate.OfficeCode, offerKey, price, append(estateOwnerUIDs(estate), request.Buyer)...)
+	if err3 != nil {
+		return Offer{}, err3
+	}
+
+	offer := Offer{
+		Round:      request.Rounds + 1,
+		Kind:       kind,
+		Party:      party,
+		By:         uid,
+		PriceHash:  priceHash,
+		Conditions: conditions,
+		DateTime:   temp_dateTime,
+	}
+
+	err1 := repo.PutOffer(serveyNo, request.Buyer, request.ID, &offer)
+	if err1 != nil {
+		return Offer{}, err1
+	}
+
+	// legacy price is not kept public
+	request.Rounds = offer.Round
+	request.ProposedPrice = 0
+	request.PriceHash = priceHash
+	request.Conditions = conditions
+	request.DateTime = temp_dateTime
+
+	// accepting keeps the party that made the offer
+	if kind == OfferKind_Accept {
+		request.Agreed = true
+	} else {
+		request.OfferedBy = party
+		request.Agreed = false
+	}
+
+	return offer, nil
+}
+
+// puts the estate and keeps the price in the buyer's requested in sync
+func (s *SmartContract) putNegotiation(ctx contractapi.TransactionContextInterface, serveyNo string, estate *Estate, request *Request) error {
+
+	repo := newRepository(ctx)
+
+	err0 := repo.PutEstate(serveyNo, estate)
+	if err0 != nil {
+		return err0
+	}
+
+	buyer, err1 := repo.GetUser(request.Buyer)
+	if err1 != nil {
+		return err1
+	}
+
+	for i, r := range buyer.Requested {
+		if r.ServeyNo == serveyNo {
+			buyer.Requested[i].ProposedPrice = 0
+			buyer.Requested[i].PriceHash = request.PriceHash
+			buyer.Requested[i].DateTime = request.DateTime
+		}
+	}
+
+	return repo.PutUser(request.Buyer, buyer)
+}
+
+// price of the latest offer on a request
+func (s *SmartContract) latestPrice(ctx contractapi.TransactionContextInterface, serveyNo string, officeCode string, request *Request) (Private_Price, error) {
+
+	offerKey, err0 := newRepository(ctx).OfferKey(serveyNo, request.Buyer, request.ID, request.Rounds)
+	if err0 != nil {
+		return Private_Price{}, err0
+	}
+
+	return s.getPrice(ctx, officeCode, offerKey, request.PriceHash, request.ProposedPrice)
+}
@@ -0,0 +1,451 @@
+package lib
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Ownership
+//
+// An estate is owned by one or more users, each holding a Share in percent,
+// the shares of an estate total 100. Owner is the managing owner, the estate
+// is listed under in queries, it stays the same while it holds a share and
+// is the co-owner with the largest share otherwise. Estates created before
+// co-ownership was added have no Owners, Owner holds 100.
+//
+// Any co-owner acts for the estate (lists it, negotiates, accepts requests).
+// A sale of the whole estate sells the shares of every co-owner, it waits
+// UnderContract until co-owners holding the threshold set with
+// Set_ConsentThreshold consented with ConsentSale_Estate, accepting counts as
+// consent. A co-owner transfers part of own share with TransferShare_Estate,
+// which only needs own consent. Both are registered by the sub-registrar like
+// any other sale.
+
+// co-owners of an estate registered before it is brought on the ledger, e.g.
+// a joint deed, owners are given as JSON [{"uid": "...", "percent": 50}, ...]
+func (s *SmartContract) SetOwners_Estate(ctx contractapi.TransactionContextInterface, serveyNo string, owners []Share) (Estate, error) {
+
+	repo := newRepository(ctx)
+
+	estate, err0 := repo.GetEstate(serveyNo)
+	if err0 != nil {
+		return Estate{}, fmt.Errorf("SetOwners_Estate >> %s", err0.Error())
+	}
+
+	_, err1 := s.authorizeAdmin(ctx, "SetOwners_Estate", estate.OfficeCode)
+	if err1 != nil {
+		return Estate{}, err1
+	}
+
+	// not while a sale is going on
+	err2 := transitionEstate(ctx, "SetOwners_Estate", serveyNo, estate, Action_Modify)
+	if err2 != nil {
+		return Estate{}, err2
+	}
+
+	err3 := validateShares(owners)
+	if err3 != nil {
+		return Estate{}, fmt.Errorf("SetOwners_Estate >> %s", err3.Error())
+	}
+
+	//=====================================
+	// every user is read before anything is written
+
+	uids := []string{}
+	users := []*User{}
+
+	for _, share := range estateOwners(estate) {
+		if searchShare(owners, share.UID) != -1 {
+			continue
+		}
+
+		user, err4 := repo.GetUser(share.UID)
+		if err4 != nil {
+			return Estate{}, fmt.Errorf("SetOwners_Estate >> %s", err4.Error())
+		}
+
+		user.Owned, _ = removeString(user.Owned, serveyNo)
+
+		uids = append(uids, share.UID)
+		users = append(users, user)
+	}
+
+	for _, share := range owners {
+		user, err5 := repo.GetUser(share.UID)
+		if err5 != nil {
+			return Estate{}, fmt.Errorf("SetOwners_Estate >> %s", err5.Error())
+		}
+
+		if searchArray(user.Owned, serveyNo) == -1 {
+			user.Owned = append(user.Owned, serveyNo)
+		}
+
+		uids = append(uids, share.UID)
+		users = append(users, user)
+	}
+
+	estate.Owners = owners
+	estate.Owner = managingOwner(owners, estate.Owner)
+
+	//=====================================
+
+	err6 := repo.PutEstate(serveyNo, estate)
+	if err6 != nil {
+		return Estate{}, fmt.Errorf("SetOwners_Estate >> %s", err6.Error())
+	}
+
+	for i, uid := range uids {
+		err7 := repo.PutUser(uid, users[i])
+		if err7 != nil {
+			return Estate{}, fmt.Errorf("SetOwners_Estate >> %s", err7.Error())
+		}
+	}
+
+	addEvent(ctx, EventType_OwnersChanged, Owners_Event{
+		ServeyNo: serveyNo,
+		Owner:    estate.Owner,
+		Owners:   estate.Owners,
+	})
+
+	return *estate, nil
+}
+
+// consent of a co-owner to the accepted sale of the estate, the sale is
+// submitted for registration once the consent threshold is reached
+func (s *SmartContract) ConsentSale_Estate(ctx contractapi.TransactionContextInterface, serveyNo string) (Transaction, error) {
+
+	repo := newRepository(ctx)
+
+	estate, err0 := repo.GetEstate(serveyNo)
+	if err0 != nil {
+		return Transaction{}, fmt.Errorf("ConsentSale_Estate >> %s", err0.Error())
+	}
+
+	caller, uid, err1 := s.authorizeOwner(ctx, "ConsentSale_Estate", estate)
+	if err1 != nil {
+		return Transaction{}, err1
+	}
+
+	num := estate.TransactionsCount + 1
+	transaction, _, err2 := s.getPendingTransaction(ctx, "ConsentSale_Estate", serveyNo, estate)
+	if err2 != nil {
+		return Transaction{}, err2
+	}
+
+	if estate.State != State_UnderContract {
+		return Transaction{}, &Validation_Error{
+			Code:     ValidationCode_NotPending,
+			Function: "ConsentSale_Estate",
+			ServeyNo: serveyNo,
+			Message:  fmt.Sprintf("Transaction %d is not waiting for consent", num),
+		}
+	}
+
+	if searchShare(saleShares(transaction), uid) == -1 {
+		return Transaction{}, &Access_Error{
+			Code:     AccessCode_NotOwner,
+			Function: "ConsentSale_Estate",
+			Caller:   caller.EnrollmentID,
+			Allowed:  []string{Access_Owner},
+			Message:  fmt.Sprintf("Transaction %d does not sell a share of %s", num, uid),
+		}
+	}
+
+	if searchArray(transaction.Consents, uid) != -1 {
+		return Transaction{}, fmt.Errorf("ConsentSale_Estate >> %s already consented to transaction %d", uid, num)
+	}
+
+	transaction.Consents = append(transaction.Consents, uid)
+
+	config, err3 := repo.GetConfig()
+	if err3 != nil {
+		return Transaction{}, fmt.Errorf("ConsentSale_Estate >> %s", err3.Error())
+	}
+
+	//=====================================
+
+	if isConsented(transaction, config.ConsentThreshold) {
+		err4 := s.submitForApproval(ctx, "ConsentSale_Estate", serveyNo, estate, num)
+		if err4 != nil {
+			return Transaction{}, err4
+		}
+
+		err5 := repo.PutEstate(serveyNo, estate)
+		if err5 != nil {
+			return Transaction{}, fmt.Errorf("ConsentSale_Estate >> %s", err5.Error())
+		}
+	}
+
+	err6 := repo.PutTransaction(serveyNo, num, transaction)
+	if err6 != nil {
+		return Transaction{}, fmt.Errorf("ConsentSale_Estate >> %s", err6.Error())
+	}
+
+	addEvent(ctx, EventType_SaleConsented, Transaction_Event{
+		ServeyNo:         serveyNo,
+		TransactionCount: num,
+		Transaction:      *transaction,
+	})
+
+	return *transaction, nil
+}
+
+// transfer of percent of the caller's share to buyer, submitted for
+// registration as a transaction of its own. Price is read from the transient
+// map, see privacy.go
+func (s *SmartContract) TransferShare_Estate(ctx contractapi.TransactionContextInterface, serveyNo string, buyer string, percent int, reason string) (Transaction, error) {
+
+	repo := newRepository(ctx)
+
+	estate, err0 := repo.GetEstate(serveyNo)
+	if err0 != nil {
+		return Transaction{}, fmt.Errorf("TransferShare_Estate >> %s", err0.Error())
+	}
+
+	_, seller, err1 := s.authorizeOwner(ctx, "TransferShare_Estate", estate)
+	if err1 != nil {
+		return Transaction{}, err1
+	}
+
+	err8 := checkReason("TransferShare_Estate", reason)
+	if err8 != nil {
+		return Transaction{}, err8
+	}
+
+	private, err2 := getPrivate(ctx, "TransferShare_Estate")
+	if err2 != nil {
+		return Transaction{}, err2
+	}
+
+	if private.Price < 0 {
+		return Transaction{}, fmt.Errorf("TransferShare_Estate >> price can not be negative")
+	}
+
+	share := ownerShare(estate, seller)
+	if percent <= 0 || percent > share {
+		return Transaction{}, fmt.Errorf("TransferShare_Estate >> percent must be between 1 and %d, the share of %s", share, seller)
+	}
+
+	if buyer == seller {
+		return Transaction{}, fmt.Errorf("TransferShare_Estate >> Share can not be transferred to its owner")
+	}
+
+	buyer_data, err3 := repo.GetUser(buyer)
+	if err3 != nil {
+		return Transaction{}, fmt.Errorf("TransferShare_Estate >> %s", err3.Error())
+	}
+
+	if buyer_data.Status != 1 {
+		return Transaction{}, &Validation_Error{
+			Code:     ValidationCode_NotVerified,
+			Function: "TransferShare_Estate",
+			ServeyNo: serveyNo,
+			Message:  fmt.Sprintf("Buyer %s is not verified", buyer),
+		}
+	}
+
+	err7 := checkLiens(ctx, "TransferShare_Estate", serveyNo, buyer)
+	if err7 != nil {
+		return Transaction{}, err7
+	}
+
+	// restored if the transfer is rejected or withdrawn
+	fromState := estate.State
+
+	err4 := transitionEstate(ctx, "TransferShare_Estate", serveyNo, estate, Action_TransferShare)
+	if err4 != nil {
+		return Transaction{}, err4
+	}
+
+	temp_dateTime, err5 := getTxDateTime(ctx)
+	if err5 != nil {
+		return Transaction{}, fmt.Errorf("TransferShare_Estate >> %s", err5.Error())
+	}
+
+	//=====================================
+
+	temp_transaction := Transaction{
+		Seller:              seller,
+		Buyer:               buyer,
+		TransactionDateTime: temp_dateTime,
+		OfficeCode:          estate.OfficeCode,
+		ApprovedBy:          "",
+		ApprovedDateTime:    time.Time{},
+		Reason:              reason,
+		Shares:              []Share{{UID: seller, Percent: percent}},
+		Consents:            []string{seller},
+		FromState:           fromState,
+	}
+
+	num, err6 := s.submitSale(ctx, "TransferShare_Estate", serveyNo, estate, &temp_transaction, Private_Price{Price: private.Price, Salt: private.Salt})
+	if err6 != nil {
+		return Transaction{}, err6
+	}
+
+	addEvent(ctx, EventType_ShareTransferred, Transaction_Event{
+		ServeyNo:         serveyNo,
+		TransactionCount: num,
+		Transaction:      temp_transaction,
+	})
+
+	return temp_transaction, nil
+}
+
+// ------------------------------------
+
+// Helper Functions - Private
+
+// shares of the co-owners, the owner alone for legacy estates
+func estateOwners(estate *Estate) []Share {
+
+	if len(estate.Owners) == 0 {
+		return []Share{{UID: estate.Owner, Percent: 100}}
+	}
+
+	return estate.Owners
+}
+
+func estateOwnerUIDs(estate *Estate) []string {
+
+	uids := []string{}
+	for _, share := range estateOwners(estate) {
+		uids = append(uids, share.UID)
+	}
+
+	return uids
+}
+
+func isOwner(estate *Estate, uid string) bool {
+	return searchShare(estateOwners(estate), uid) != -1
+}
+
+// percent held by uid, 0 if uid is not a co-owner
+func ownerShare(estate *Estate, uid string) int {
+
+	owners := estateOwners(estate)
+
+	i := searchShare(owners, uid)
+	if i == -1 {
+		return 0
+	}
+
+	return owners[i].Percent
+}
+
+// shares sold by a transaction, the whole estate of the seller for legacy
+// transactions
+func saleShares(transaction *Transaction) []Share {
+
+	if len(transaction.Shares) == 0 {
+		return []Share{{UID: transaction.Seller, Percent: 100}}
+	}
+
+	return transaction.Shares
+}
+
+// uids of the sellers and the buyer of a transaction
+func transactionParties(transaction *Transaction) []string {
+
+	uids := []string{transaction.Buyer}
+	for _, share := range saleShares(transaction) {
+		uids = append(uids, share.UID)
+	}
+
+	return uids
+}
+
+// current stays managing owner while it holds a share
+func managingOwner(owners []Share, current string) string {
+
+	if searchShare(owners, current) != -1 {
+		return current
+	}
+
+	// first of the largest, in the order of owners
+	sorted := append([]Share{}, owners...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Percent > sorted[j].Percent
+	})
+
+	return sorted[0].UID
+}
+
+func validateShares(shares []Share) error {
+
+	if len(shares) == 0 {
+		return fmt.Errorf("Estate needs at least one owner")
+	}
+
+	total := 0
+	for i, share := range shares {
+		if share.UID == "" {
+			return fmt.Errorf("uid of owner %d is empty", i+1)
+		}
+		if share.Percent <= 0 || share.Percent > 100 {
+			return fmt.Errorf("percent of %s must be between 1 and 100", share.UID)
+		}
+		if searchShare(shares[:i], share.UID) != -1 {
+			return fmt.Errorf("%s is given more than once", share.UID)
+		}
+		total += share.Percent
+	}
+
+	if total != 100 {
+		return fmt.Errorf("Shares total %d%%, not 100%%", total)
+	}
+
+	return nil
+}
+
+// owners after the shares are moved to buyer, sellers left without a share
+// are removed
+func transferShares(owners []Share, shares []Share, buyer string) ([]Share, error) {
+
+	temp_owners := append([]Share{}, owners...)
+
+	for _, share := range shares {
+		i := searchShare(temp_owners, share.UID)
+		if i == -1 || temp_owners[i].Percent < share.Percent {
+			return nil, fmt.Errorf("Share of %d%% is not owned by seller %s", share.Percent, share.UID)
+		}
+		temp_owners[i].Percent -= share.Percent
+
+		j := searchShare(temp_owners, buyer)
+		if j == -1 {
+			temp_owners = append(temp_owners, Share{UID: buyer})
+			j = len(temp_owners) - 1
+		}
+		temp_owners[j].Percent += share.Percent
+	}
+
+	result := []Share{}
+	for _, owner := range temp_owners {
+		if owner.Percent > 0 {
+			result = append(result, owner)
+		}
+	}
+
+	return result, nil
+}
+
+// true once the sellers who consented hold threshold percent of the shares sold
+func isConsented(transaction *Transaction, threshold int) bool {
+
+	if threshold == 0 {
+		threshold = 100
+	}
+
+	total := 0
+	consented := 0
+	for _, share := range saleShares(transaction) {
+		total += share.Percent
+		if searchArray(transaction.Consents, share.UID) != -1 {
+			consented += share.Percent
+		}
+	}
+
+	return consented*100 >= threshold*total
+}
@@ -0,0 +1,158 @@
+package lib
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidateShares(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		shares []Share
+		valid  bool
+	}{
+		{"sole owner", []Share{{UID: "u1", Percent: 100}}, true},
+		{"co-owners", []Share{{UID: "u1", Percent: 60}, {UID: "u2", Percent: 40}}, true},
+		{"no owner", []Share{}, false},
+		{"empty uid", []Share{{UID: "", Percent: 100}}, false},
+		{"zero percent", []Share{{UID: "u1", Percent: 100}, {UID: "u2", Percent: 0}}, false},
+		{"negative percent", []Share{{UID: "u1", Percent: 110}, {UID: "u2", Percent: -10}}, false},
+		{"owner twice", []Share{{UID: "u1", Percent: 50}, {UID: "u1", Percent: 50}}, false},
+		{"under 100", []Share{{UID: "u1", Percent: 50}, {UID: "u2", Percent: 40}}, false},
+		{"over 100", []Share{{UID: "u1", Percent: 60}, {UID: "u2", Percent: 60}}, false},
+	}
+
+	for _, test := range tests {
+		err := validateShares(test.shares)
+		if (err == nil) != test.valid {
+			t.Errorf("%s: got %v, want valid %t", test.name, err, test.valid)
+		}
+	}
+}
+
+func TestTransferShares(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		owners []Share
+		shares []Share
+		buyer  string
+		want   []Share
+	}{
+		{
+			name:   "whole estate",
+			owners: []Share{{UID: "u1", Percent: 100}},
+			shares: []Share{{UID: "u1", Percent: 100}},
+			buyer:  "u2",
+			want:   []Share{{UID: "u2", Percent: 100}},
+		},
+		{
+			name:   "part of a share",
+			owners: []Share{{UID: "u1", Percent: 100}},
+			shares: []Share{{UID: "u1", Percent: 40}},
+			buyer:  "u2",
+			want:   []Share{{UID: "u1", Percent: 60}, {UID: "u2", Percent: 40}},
+		},
+		{
+			name:   "to a co-owner",
+			owners: []Share{{UID: "u1", Percent: 50}, {UID: "u2", Percent: 50}},
+			shares: []Share{{UID: "u1", Percent: 25}},
+			buyer:  "u2",
+			want:   []Share{{UID: "u1", Percent: 25}, {UID: "u2", Percent: 75}},
+		},
+		{
+			name:   "from several sellers",
+			owners: []Share{{UID: "u1", Percent: 50}, {UID: "u2", Percent: 30}, {UID: "u3", Percent: 20}},
+			shares: []Share{{UID: "u1", Percent: 50}, {UID: "u3", Percent: 20}},
+			buyer:  "u4",
+			want:   []Share{{UID: "u2", Percent: 30}, {UID: "u4", Percent: 70}},
+		},
+	}
+
+	for _, test := range tests {
+		owners := append([]Share{}, test.owners...)
+
+		got, err := transferShares(owners, test.shares, test.buyer)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err.Error())
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+		if validateShares(got) != nil {
+			t.Errorf("%s: %v do not total 100%%", test.name, got)
+		}
+
+		// owners of the estate are not changed until the result is put
+		if !reflect.DeepEqual(owners, test.owners) {
+			t.Errorf("%s: owners changed to %v", test.name, owners)
+		}
+	}
+}
+
+func TestTransferSharesNotOwned(t *testing.T) {
+
+	owners := []Share{{UID: "u1", Percent: 60}, {UID: "u2", Percent: 40}}
+
+	tests := [][]Share{
+		{{UID: "u3", Percent: 10}},
+		{{UID: "u2", Percent: 50}},
+		{{UID: "u1", Percent: 40}, {UID: "u1", Percent: 40}},
+	}
+
+	for _, shares := range tests {
+		_, err := transferShares(owners, shares, "u4")
+		if err == nil {
+			t.Errorf("sale of %v from %v succeeded", shares, owners)
+		}
+	}
+}
+
+func TestIsConsented(t *testing.T) {
+
+	transaction := &Transaction{
+		Seller: "u1",
+		Shares: []Share{{UID: "u1", Percent: 50}, {UID: "u2", Percent: 30}, {UID: "u3", Percent: 20}},
+	}
+
+	tests := []struct {
+		consents  []string
+		threshold int
+		want      bool
+	}{
+		{[]string{}, 0, false},
+		{[]string{"u1", "u2"}, 0, false},
+		{[]string{"u1", "u2", "u3"}, 0, true},
+		{[]string{"u1", "u2", "u3"}, 100, true},
+		{[]string{"u1"}, 50, true},
+		{[]string{"u2", "u3"}, 51, false},
+		{[]string{"u1", "u3"}, 70, true},
+		{[]string{"u4"}, 1, false},
+	}
+
+	for _, test := range tests {
+		transaction.Consents = test.consents
+		if got := isConsented(transaction, test.threshold); got != test.want {
+			t.Errorf("consents %v, threshold %d: got %t, want %t", test.consents, test.threshold, got, test.want)
+		}
+	}
+
+	// legacy transactions sell the whole estate of the seller
+	legacy := &Transaction{Seller: "u1", Consents: []string{"u1"}}
+	if !isConsented(legacy, 0) {
+		t.Error("legacy transaction consented by the seller is not consented")
+	}
+}
+
+func TestManagingOwner(t *testing.T) {
+
+	owners := []Share{{UID: "u1", Percent: 30}, {UID: "u2", Percent: 40}, {UID: "u3", Percent: 40}}
+
+	if got := managingOwner(owners, "u1"); got != "u1" {
+		t.Errorf("got %s, current owner u1 still holds a share", got)
+	}
+	if got := managingOwner(owners, "u4"); got != "u2" {
+		t.Errorf("got %s, want the first of the largest shares u2", got)
+	}
+}
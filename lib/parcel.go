@@ -0,0 +1,423 @@
+package lib
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Parcels
+//
+// The sub-registrar partitions an estate into new estates with
+// SubdivideEstate. The estate is Retired, it keeps its history and lists the
+// new estates in Children. Every part gets a derived serveyNo (123/1, 123/2,
+// ...), the owners and office of the estate, and the estate in Parents. The
+// partition is the first transaction of every part, with reason "partition".
+//
+// Estates of the same office, owned by the same co-owners with the same
+// shares, are merged into a new estate with AmalgamateEstates. The
+// sub-registrar attests that they are adjacent, it is not checked. The
+// estates are Retired with the merged estate in Children, the merged estate
+// lists them in Parents and its first transaction has reason "amalgamation".
+//
+// Estates are only retired when nothing is pending on them: no requests, no
+// active lien and no pending or active lease.
+
+// parts get serveyNo/1, serveyNo/2, ... in order, their areas total the area
+// of the estate
+func (s *SmartContract) SubdivideEstate(ctx contractapi.TransactionContextInterface, serveyNo string, parts []Estate_Part) ([]Estate, error) {
+
+	repo := newRepository(ctx)
+
+	estate, err0 := repo.GetEstate(serveyNo)
+	if err0 != nil {
+		return []Estate{}, fmt.Errorf("SubdivideEstate >> %s", err0.Error())
+	}
+
+	// only sub-registrar of the estate's office
+	_, err1 := s.authorizeSubRegistrar(ctx, "SubdivideEstate", estate.OfficeCode)
+	if err1 != nil {
+		return []Estate{}, s.recordViolation(ctx, err1, serveyNo, estate.OfficeCode)
+	}
+
+	//=====================================
+
+	if len(parts) < 2 {
+		return []Estate{}, fmt.Errorf("SubdivideEstate >> Estate is subdivided into at least 2 parts")
+	}
+
+	total := 0
+	for i, part := range parts {
+		if part.Area <= 0 {
+			return []Estate{}, fmt.Errorf("SubdivideEstate >> area of part %d must be greater than 0", i+1)
+		}
+		total += part.Area
+	}
+
+	if total != estate.Area {
+		return []Estate{}, fmt.Errorf("SubdivideEstate >> Areas of the parts total %d, not %d, the area of the estate", total, estate.Area)
+	}
+
+	err2 := s.checkRetirable(ctx, "SubdivideEstate", serveyNo, estate)
+	if err2 != nil {
+		return []Estate{}, err2
+	}
+
+	err3 := transitionEstate(ctx, "SubdivideEstate", serveyNo, estate, Action_Subdivide)
+	if err3 != nil {
+		return []Estate{}, err3
+	}
+
+	admin, err4 := repo.GetAdmin(estate.OfficeCode)
+	if err4 != nil {
+		return []Estate{}, fmt.Errorf("SubdivideEstate >> %s", err4.Error())
+	}
+
+	temp_dateTime, err5 := getTxDateTime(ctx)
+	if err5 != nil {
+		return []Estate{}, fmt.Errorf("SubdivideEstate >> %s", err5.Error())
+	}
+
+	children := []Estate{}
+	childNos := []string{}
+
+	for i, part := range parts {
+		childNo := fmt.Sprintf("%s/%d", serveyNo, i+1)
+
+		exists, err6 := repo.EstateExists(childNo)
+		if err6 != nil {
+			return []Estate{}, fmt.Errorf("SubdivideEstate >> %s", err6.Error())
+		} else if exists {
+			return []Estate{}, fmt.Errorf("SubdivideEstate >> Estate with serveyNo %s already exists", childNo)
+		}
+
+		location := part.Location
+		if location == "" {
+			location = estate.Location
+		}
+
+		child := Estate{
+			Owner:             estate.Owner,
+			Owners:            append([]Share{}, estateOwners(estate)...),
+			OfficeCode:        estate.OfficeCode,
+			Location:          location,
+			Area:              part.Area,
+			PurchasedOn:       estate.PurchasedOn,
+			TransactionsCount: 1,
+			Requests:          []Request{},
+			Parents:           []string{serveyNo},
+			Children:          []string{},
+		}
+
+		err7 := transitionEstate(ctx, "SubdivideEstate", childNo, &child, Action_Subdivide)
+		if err7 != nil {
+			return []Estate{}, err7
+		}
+
+		children = append(children, child)
+		childNos = append(childNos, childNo)
+	}
+
+	// every co-owner owns the parts instead of the estate
+	owners, err8 := s.replaceOwned(ctx, "SubdivideEstate", estateOwnerUIDs(estate), []string{serveyNo}, childNos)
+	if err8 != nil {
+		return []Estate{}, err8
+	}
+
+	//=====================================
+
+	estate.Children = childNos
+
+	err9 := repo.PutEstate(serveyNo, estate)
+	if err9 != nil {
+		return []Estate{}, fmt.Errorf("SubdivideEstate >> %s", err9.Error())
+	}
+
+	for i, childNo := range childNos {
+		err10 := repo.PutEstate(childNo, &children[i])
+		if err10 != nil {
+			return []Estate{}, fmt.Errorf("SubdivideEstate >> %s", err10.Error())
+		}
+
+		err11 := repo.PutTransaction(childNo, 1, &Transaction{
+			Seller:              estate.Owner,
+			Buyer:               estate.Owner,
+			TransactionDateTime: temp_dateTime,
+			OfficeCode:          estate.OfficeCode,
+			ApprovedBy:          admin.UID,
+			ApprovedDateTime:    temp_dateTime,
+			Reason:              "partition",
+			Shares:              children[i].Owners,
+			Consents:            []string{},
+		})
+		if err11 != nil {
+			return []Estate{}, fmt.Errorf("SubdivideEstate >> %s", err11.Error())
+		}
+	}
+
+	for _, user := range owners {
+		err12 := repo.PutUser(user.UID, user)
+		if err12 != nil {
+			return []Estate{}, fmt.Errorf("SubdivideEstate >> %s", err12.Error())
+		}
+	}
+
+	addEvent(ctx, EventType_EstateSubdivided, Parcel_Event{
+		From: []string{serveyNo},
+		To:   childNos,
+	})
+
+	return children, nil
+}
+
+// estates are merged into a new estate newServeyNo, empty location for the
+// location of the first estate
+func (s *SmartContract) AmalgamateEstates(ctx contractapi.TransactionContextInterface, serveyNos []string, newServeyNo string, location string) (Estate, error) {
+
+	repo := newRepository(ctx)
+
+	if len(serveyNos) < 2 {
+		return Estate{}, fmt.Errorf("AmalgamateEstates >> At least 2 estates are amalgamated")
+	}
+
+	if newServeyNo == "" {
+		return Estate{}, fmt.Errorf("AmalgamateEstates >> newServeyNo is required")
+	}
+
+	estates := []*Estate{}
+
+	for i, serveyNo := range serveyNos {
+		if searchArray(serveyNos[:i], serveyNo) != -1 {
+			return Estate{}, fmt.Errorf("AmalgamateEstates >> %s is given more than once", serveyNo)
+		}
+
+		estate, err0 := repo.GetEstate(serveyNo)
+		if err0 != nil {
+			return Estate{}, fmt.Errorf("AmalgamateEstates >> %s", err0.Error())
+		}
+
+		estates = append(estates, estate)
+	}
+
+	first := estates[0]
+
+	// only sub-registrar of the estates' office
+	_, err1 := s.authorizeSubRegistrar(ctx, "AmalgamateEstates", first.OfficeCode)
+	if err1 != nil {
+		return Estate{}, s.recordViolation(ctx, err1, serveyNos[0], first.OfficeCode)
+	}
+
+	//=====================================
+
+	exists, err2 := repo.EstateExists(newServeyNo)
+	if err2 != nil {
+		return Estate{}, fmt.Errorf("AmalgamateEstates >> %s", err2.Error())
+	} else if exists {
+		return Estate{}, fmt.Errorf("AmalgamateEstates >> Estate with serveyNo %s already exists", newServeyNo)
+	}
+
+	area := 0
+	purchasedOn := first.PurchasedOn
+
+	for i, estate := range estates {
+		serveyNo := serveyNos[i]
+
+		if estate.OfficeCode != first.OfficeCode {
+			return Estate{}, &Validation_Error{
+				Code:     ValidationCode_OfficeMismatch,
+				Function: "AmalgamateEstates",
+				ServeyNo: serveyNo,
+				Message:  fmt.Sprintf("Estate resides in %s, not in %s", estate.OfficeCode, first.OfficeCode),
+			}
+		}
+
+		if !sameShares(estateOwners(estate), estateOwners(first)) {
+			return Estate{}, &Validation_Error{
+				Code:     ValidationCode_NotOwned,
+				Function: "AmalgamateEstates",
+				ServeyNo: serveyNo,
+				Message:  fmt.Sprintf("Estate is not owned by the same co-owners with the same shares as %s", serveyNos[0]),
+			}
+		}
+
+		// verified, with no sale, auction or succession going on
+		err3 := transitionEstate(ctx, "AmalgamateEstates", serveyNo, estate, Action_Amalgamate)
+		if err3 != nil {
+			return Estate{}, err3
+		}
+
+		err4 := s.checkRetirable(ctx, "AmalgamateEstates", serveyNo, estate)
+		if err4 != nil {
+			return Estate{}, err4
+		}
+
+		area += estate.Area
+		if estate.PurchasedOn.After(purchasedOn) {
+			purchasedOn = estate.PurchasedOn
+		}
+	}
+
+	if location == "" {
+		location = first.Location
+	}
+
+	merged := Estate{
+		Owner:             first.Owner,
+		Owners:            append([]Share{}, estateOwners(first)...),
+		OfficeCode:        first.OfficeCode,
+		Location:          location,
+		Area:              area,
+		PurchasedOn:       purchasedOn,
+		TransactionsCount: 1,
+		Requests:          []Request{},
+		Parents:           serveyNos,
+		Children:          []string{},
+	}
+
+	err5 := transitionEstate(ctx, "AmalgamateEstates", newServeyNo, &merged, Action_Amalgamate)
+	if err5 != nil {
+		return Estate{}, err5
+	}
+
+	// every co-owner owns the merged estate instead of the estates
+	owners, err6 := s.replaceOwned(ctx, "AmalgamateEstates", estateOwnerUIDs(first), serveyNos, []string{newServeyNo})
+	if err6 != nil {
+		return Estate{}, err6
+	}
+
+	admin, err7 := repo.GetAdmin(first.OfficeCode)
+	if err7 != nil {
+		return Estate{}, fmt.Errorf("AmalgamateEstates >> %s", err7.Error())
+	}
+
+	temp_dateTime, err8 := getTxDateTime(ctx)
+	if err8 != nil {
+		return Estate{}, fmt.Errorf("AmalgamateEstates >> %s", err8.Error())
+	}
+
+	//=====================================
+
+	for i, estate := range estates {
+		estate.Children = []string{newServeyNo}
+
+		err9 := repo.PutEstate(serveyNos[i], estate)
+		if err9 != nil {
+			return Estate{}, fmt.Errorf("AmalgamateEstates >> %s", err9.Error())
+		}
+	}
+
+	err10 := repo.PutEstate(newServeyNo, &merged)
+	if err10 != nil {
+		return Estate{}, fmt.Errorf("AmalgamateEstates >> %s", err10.Error())
+	}
+
+	err11 := repo.PutTransaction(newServeyNo, 1, &Transaction{
+		Seller:              merged.Owner,
+		Buyer:               merged.Owner,
+		TransactionDateTime: temp_dateTime,
+		OfficeCode:          merged.OfficeCode,
+		ApprovedBy:          admin.UID,
+		ApprovedDateTime:    temp_dateTime,
+		Reason:              "amalgamation",
+		Shares:              merged.Owners,
+		Consents:            []string{},
+	})
+	if err11 != nil {
+		return Estate{}, fmt.Errorf("AmalgamateEstates >> %s", err11.Error())
+	}
+
+	for _, user := range owners {
+		err12 := repo.PutUser(user.UID, user)
+		if err12 != nil {
+			return Estate{}, fmt.Errorf("AmalgamateEstates >> %s", err12.Error())
+		}
+	}
+
+	addEvent(ctx, EventType_EstatesAmalgamated, Parcel_Event{
+		From: serveyNos,
+		To:   []string{newServeyNo},
+	})
+
+	return merged, nil
+}
+
+// ------------------------------------
+
+// Helper Functions - Private
+
+// fails while anything is pending on the estate, see the top of this file
+func (s *SmartContract) checkRetirable(ctx contractapi.TransactionContextInterface, fname string, serveyNo string, estate *Estate) error {
+
+	if len(estate.Requests) > 0 {
+		return fmt.Errorf("%s >> Estate %s has requests, they have to be cleared with ClearRequests_Estate first", fname, serveyNo)
+	}
+
+	err0 := checkUnencumbered(ctx, fname, serveyNo)
+	if err0 != nil {
+		return err0
+	}
+
+	leases, err1 := newRepository(ctx).GetLeases(serveyNo)
+	if err1 != nil {
+		return fmt.Errorf("%s >> %s", fname, err1.Error())
+	}
+
+	for _, lease := range leases {
+		if lease.Status == LeaseStatus_Pending || lease.Status == LeaseStatus_Active {
+			return fmt.Errorf("%s >> Estate %s has a %s lease %s", fname, serveyNo, lease.Status, lease.ID)
+		}
+	}
+
+	return nil
+}
+
+// users with the retired estates replaced by the new ones in Owned, each
+// once, the caller still has to put them
+func (s *SmartContract) replaceOwned(ctx contractapi.TransactionContextInterface, fname string, uids []string, retired []string, created []string) ([]*User, error) {
+
+	repo := newRepository(ctx)
+
+	users := []*User{}
+
+	for i, uid := range uids {
+		if searchArray(uids[:i], uid) != -1 {
+			continue
+		}
+
+		user, err0 := repo.GetUser(uid)
+		if err0 != nil {
+			return nil, fmt.Errorf("%s >> %s", fname, err0.Error())
+		}
+
+		for _, serveyNo := range retired {
+			user.Owned, _ = removeString(user.Owned, serveyNo)
+		}
+
+		for _, serveyNo := range created {
+			if searchArray(user.Owned, serveyNo) == -1 {
+				user.Owned = append(user.Owned, serveyNo)
+			}
+		}
+
+		users = append(users, user)
+	}
+
+	return users, nil
+}
+
+// same co-owners with the same percents, in any order
+func sameShares(a []Share, b []Share) bool {
+
+	if len(a) != len(b) {
+		return false
+	}
+
+	for _, share := range a {
+		i := searchShare(b, share.UID)
+		if i == -1 || b[i].Percent != share.Percent {
+			return false
+		}
+	}
+
+	return true
+}
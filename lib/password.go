@@ -0,0 +1,179 @@
+package lib
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Passwords
+//
+// Passwords are stored as salted scrypt hashes in the form
+// scrypt$<N>$<r>$<p>$<salt>$<hash>. Records written before hashing was
+// introduced still hold the cleartext password, those are compared as is
+// and have to be rotated with ChangePassword before they can be used. Legacy
+// users hold their uid, they are reset by an admin with ResetPassword_User
+// instead.
+//
+// ChangePassword acts on a record bound to the caller, or on an unbound
+// record the caller could claim with Migrate_Identity.
+
+const (
+	scrypt_N      = 1 << 15
+	scrypt_R      = 8
+	scrypt_P      = 1
+	scrypt_KeyLen = 32
+
+	minPasswordLength = 8
+)
+
+func (s *SmartContract) ChangePassword(ctx contractapi.TransactionContextInterface) error {
+
+	credentials, err5 := getCredentials(ctx, "ChangePassword")
+	if err5 != nil {
+		return err5
+	}
+
+	_username := credentials.Username
+	_password := credentials.Password
+	newPassword := credentials.NewPassword
+
+	caller, err6 := s.getCaller(ctx)
+	if err6 != nil {
+		return &Access_Error{Code: AccessCode_Unauthenticated, Function: "ChangePassword", Message: err6.Error()}
+	}
+
+	repo := newRepository(ctx)
+
+	// get data
+	data, err1 := repo.GetRecord(_username)
+	if err1 != nil {
+		return fmt.Errorf("ChangePassword >> %s", err1.Error())
+	}
+
+	// bound records only by their identity, unbound ones only by identities
+	// that could claim them
+	bound := recordIdentity(data)
+	if bound.EnrollmentID != "" {
+		if bound != caller.Identity {
+			return &Access_Error{
+				Code:     AccessCode_NotOwner,
+				Function: "ChangePassword",
+				Caller:   caller.EnrollmentID,
+				Message:  fmt.Sprintf("%s is bound to another identity", _username),
+			}
+		}
+	} else {
+		err7 := checkClaim(caller, "ChangePassword", _username)
+		if err7 != nil {
+			return err7
+		}
+	}
+
+	// cleartext uid of legacy users is no proof of ownership
+	stored, _ := data["password"].(string)
+	if strings.HasPrefix(_username, "user_") && !isPasswordHashed(stored) {
+		return fmt.Errorf("ChangePassword >> Password of %s must be reset by an admin with ResetPassword_User first", _username)
+	}
+
+	verified, err0 := s.verifyPassword(ctx, _username, _password)
+
+	if err0 != nil {
+		return fmt.Errorf("verifyPassword >> Verify password %s", err0.Error())
+	} else if !verified {
+		return fmt.Errorf("ChangePassword >> Password Missmatched for %s", _username)
+	}
+
+	if len(newPassword) < minPasswordLength {
+		return fmt.Errorf("ChangePassword >> New password must have at least %d characters", minPasswordLength)
+	}
+
+	if newPassword == _password {
+		return fmt.Errorf("ChangePassword >> New password must be different from the current one")
+	}
+
+	//=====================================
+
+	hashed, err3 := hashPassword(ctx, _username, newPassword)
+	if err3 != nil {
+		return fmt.Errorf("ChangePassword >> %s", err3.Error())
+	}
+
+	data["password"] = hashed
+	data["mustChangePassword"] = false
+
+	err4 := repo.PutRecord(_username, data)
+	if err4 != nil {
+		return fmt.Errorf("ChangePassword >> %s", err4.Error())
+	}
+
+	return nil
+}
+
+// ------------------------------------
+
+// Helper Functions - Private
+
+// salt can not be random, every endorsing peer has to produce the same
+// write set, so it is derived from the transaction id and the record key
+func hashPassword(ctx contractapi.TransactionContextInterface, key string, password string) (string, error) {
+
+	saltSum := sha256.Sum256([]byte(ctx.GetStub().GetTxID() + "_" + key))
+	salt := saltSum[:16]
+
+	hash, err0 := scrypt.Key([]byte(password), salt, scrypt_N, scrypt_R, scrypt_P, scrypt_KeyLen)
+	if err0 != nil {
+		return "", fmt.Errorf("hashPassword >> Can't hash password. %s", err0.Error())
+	}
+
+	encoded := strings.Join([]string{
+		"scrypt",
+		strconv.Itoa(scrypt_N),
+		strconv.Itoa(scrypt_R),
+		strconv.Itoa(scrypt_P),
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(hash),
+	}, "$")
+
+	return encoded, nil
+}
+
+func isPasswordHashed(stored string) bool {
+	return strings.HasPrefix(stored, "scrypt$")
+}
+
+func checkPassword(stored string, password string) (bool, error) {
+
+	// legacy cleartext password
+	if !isPasswordHashed(stored) {
+		return subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1, nil
+	}
+
+	parts := strings.Split(stored, "$")
+	if len(parts) != 6 {
+		return false, fmt.Errorf("checkPassword >> Malformed password hash")
+	}
+
+	n, err0 := strconv.Atoi(parts[1])
+	r, err1 := strconv.Atoi(parts[2])
+	p, err2 := strconv.Atoi(parts[3])
+	salt, err3 := base64.RawStdEncoding.DecodeString(parts[4])
+	hash, err4 := base64.RawStdEncoding.DecodeString(parts[5])
+
+	if err0 != nil || err1 != nil || err2 != nil || err3 != nil || err4 != nil {
+		return false, fmt.Errorf("checkPassword >> Malformed password hash")
+	}
+
+	computed, err5 := scrypt.Key([]byte(password), salt, n, r, p, len(hash))
+	if err5 != nil {
+		return false, fmt.Errorf("checkPassword >> Can't hash password. %s", err5.Error())
+	}
+
+	return subtle.ConstantTimeCompare(computed, hash) == 1, nil
+}
@@ -0,0 +1,297 @@
+package lib
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Privacy
+//
+// Prices (requests, offers, transactions, penalties), rent and deposit of
+// leases and names of users are not kept in public state. They are passed in the transient map, see
+// transient.go, and stored as private data under the key of the record they
+// belong to, in:
+//
+//	office_<officeCode>  one per office, defined in collections_config.json
+//	                     with the orgs of the office and of its users, every
+//	                     office added with CreateOrModify_Admin needs one.
+//	                     Entries are generated from collections_config.template.json,
+//	                     OFFICE_CODE=PNE OFFICE_MEMBERS="'Org1MSP.member', 'Org2MSP.member'"
+//	                     envsubst < collections_config.template.json
+//	                     gives the entry for PNE shipped in collections_config.json
+//	_implicit_org_<msp>  of the caller's org and of the orgs of the parties,
+//	                     so every party keeps its own copy
+//
+// The public record keeps the hex sha256 of the private JSON in its
+// priceHash/nameHash/termsHash field, the same hash Fabric keeps for private data. A
+// party holding the value and its salt proves it with VerifyTransactionPrice
+// or VerifyUserName, values themselves are read with GetTransactionPrice and
+// GetUserName on a peer of a member org.
+//
+// Records written before private data was added keep their values public and
+// have no hash. Prices of open auctions and revealed sealed bids are public by
+// design.
+
+const Collection_OfficePrefix = "office_"
+
+// price of a transaction, only for its parties and the admins of its office
+func (s *SmartContract) GetTransactionPrice(ctx contractapi.TransactionContextInterface, serveyNo string, num int) (Private_Price, error) {
+
+	repo := newRepository(ctx)
+
+	transaction, err0 := repo.GetTransaction(serveyNo, num)
+	if err0 != nil {
+		return Private_Price{}, fmt.Errorf("GetTransactionPrice >> %s", err0.Error())
+	}
+
+	parties := []string{transaction.Seller, transaction.Buyer}
+	for _, share := range transaction.Shares {
+		parties = append(parties, share.UID)
+	}
+
+	_, err1 := s.authorizeParty(ctx, "GetTransactionPrice", transaction.OfficeCode, parties...)
+	if err1 != nil {
+		return Private_Price{}, err1
+	}
+
+	key, err2 := repo.TransactionKey(serveyNo, num)
+	if err2 != nil {
+		return Private_Price{}, fmt.Errorf("GetTransactionPrice >> %s", err2.Error())
+	}
+
+	price, err3 := s.getPrice(ctx, transaction.OfficeCode, key, transaction.PriceHash, transaction.Price)
+	if err3 != nil {
+		return Private_Price{}, fmt.Errorf("GetTransactionPrice >> %s", err3.Error())
+	}
+
+	return price, nil
+}
+
+// true if the price and salt in the transient map are the ones the
+// transaction was made with
+func (s *SmartContract) VerifyTransactionPrice(ctx contractapi.TransactionContextInterface, serveyNo string, num int) (bool, error) {
+
+	private, err0 := getPrivate(ctx, "VerifyTransactionPrice")
+	if err0 != nil {
+		return false, err0
+	}
+
+	transaction, err1 := newRepository(ctx).GetTransaction(serveyNo, num)
+	if err1 != nil {
+		return false, fmt.Errorf("VerifyTransactionPrice >> %s", err1.Error())
+	}
+
+	// legacy transactions have a public price
+	if transaction.PriceHash == "" {
+		return transaction.Price == private.Price, nil
+	}
+
+	return privateHash(Private_Price{Price: private.Price, Salt: private.Salt}) == transaction.PriceHash, nil
+}
+
+// name of a user, only for the user and the admins
+func (s *SmartContract) GetUserName(ctx contractapi.TransactionContextInterface, uid string) (Private_Name, error) {
+
+	caller, err0 := s.getCaller(ctx)
+	if err0 != nil {
+		return Private_Name{}, &Access_Error{Code: AccessCode_Unauthenticated, Function: "GetUserName", Message: err0.Error()}
+	}
+
+	if caller.Username != "user_"+uid {
+		_, err1 := s.authorizeAdmin(ctx, "GetUserName", "")
+		if err1 != nil {
+			return Private_Name{}, err1
+		}
+	}
+
+	repo := newRepository(ctx)
+
+	user, err2 := repo.GetUser(uid)
+	if err2 != nil {
+		return Private_Name{}, fmt.Errorf("GetUserName >> %s", err2.Error())
+	}
+
+	// legacy users have a public name
+	if user.NameHash == "" {
+		return Private_Name{Name: user.Name}, nil
+	}
+
+	key, err3 := repo.UserKey(uid)
+	if err3 != nil {
+		return Private_Name{}, fmt.Errorf("GetUserName >> %s", err3.Error())
+	}
+
+	name := Private_Name{}
+	err4 := s.getPrivateData(ctx, user.OfficeCode, key, user.NameHash, &name)
+	if err4 != nil {
+		return Private_Name{}, fmt.Errorf("GetUserName >> %s", err4.Error())
+	}
+
+	return name, nil
+}
+
+// true if the name and salt in the transient map are the ones the user was
+// registered with
+func (s *SmartContract) VerifyUserName(ctx contractapi.TransactionContextInterface, uid string) (bool, error) {
+
+	private, err0 := getPrivate(ctx, "VerifyUserName")
+	if err0 != nil {
+		return false, err0
+	}
+
+	user, err1 := newRepository(ctx).GetUser(uid)
+	if err1 != nil {
+		return false, fmt.Errorf("VerifyUserName >> %s", err1.Error())
+	}
+
+	// legacy users have a public name
+	if user.NameHash == "" {
+		return user.Name == private.Name, nil
+	}
+
+	return privateHash(Private_Name{Name: private.Name, Salt: private.Salt}) == user.NameHash, nil
+}
+
+// ------------------------------------
+
+// Helper Functions - Private
+
+func officeCollection(officeCode string) string {
+	return Collection_OfficePrefix + officeCode
+}
+
+// collection every org has without configuring it, only its peers keep the data
+func implicitCollection(mspID string) string {
+	return "_implicit_org_" + mspID
+}
+
+// hash kept in public state, same as the one Fabric keeps for the private data
+func privateHash(v interface{}) string {
+
+	marshaled_data, _ := json.Marshal(v)
+	hash := sha256.Sum256(marshaled_data)
+
+	return hex.EncodeToString(hash[:])
+}
+
+// stores v in the collection of the office, if any, and of the orgs of the
+// caller and of the parties (uids), returns the hash for public state
+func (s *SmartContract) putPrivateData(ctx contractapi.TransactionContextInterface, officeCode string, key string, v interface{}, parties ...string) (string, error) {
+
+	collections, err0 := s.partyCollections(ctx, parties)
+	if err0 != nil {
+		return "", err0
+	}
+
+	if officeCode != "" {
+		collections = append(collections, officeCollection(officeCode))
+	}
+
+	repo := newRepository(ctx)
+
+	for _, collection := range collections {
+		err1 := repo.PutPrivate(collection, key, v)
+		if err1 != nil {
+			return "", err1
+		}
+	}
+
+	return privateHash(v), nil
+}
+
+// implicit collections of the orgs of the caller and of the parties, sorted.
+// Parties not bound to an identity yet have no org, sellers of historical
+// deeds may not be users at all
+func (s *SmartContract) partyCollections(ctx contractapi.TransactionContextInterface, parties []string) ([]string, error) {
+
+	caller, err0 := s.getCaller(ctx)
+	if err0 != nil {
+		return []string{}, err0
+	}
+
+	repo := newRepository(ctx)
+
+	collections := []string{implicitCollection(caller.MSPID)}
+
+	for _, uid := range parties {
+		exists, err1 := repo.UserExists(uid)
+		if err1 != nil {
+			return []string{}, err1
+		} else if !exists {
+			continue
+		}
+
+		user, err2 := repo.GetUser(uid)
+		if err2 != nil {
+			return []string{}, err2
+		}
+
+		if user.Identity.MSPID == "" {
+			continue
+		}
+
+		collection := implicitCollection(user.Identity.MSPID)
+		if searchArray(collections, collection) == -1 {
+			collections = append(collections, collection)
+		}
+	}
+
+	sort.Strings(collections)
+
+	return collections, nil
+}
+
+// reads v from the collection of the caller's org or of the office, whichever
+// this peer has and matches the hash in public state
+func (s *SmartContract) getPrivateData(ctx contractapi.TransactionContextInterface, officeCode string, key string, hash string, v interface{}) error {
+
+	caller, err0 := s.getCaller(ctx)
+	if err0 != nil {
+		return err0
+	}
+
+	collections := []string{implicitCollection(caller.MSPID)}
+	if officeCode != "" {
+		collections = append(collections, officeCollection(officeCode))
+	}
+
+	repo := newRepository(ctx)
+
+	// peers of other orgs can not read the collection, try the next one
+	for _, collection := range collections {
+		found, err1 := repo.GetPrivate(collection, key, v)
+		if err1 == nil && found && privateHash(v) == hash {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("private data of %q is not available on this peer, it is kept in %s", key, strings.Join(collections, ", "))
+}
+
+// price of a record, legacy records without a hash have it in public state
+func (s *SmartContract) getPrice(ctx contractapi.TransactionContextInterface, officeCode string, key string, hash string, public int) (Private_Price, error) {
+
+	if hash == "" {
+		return Private_Price{Price: public}, nil
+	}
+
+	price := Private_Price{}
+	err0 := s.getPrivateData(ctx, officeCode, key, hash, &price)
+	if err0 != nil {
+		return Private_Price{}, err0
+	}
+
+	return price, nil
+}
+
+// stores the price of a record for its parties (uids), returns the hash for
+// public state
+func (s *SmartContract) putPrice(ctx contractapi.TransactionContextInterface, officeCode string, key string, price Private_Price, parties ...string) (string, error) {
+	return s.putPrivateData(ctx, officeCode, key, price, parties...)
+}
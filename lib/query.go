@@ -0,0 +1,362 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Queries
+//
+// Read only transactions, to be evaluated, not submitted. Paginated queries
+// are not allowed in transactions that write.
+
+// chain of title of an estate, every registered transfer with the ledger
+// transaction it took effect in
+func (s *SmartContract) GetEstateHistory(ctx contractapi.TransactionContextInterface, serveyNo string, pageSize int, bookmark string) (Estate_History, error) {
+
+	// slices can not be null in the returned value, even on errors
+	history := Estate_History{
+		ServeyNo: serveyNo,
+		Titles:   []Title_Record{},
+		Leases:   []Lease{},
+	}
+
+	if pageSize <= 0 {
+		return history, fmt.Errorf("GetEstateHistory >> pageSize must be greater than 0")
+	}
+
+	repo := newRepository(ctx)
+
+	estate, err0 := repo.GetEstate(serveyNo)
+	if err0 != nil {
+		return history, fmt.Errorf("GetEstateHistory >> %s", err0.Error())
+	}
+
+	versions, err1 := repo.GetEstateVersions(serveyNo)
+	if err1 != nil {
+		return history, fmt.Errorf("GetEstateHistory >> %s", err1.Error())
+	}
+
+	records, nextBookmark, err2 := repo.GetTransactionsPage(serveyNo, int32(pageSize), bookmark)
+	if err2 != nil {
+		return history, fmt.Errorf("GetEstateHistory >> %s", err2.Error())
+	}
+
+	history.Bookmark = nextBookmark
+
+	for _, record := range records {
+
+		// pending transaction, not a title yet
+		if record.TransactionCount > estate.TransactionsCount {
+			continue
+		}
+
+		// first version of the estate that counts the transfer, for deeds
+		// added with Add_Transaction this is the version that imported them
+		for _, version := range versions {
+			if version.IsDelete || version.Estate.TransactionsCount < record.TransactionCount {
+				continue
+			}
+
+			if record.TxID == "" || version.Timestamp.Before(record.Timestamp) {
+				record.TxID = version.TxID
+				record.Timestamp = version.Timestamp
+			}
+		}
+
+		history.Titles = append(history.Titles, record)
+	}
+
+	// leases are not paged, they come with the first page
+	if bookmark == "" {
+		leases, err3 := repo.GetLeases(serveyNo)
+		if err3 != nil {
+			return history, fmt.Errorf("GetEstateHistory >> %s", err3.Error())
+		}
+
+		for _, lease := range leases {
+			if lease.ApprovedBy != "" {
+				history.Leases = append(history.Leases, lease)
+			}
+		}
+	}
+
+	return history, nil
+}
+
+// estates matching an Estate_Filter given as JSON, e.g.
+// {"officeCode": "PNE", "state": "Draft"} or {"state": "Listed", "minArea": 100}
+func (s *SmartContract) QueryEstates(ctx contractapi.TransactionContextInterface, filter string, pageSize int, bookmark string) (Estate_Query_Result, error) {
+
+	result := Estate_Query_Result{Records: []Estate_Record{}}
+
+	if pageSize <= 0 {
+		return result, fmt.Errorf("QueryEstates >> pageSize must be greater than 0")
+	}
+
+	estateFilter := Estate_Filter{}
+	if filter != "" {
+		err0 := json.Unmarshal([]byte(filter), &estateFilter)
+		if err0 != nil {
+			return result, fmt.Errorf("QueryEstates >> filter is not a valid Estate_Filter. %s", err0.Error())
+		}
+	}
+
+	var records []Estate_Record
+	var nextBookmark string
+	var err1 error
+
+	// co-owners are resolved through Owned of the user, no index serves a
+	// selector on the owners array
+	if estateFilter.Owner != "" {
+		records, nextBookmark, err1 = queryOwnedEstates(ctx, estateFilter, pageSize, bookmark)
+	} else {
+		records, nextBookmark, err1 = newRepository(ctx).QueryEstates(estateSelector(estateFilter), int32(pageSize), bookmark)
+	}
+
+	if err1 != nil {
+		return result, fmt.Errorf("QueryEstates >> %s", err1.Error())
+	}
+
+	now, err2 := getTxDateTime(ctx)
+	if err2 != nil {
+		return result, fmt.Errorf("QueryEstates >> %s", err2.Error())
+	}
+
+	// expired requests are only kept until they are purged
+	for i := range records {
+		records[i].Estate.Requests = activeRequests(records[i].Estate.Requests, now)
+	}
+
+	result.Records = records
+	result.Bookmark = nextBookmark
+
+	return result, nil
+}
+
+// rounds of the negotiation on the current request of a buyer, oldest first,
+// with prices for the parties and the admins of the office
+func (s *SmartContract) GetOffers(ctx contractapi.TransactionContextInterface, serveyNo string, buyer string) ([]Offer, error) {
+
+	offers := []Offer{}
+
+	repo := newRepository(ctx)
+
+	estate, err0 := repo.GetEstate(serveyNo)
+	if err0 != nil {
+		return offers, fmt.Errorf("GetOffers >> %s", err0.Error())
+	}
+
+	now, err2 := getTxDateTime(ctx)
+	if err2 != nil {
+		return offers, fmt.Errorf("GetOffers >> %s", err2.Error())
+	}
+
+	index := searchRequest(estate.Requests, buyer)
+	if index == -1 || isExpired(estate.Requests[index].ExpiresOn, now) {
+		return offers, fmt.Errorf("GetOffers >> No request found for given buyer: %s", buyer)
+	}
+
+	// requests made before negotiations were added have no offers
+	if estate.Requests[index].ID == "" {
+		return offers, nil
+	}
+
+	records, err1 := repo.GetOffers(serveyNo, buyer, estate.Requests[index].ID)
+	if err1 != nil {
+		return offers, fmt.Errorf("GetOffers >> %s", err1.Error())
+	}
+
+	// prices only for the parties and the admins of the office, from a peer
+	// that has the private data
+	_, err3 := s.authorizeParty(ctx, "GetOffers", estate.OfficeCode, append(estateOwnerUIDs(estate), buyer)...)
+	if err3 != nil {
+		return records, nil
+	}
+
+	for i := range records {
+		offerKey, err4 := repo.OfferKey(serveyNo, buyer, estate.Requests[index].ID, records[i].Round)
+		if err4 != nil {
+			return offers, fmt.Errorf("GetOffers >> %s", err4.Error())
+		}
+
+		price, err5 := s.getPrice(ctx, estate.OfficeCode, offerKey, records[i].PriceHash, records[i].Price)
+		if err5 != nil {
+			return offers, fmt.Errorf("GetOffers >> %s", err5.Error())
+		}
+
+		records[i].Price = price.Price
+	}
+
+	return records, nil
+}
+
+// requests on an estate that have not expired
+func (s *SmartContract) GetRequests(ctx contractapi.TransactionContextInterface, serveyNo string) ([]Request, error) {
+
+	estate, err0 := newRepository(ctx).GetEstate(serveyNo)
+	if err0 != nil {
+		return []Request{}, fmt.Errorf("GetRequests >> %s", err0.Error())
+	}
+
+	now, err1 := getTxDateTime(ctx)
+	if err1 != nil {
+		return []Request{}, fmt.Errorf("GetRequests >> %s", err1.Error())
+	}
+
+	return activeRequests(estate.Requests, now), nil
+}
+
+// succession of an estate, the pending one if successionID is empty
+func (s *SmartContract) GetSuccession(ctx contractapi.TransactionContextInterface, serveyNo string, successionID string) (Succession, error) {
+
+	repo := newRepository(ctx)
+
+	if successionID == "" {
+		estate, err0 := repo.GetEstate(serveyNo)
+		if err0 != nil {
+			return Succession{}, fmt.Errorf("GetSuccession >> %s", err0.Error())
+		}
+
+		if estate.Succession == "" {
+			return Succession{}, fmt.Errorf("GetSuccession >> No succession is pending for %s", serveyNo)
+		}
+
+		successionID = estate.Succession
+	}
+
+	succession, err1 := repo.GetSuccession(serveyNo, successionID)
+	if err1 != nil {
+		return Succession{}, fmt.Errorf("GetSuccession >> %s", err1.Error())
+	}
+
+	return *succession, nil
+}
+
+// auction of an estate, the running one if auctionID is empty, prices of
+// sealed bids are only set once revealed
+func (s *SmartContract) GetAuction(ctx contractapi.TransactionContextInterface, serveyNo string, auctionID string) (Auction, error) {
+
+	repo := newRepository(ctx)
+
+	if auctionID == "" {
+		estate, err0 := repo.GetEstate(serveyNo)
+		if err0 != nil {
+			return Auction{}, fmt.Errorf("GetAuction >> %s", err0.Error())
+		}
+
+		if estate.Auction == "" {
+			return Auction{}, fmt.Errorf("GetAuction >> No auction is running for %s", serveyNo)
+		}
+
+		auctionID = estate.Auction
+	}
+
+	auction, err1 := repo.GetAuction(serveyNo, auctionID)
+	if err1 != nil {
+		return Auction{}, fmt.Errorf("GetAuction >> %s", err1.Error())
+	}
+
+	return *auction, nil
+}
+
+// ------------------------------------
+
+// Helper Functions - Private
+
+// selector for the fields of the filter other than Owner
+func estateSelector(filter Estate_Filter) map[string]interface{} {
+
+	selector := map[string]interface{}{}
+
+	if filter.OfficeCode != "" {
+		selector["officeCode"] = filter.OfficeCode
+	}
+	if filter.State != "" {
+		selector["state"] = filter.State
+	}
+
+	area := map[string]interface{}{}
+	if filter.MinArea != nil {
+		area["$gte"] = *filter.MinArea
+	}
+	if filter.MaxArea != nil {
+		area["$lte"] = *filter.MaxArea
+	}
+	if len(area) > 0 {
+		selector["area"] = area
+	}
+
+	return selector
+}
+
+// page of the estates in Owned of filter.Owner matching the rest of the
+// filter, the bookmark is the position in Owned to continue from
+func queryOwnedEstates(ctx contractapi.TransactionContextInterface, filter Estate_Filter, pageSize int, bookmark string) ([]Estate_Record, string, error) {
+
+	records := []Estate_Record{}
+
+	start := 0
+	if bookmark != "" {
+		var err0 error
+		start, err0 = strconv.Atoi(bookmark)
+		if err0 != nil || start < 0 {
+			return records, "", fmt.Errorf("queryOwnedEstates >> bookmark %q is not valid", bookmark)
+		}
+	}
+
+	repo := newRepository(ctx)
+
+	exists, err1 := repo.UserExists(filter.Owner)
+	if err1 != nil {
+		return records, "", fmt.Errorf("queryOwnedEstates >> %s", err1.Error())
+	} else if !exists {
+		return records, "", nil
+	}
+
+	user, err2 := repo.GetUser(filter.Owner)
+	if err2 != nil {
+		return records, "", fmt.Errorf("queryOwnedEstates >> %s", err2.Error())
+	}
+
+	for i := start; i < len(user.Owned); i++ {
+		if len(records) == pageSize {
+			return records, strconv.Itoa(i), nil
+		}
+
+		estate, err3 := repo.GetEstate(user.Owned[i])
+		if err3 != nil {
+			return records, "", fmt.Errorf("queryOwnedEstates >> %s", err3.Error())
+		}
+
+		if matchesFilter(estate, filter) {
+			records = append(records, Estate_Record{ServeyNo: user.Owned[i], Estate: *estate})
+		}
+	}
+
+	return records, "", nil
+}
+
+func matchesFilter(estate *Estate, filter Estate_Filter) bool {
+
+	if filter.Owner != "" && !isOwner(estate, filter.Owner) {
+		return false
+	}
+	if filter.OfficeCode != "" && estate.OfficeCode != filter.OfficeCode {
+		return false
+	}
+	if filter.State != "" && estate.State != filter.State {
+		return false
+	}
+	if filter.MinArea != nil && estate.Area < *filter.MinArea {
+		return false
+	}
+	if filter.MaxArea != nil && estate.Area > *filter.MaxArea {
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,1136 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Repository
+//
+// All records are stored under composite keys, one namespace per record type:
+//
+//	estate       serveyNo
+//	user         uid
+//	admin        "super" | "office", officeCode
+//	txn          serveyNo, transaction number (zero padded, sorts in order)
+//	offer        serveyNo, buyer, request id, round (zero padded)
+//	auction      serveyNo, auction id
+//	bid          serveyNo, auction id, bidder (private data of the bidder's org)
+//	identity     mspId, enrollmentId
+//	violation    txId
+//	historical   txId, field
+//	cancellation serveyNo, txId
+//	bank         bankCode
+//	lien         serveyNo, lien id
+//	lease        serveyNo, lease id
+//	succession   serveyNo, succession id
+//	config       (none)
+//
+// Private data (see privacy.go) is stored under the key of the public record
+// it belongs to.
+//
+// Attributes are separated by a 0x00 byte, so a serveyNo containing "_" can
+// not collide with another key, and partial key queries never mix types.
+// Usernames used in credentials (admin_super, admin_<officeCode>, user_<uid>)
+// are mapped to keys with UsernameKey.
+
+const (
+	ObjectType_Estate       = "estate"
+	ObjectType_User         = "user"
+	ObjectType_Admin        = "admin"
+	ObjectType_Transaction  = "txn"
+	ObjectType_Offer        = "offer"
+	ObjectType_Auction      = "auction"
+	ObjectType_Bid          = "bid"
+	ObjectType_Identity     = "identity"
+	ObjectType_Violation    = "violation"
+	ObjectType_Historical   = "historical"
+	ObjectType_Cancellation = "cancellation"
+	ObjectType_Config       = "config"
+	ObjectType_Bank         = "bank"
+	ObjectType_Lien         = "lien"
+	ObjectType_Lease        = "lease"
+	ObjectType_Succession   = "succession"
+)
+
+type Repository struct {
+	stub shim.ChaincodeStubInterface
+}
+
+func newRepository(ctx contractapi.TransactionContextInterface) *Repository {
+	return &Repository{stub: ctx.GetStub()}
+}
+
+// ------------------------------------
+
+// Keys
+
+func (r *Repository) EstateKey(serveyNo string) (string, error) {
+	return r.stub.CreateCompositeKey(ObjectType_Estate, []string{serveyNo})
+}
+
+func (r *Repository) UserKey(uid string) (string, error) {
+	return r.stub.CreateCompositeKey(ObjectType_User, []string{uid})
+}
+
+func (r *Repository) AdminKey(officeCode string) (string, error) {
+	return r.stub.CreateCompositeKey(ObjectType_Admin, []string{"office", officeCode})
+}
+
+func (r *Repository) SuperAdminKey() (string, error) {
+	return r.stub.CreateCompositeKey(ObjectType_Admin, []string{"super"})
+}
+
+func (r *Repository) TransactionKey(serveyNo string, num int) (string, error) {
+	return r.stub.CreateCompositeKey(ObjectType_Transaction, []string{serveyNo, fmt.Sprintf("%08d", num)})
+}
+
+func (r *Repository) OfferKey(serveyNo string, buyer string, requestID string, round int) (string, error) {
+	return r.stub.CreateCompositeKey(ObjectType_Offer, []string{serveyNo, buyer, requestID, fmt.Sprintf("%08d", round)})
+}
+
+func (r *Repository) AuctionKey(serveyNo string, auctionID string) (string, error) {
+	return r.stub.CreateCompositeKey(ObjectType_Auction, []string{serveyNo, auctionID})
+}
+
+func (r *Repository) BidKey(serveyNo string, auctionID string, bidder string) (string, error) {
+	return r.stub.CreateCompositeKey(ObjectType_Bid, []string{serveyNo, auctionID, bidder})
+}
+
+func (r *Repository) CancellationKey(serveyNo string, txID string) (string, error) {
+	return r.stub.CreateCompositeKey(ObjectType_Cancellation, []string{serveyNo, txID})
+}
+
+func (r *Repository) BankKey(bankCode string) (string, error) {
+	return r.stub.CreateCompositeKey(ObjectType_Bank, []string{bankCode})
+}
+
+func (r *Repository) LienKey(serveyNo string, lienID string) (string, error) {
+	return r.stub.CreateCompositeKey(ObjectType_Lien, []string{serveyNo, lienID})
+}
+
+func (r *Repository) LeaseKey(serveyNo string, leaseID string) (string, error) {
+	return r.stub.CreateCompositeKey(ObjectType_Lease, []string{serveyNo, leaseID})
+}
+
+func (r *Repository) SuccessionKey(serveyNo string, successionID string) (string, error) {
+	return r.stub.CreateCompositeKey(ObjectType_Succession, []string{serveyNo, successionID})
+}
+
+func (r *Repository) IdentityKey(identity Identity) (string, error) {
+	return r.stub.CreateCompositeKey(ObjectType_Identity, []string{identity.MSPID, identity.EnrollmentID})
+}
+
+// admin_super, admin_<officeCode>, user_<uid>
+func (r *Repository) UsernameKey(username string) (string, error) {
+
+	if username == "admin_super" {
+		return r.SuperAdminKey()
+	} else if strings.HasPrefix(username, "admin_") {
+		return r.AdminKey(strings.TrimPrefix(username, "admin_"))
+	} else if strings.HasPrefix(username, "user_") {
+		return r.UserKey(strings.TrimPrefix(username, "user_"))
+	}
+
+	return "", fmt.Errorf("UsernameKey >> %s is not a valid username", username)
+}
+
+// ------------------------------------
+
+// Estates
+
+func (r *Repository) GetEstate(serveyNo string) (*Estate, error) {
+
+	key, err0 := r.EstateKey(serveyNo)
+	if err0 != nil {
+		return nil, fmt.Errorf("GetEstate >> %s", err0.Error())
+	}
+
+	estate := new(Estate)
+	err1 := r.get(key, estate)
+	if err1 != nil {
+		return nil, fmt.Errorf("GetEstate >> estate %s %s", serveyNo, err1.Error())
+	}
+
+	return estate, nil
+}
+
+func (r *Repository) EstateExists(serveyNo string) (bool, error) {
+
+	key, err0 := r.EstateKey(serveyNo)
+	if err0 != nil {
+		return false, fmt.Errorf("EstateExists >> %s", err0.Error())
+	}
+
+	return r.exists(key)
+}
+
+func (r *Repository) PutEstate(serveyNo string, estate *Estate) error {
+
+	key, err0 := r.EstateKey(serveyNo)
+	if err0 != nil {
+		return fmt.Errorf("PutEstate >> %s", err0.Error())
+	}
+
+	estate.DocType = ObjectType_Estate
+
+	err1 := r.put(key, estate)
+	if err1 != nil {
+		return fmt.Errorf("PutEstate >> %s", err1.Error())
+	}
+
+	return nil
+}
+
+// CouchDB selector over estates, docType is added to the selector,
+// indexes are in META-INF/statedb/couchdb/indexes
+func (r *Repository) QueryEstates(selector map[string]interface{}, pageSize int32, bookmark string) ([]Estate_Record, string, error) {
+
+	selector["docType"] = ObjectType_Estate
+
+	query, _ := json.Marshal(map[string]interface{}{"selector": selector})
+
+	resultsIterator, metadata, err0 := r.stub.GetQueryResultWithPagination(string(query), pageSize, bookmark)
+	if err0 != nil {
+		return nil, "", fmt.Errorf("QueryEstates >> Failed to query world state. %s", err0.Error())
+	}
+	defer resultsIterator.Close()
+
+	records := []Estate_Record{}
+
+	for resultsIterator.HasNext() {
+		queryResponse, err1 := resultsIterator.Next()
+		if err1 != nil {
+			return nil, "", fmt.Errorf("QueryEstates >> %s", err1.Error())
+		}
+
+		_, attributes, err2 := r.stub.SplitCompositeKey(queryResponse.Key)
+		if err2 != nil || len(attributes) != 1 {
+			return nil, "", fmt.Errorf("QueryEstates >> Malformed key %q", queryResponse.Key)
+		}
+
+		record := Estate_Record{ServeyNo: attributes[0]}
+		err3 := json.Unmarshal(queryResponse.Value, &record.Estate)
+		if err3 != nil {
+			return nil, "", fmt.Errorf("QueryEstates >> Can't Unmarshal Data")
+		}
+
+		records = append(records, record)
+	}
+
+	// last page
+	if metadata == nil || metadata.FetchedRecordsCount < pageSize {
+		return records, "", nil
+	}
+
+	return records, metadata.Bookmark, nil
+}
+
+// CouchDB selector over estates like QueryEstates, unpaginated so it can be
+// used in transactions that write, at most limit records are returned and
+// more is true if there are others
+func (r *Repository) FindEstates(selector map[string]interface{}, limit int) ([]Estate_Record, bool, error) {
+
+	selector["docType"] = ObjectType_Estate
+
+	query, _ := json.Marshal(map[string]interface{}{"selector": selector})
+
+	resultsIterator, err0 := r.stub.GetQueryResult(string(query))
+	if err0 != nil {
+		return nil, false, fmt.Errorf("FindEstates >> Failed to query world state. %s", err0.Error())
+	}
+	defer resultsIterator.Close()
+
+	records := []Estate_Record{}
+
+	for resultsIterator.HasNext() {
+		if len(records) == limit {
+			return records, true, nil
+		}
+
+		queryResponse, err1 := resultsIterator.Next()
+		if err1 != nil {
+			return nil, false, fmt.Errorf("FindEstates >> %s", err1.Error())
+		}
+
+		_, attributes, err2 := r.stub.SplitCompositeKey(queryResponse.Key)
+		if err2 != nil || len(attributes) != 1 {
+			return nil, false, fmt.Errorf("FindEstates >> Malformed key %q", queryResponse.Key)
+		}
+
+		record := Estate_Record{ServeyNo: attributes[0]}
+		err3 := json.Unmarshal(queryResponse.Value, &record.Estate)
+		if err3 != nil {
+			return nil, false, fmt.Errorf("FindEstates >> Can't Unmarshal Data")
+		}
+
+		records = append(records, record)
+	}
+
+	return records, false, nil
+}
+
+// every version of the estate, in the order returned by the ledger
+func (r *Repository) GetEstateVersions(serveyNo string) ([]Estate_Version, error) {
+
+	key, err0 := r.EstateKey(serveyNo)
+	if err0 != nil {
+		return nil, fmt.Errorf("GetEstateVersions >> %s", err0.Error())
+	}
+
+	resultsIterator, err1 := r.stub.GetHistoryForKey(key)
+	if err1 != nil {
+		return nil, fmt.Errorf("GetEstateVersions >> Failed to read history. %s", err1.Error())
+	}
+	defer resultsIterator.Close()
+
+	versions := []Estate_Version{}
+
+	for resultsIterator.HasNext() {
+		modification, err2 := resultsIterator.Next()
+		if err2 != nil {
+			return nil, fmt.Errorf("GetEstateVersions >> %s", err2.Error())
+		}
+
+		version := Estate_Version{
+			TxID:     modification.TxId,
+			IsDelete: modification.IsDelete,
+		}
+
+		if modification.Timestamp != nil {
+			version.Timestamp = time.Unix(modification.Timestamp.Seconds, int64(modification.Timestamp.Nanos)).UTC()
+		}
+
+		if !modification.IsDelete {
+			err3 := json.Unmarshal(modification.Value, &version.Estate)
+			if err3 != nil {
+				return nil, fmt.Errorf("GetEstateVersions >> Can't Unmarshal Data")
+			}
+		}
+
+		versions = append(versions, version)
+	}
+
+	return versions, nil
+}
+
+// ------------------------------------
+
+// Users
+
+func (r *Repository) GetUser(uid string) (*User, error) {
+
+	key, err0 := r.UserKey(uid)
+	if err0 != nil {
+		return nil, fmt.Errorf("GetUser >> %s", err0.Error())
+	}
+
+	user := new(User)
+	err1 := r.get(key, user)
+	if err1 != nil {
+		return nil, fmt.Errorf("GetUser >> user %s %s", uid, err1.Error())
+	}
+
+	return user, nil
+}
+
+func (r *Repository) UserExists(uid string) (bool, error) {
+
+	key, err0 := r.UserKey(uid)
+	if err0 != nil {
+		return false, fmt.Errorf("UserExists >> %s", err0.Error())
+	}
+
+	return r.exists(key)
+}
+
+func (r *Repository) PutUser(uid string, user *User) error {
+
+	key, err0 := r.UserKey(uid)
+	if err0 != nil {
+		return fmt.Errorf("PutUser >> %s", err0.Error())
+	}
+
+	err1 := r.put(key, user)
+	if err1 != nil {
+		return fmt.Errorf("PutUser >> %s", err1.Error())
+	}
+
+	return nil
+}
+
+// ------------------------------------
+
+// Admins
+
+func (r *Repository) GetAdmin(officeCode string) (*Admin_OfficeCode, error) {
+
+	key, err0 := r.AdminKey(officeCode)
+	if err0 != nil {
+		return nil, fmt.Errorf("GetAdmin >> %s", err0.Error())
+	}
+
+	admin := new(Admin_OfficeCode)
+	err1 := r.get(key, admin)
+	if err1 != nil {
+		return nil, fmt.Errorf("GetAdmin >> admin of %s %s", officeCode, err1.Error())
+	}
+
+	return admin, nil
+}
+
+func (r *Repository) AdminExists(officeCode string) (bool, error) {
+
+	key, err0 := r.AdminKey(officeCode)
+	if err0 != nil {
+		return false, fmt.Errorf("AdminExists >> %s", err0.Error())
+	}
+
+	return r.exists(key)
+}
+
+func (r *Repository) PutAdmin(officeCode string, admin *Admin_OfficeCode) error {
+
+	key, err0 := r.AdminKey(officeCode)
+	if err0 != nil {
+		return fmt.Errorf("PutAdmin >> %s", err0.Error())
+	}
+
+	err1 := r.put(key, admin)
+	if err1 != nil {
+		return fmt.Errorf("PutAdmin >> %s", err1.Error())
+	}
+
+	return nil
+}
+
+func (r *Repository) SuperAdminExists() (bool, error) {
+
+	key, err0 := r.SuperAdminKey()
+	if err0 != nil {
+		return false, fmt.Errorf("SuperAdminExists >> %s", err0.Error())
+	}
+
+	return r.exists(key)
+}
+
+func (r *Repository) PutSuperAdmin(admin *Admin_Super) error {
+
+	key, err0 := r.SuperAdminKey()
+	if err0 != nil {
+		return fmt.Errorf("PutSuperAdmin >> %s", err0.Error())
+	}
+
+	err1 := r.put(key, admin)
+	if err1 != nil {
+		return fmt.Errorf("PutSuperAdmin >> %s", err1.Error())
+	}
+
+	return nil
+}
+
+// ------------------------------------
+
+// Transactions
+
+func (r *Repository) GetTransaction(serveyNo string, num int) (*Transaction, error) {
+
+	key, err0 := r.TransactionKey(serveyNo, num)
+	if err0 != nil {
+		return nil, fmt.Errorf("GetTransaction >> %s", err0.Error())
+	}
+
+	transaction := new(Transaction)
+	err1 := r.get(key, transaction)
+	if err1 != nil {
+		return nil, fmt.Errorf("GetTransaction >> transaction %d of %s %s", num, serveyNo, err1.Error())
+	}
+
+	return transaction, nil
+}
+
+func (r *Repository) TransactionExists(serveyNo string, num int) (bool, error) {
+
+	key, err0 := r.TransactionKey(serveyNo, num)
+	if err0 != nil {
+		return false, fmt.Errorf("TransactionExists >> %s", err0.Error())
+	}
+
+	return r.exists(key)
+}
+
+func (r *Repository) PutTransaction(serveyNo string, num int, transaction *Transaction) error {
+
+	key, err0 := r.TransactionKey(serveyNo, num)
+	if err0 != nil {
+		return fmt.Errorf("PutTransaction >> %s", err0.Error())
+	}
+
+	err1 := r.put(key, transaction)
+	if err1 != nil {
+		return fmt.Errorf("PutTransaction >> %s", err1.Error())
+	}
+
+	return nil
+}
+
+// transactions of an estate in order of their number, one page at a time,
+// only TransactionCount and Transaction of the records are set
+func (r *Repository) GetTransactionsPage(serveyNo string, pageSize int32, bookmark string) ([]Title_Record, string, error) {
+
+	resultsIterator, metadata, err0 := r.stub.GetStateByPartialCompositeKeyWithPagination(ObjectType_Transaction, []string{serveyNo}, pageSize, bookmark)
+	if err0 != nil {
+		return nil, "", fmt.Errorf("GetTransactionsPage >> Failed to read from world state. %s", err0.Error())
+	}
+	defer resultsIterator.Close()
+
+	records := []Title_Record{}
+
+	for resultsIterator.HasNext() {
+		queryResponse, err1 := resultsIterator.Next()
+		if err1 != nil {
+			return nil, "", fmt.Errorf("GetTransactionsPage >> %s", err1.Error())
+		}
+
+		_, attributes, err2 := r.stub.SplitCompositeKey(queryResponse.Key)
+		if err2 != nil || len(attributes) != 2 {
+			return nil, "", fmt.Errorf("GetTransactionsPage >> Malformed key %q", queryResponse.Key)
+		}
+
+		num, err3 := strconv.Atoi(attributes[1])
+		if err3 != nil {
+			return nil, "", fmt.Errorf("GetTransactionsPage >> Malformed key %q", queryResponse.Key)
+		}
+
+		record := Title_Record{TransactionCount: num}
+		err4 := json.Unmarshal(queryResponse.Value, &record.Transaction)
+		if err4 != nil {
+			return nil, "", fmt.Errorf("GetTransactionsPage >> Can't Unmarshal Data")
+		}
+
+		records = append(records, record)
+	}
+
+	// last page
+	if metadata == nil || metadata.FetchedRecordsCount < pageSize {
+		return records, "", nil
+	}
+
+	return records, metadata.Bookmark, nil
+}
+
+func (r *Repository) DelTransaction(serveyNo string, num int) error {
+
+	key, err0 := r.TransactionKey(serveyNo, num)
+	if err0 != nil {
+		return fmt.Errorf("DelTransaction >> %s", err0.Error())
+	}
+
+	err1 := r.stub.DelState(key)
+	if err1 != nil {
+		return fmt.Errorf("DelTransaction >> Failed to delete from world state. %s", err1.Error())
+	}
+
+	return nil
+}
+
+// ------------------------------------
+
+// Offers, immutable
+
+func (r *Repository) PutOffer(serveyNo string, buyer string, requestID string, offer *Offer) error {
+
+	key, err0 := r.OfferKey(serveyNo, buyer, requestID, offer.Round)
+	if err0 != nil {
+		return fmt.Errorf("PutOffer >> %s", err0.Error())
+	}
+
+	exists, err1 := r.exists(key)
+	if err1 != nil {
+		return fmt.Errorf("PutOffer >> %s", err1.Error())
+	}
+
+	if exists {
+		return fmt.Errorf("PutOffer >> offer %d of %s on %s already exists", offer.Round, buyer, serveyNo)
+	}
+
+	err2 := r.put(key, offer)
+	if err2 != nil {
+		return fmt.Errorf("PutOffer >> %s", err2.Error())
+	}
+
+	return nil
+}
+
+// offers of a request in order of their round
+func (r *Repository) GetOffers(serveyNo string, buyer string, requestID string) ([]Offer, error) {
+
+	resultsIterator, err0 := r.stub.GetStateByPartialCompositeKey(ObjectType_Offer, []string{serveyNo, buyer, requestID})
+	if err0 != nil {
+		return nil, fmt.Errorf("GetOffers >> Failed to read from world state. %s", err0.Error())
+	}
+	defer resultsIterator.Close()
+
+	offers := []Offer{}
+
+	for resultsIterator.HasNext() {
+		queryResponse, err1 := resultsIterator.Next()
+		if err1 != nil {
+			return nil, fmt.Errorf("GetOffers >> %s", err1.Error())
+		}
+
+		offer := Offer{}
+		err2 := json.Unmarshal(queryResponse.Value, &offer)
+		if err2 != nil {
+			return nil, fmt.Errorf("GetOffers >> Can't Unmarshal Data")
+		}
+
+		offers = append(offers, offer)
+	}
+
+	return offers, nil
+}
+
+// ------------------------------------
+
+// Auctions
+
+func (r *Repository) GetAuction(serveyNo string, auctionID string) (*Auction, error) {
+
+	key, err0 := r.AuctionKey(serveyNo, auctionID)
+	if err0 != nil {
+		return nil, fmt.Errorf("GetAuction >> %s", err0.Error())
+	}
+
+	auction := new(Auction)
+	err1 := r.get(key, auction)
+	if err1 != nil {
+		return nil, fmt.Errorf("GetAuction >> auction %s of %s %s", auctionID, serveyNo, err1.Error())
+	}
+
+	return auction, nil
+}
+
+func (r *Repository) PutAuction(serveyNo string, auction *Auction) error {
+
+	key, err0 := r.AuctionKey(serveyNo, auction.ID)
+	if err0 != nil {
+		return fmt.Errorf("PutAuction >> %s", err0.Error())
+	}
+
+	err1 := r.put(key, auction)
+	if err1 != nil {
+		return fmt.Errorf("PutAuction >> %s", err1.Error())
+	}
+
+	return nil
+}
+
+func (r *Repository) GetPrivateBid(collection string, serveyNo string, auctionID string, bidder string) (*Private_Bid, error) {
+
+	key, err0 := r.BidKey(serveyNo, auctionID, bidder)
+	if err0 != nil {
+		return nil, fmt.Errorf("GetPrivateBid >> %s", err0.Error())
+	}
+
+	dataAsBytes, err1 := r.stub.GetPrivateData(collection, key)
+	if err1 != nil {
+		return nil, fmt.Errorf("GetPrivateBid >> Failed to read from %s. %s", collection, err1.Error())
+	}
+
+	if dataAsBytes == nil {
+		return nil, fmt.Errorf("GetPrivateBid >> bid of %s does not exist in %s", bidder, collection)
+	}
+
+	bid := new(Private_Bid)
+	err2 := json.Unmarshal(dataAsBytes, bid)
+	if err2 != nil {
+		return nil, fmt.Errorf("GetPrivateBid >> Can't Unmarshal Data")
+	}
+
+	return bid, nil
+}
+
+func (r *Repository) PutPrivateBid(collection string, serveyNo string, auctionID string, bidder string, bid *Private_Bid) error {
+
+	key, err0 := r.BidKey(serveyNo, auctionID, bidder)
+	if err0 != nil {
+		return fmt.Errorf("PutPrivateBid >> %s", err0.Error())
+	}
+
+	marshaled_data, _ := json.Marshal(bid)
+	err1 := r.stub.PutPrivateData(collection, key, marshaled_data)
+	if err1 != nil {
+		return fmt.Errorf("PutPrivateBid >> Failed to put to %s. %s", collection, err1.Error())
+	}
+
+	return nil
+}
+
+// ------------------------------------
+
+// Private data, false if the key does not exist in the collection
+
+func (r *Repository) GetPrivate(collection string, key string, v interface{}) (bool, error) {
+
+	dataAsBytes, err0 := r.stub.GetPrivateData(collection, key)
+	if err0 != nil {
+		return false, fmt.Errorf("GetPrivate >> Failed to read from %s. %s", collection, err0.Error())
+	}
+
+	if dataAsBytes == nil {
+		return false, nil
+	}
+
+	err1 := json.Unmarshal(dataAsBytes, v)
+	if err1 != nil {
+		return false, fmt.Errorf("GetPrivate >> Can't Unmarshal Data")
+	}
+
+	return true, nil
+}
+
+func (r *Repository) PutPrivate(collection string, key string, v interface{}) error {
+
+	marshaled_data, _ := json.Marshal(v)
+	err0 := r.stub.PutPrivateData(collection, key, marshaled_data)
+	if err0 != nil {
+		return fmt.Errorf("PutPrivate >> Failed to put to %s. %s", collection, err0.Error())
+	}
+
+	return nil
+}
+
+// ------------------------------------
+
+// Records by username, to handle the data from unknown/misc structs
+
+func (r *Repository) GetRecord(username string) (map[string]interface{}, error) {
+
+	key, err0 := r.UsernameKey(username)
+	if err0 != nil {
+		return nil, fmt.Errorf("GetRecord >> %s", err0.Error())
+	}
+
+	data := make(map[string]interface{})
+	err1 := r.get(key, &data)
+	if err1 != nil {
+		return nil, fmt.Errorf("GetRecord >> %s %s", username, err1.Error())
+	}
+
+	return data, nil
+}
+
+func (r *Repository) PutRecord(username string, data map[string]interface{}) error {
+
+	key, err0 := r.UsernameKey(username)
+	if err0 != nil {
+		return fmt.Errorf("PutRecord >> %s", err0.Error())
+	}
+
+	err1 := r.put(key, data)
+	if err1 != nil {
+		return fmt.Errorf("PutRecord >> %s", err1.Error())
+	}
+
+	return nil
+}
+
+// ------------------------------------
+
+// Identities, username bound to an identity
+
+func (r *Repository) GetIdentity(identity Identity) (string, error) {
+
+	key, err0 := r.IdentityKey(identity)
+	if err0 != nil {
+		return "", fmt.Errorf("GetIdentity >> %s", err0.Error())
+	}
+
+	dataAsBytes, err1 := r.stub.GetState(key)
+	if err1 != nil {
+		return "", fmt.Errorf("GetIdentity >> Failed to read from world state. %s", err1.Error())
+	}
+
+	return string(dataAsBytes), nil
+}
+
+func (r *Repository) PutIdentity(identity Identity, username string) error {
+
+	key, err0 := r.IdentityKey(identity)
+	if err0 != nil {
+		return fmt.Errorf("PutIdentity >> %s", err0.Error())
+	}
+
+	err1 := r.stub.PutState(key, []byte(username))
+	if err1 != nil {
+		return fmt.Errorf("PutIdentity >> Failed to put to world state. %s", err1.Error())
+	}
+
+	return nil
+}
+
+func (r *Repository) DelIdentity(identity Identity) error {
+
+	key, err0 := r.IdentityKey(identity)
+	if err0 != nil {
+		return fmt.Errorf("DelIdentity >> %s", err0.Error())
+	}
+
+	err1 := r.stub.DelState(key)
+	if err1 != nil {
+		return fmt.Errorf("DelIdentity >> Failed to delete from world state. %s", err1.Error())
+	}
+
+	return nil
+}
+
+// ------------------------------------
+
+// Audit records, keyed by the transaction that wrote them
+
+func (r *Repository) PutViolation(txID string, violation *Jurisdiction_Violation) error {
+
+	key, err0 := r.stub.CreateCompositeKey(ObjectType_Violation, []string{txID})
+	if err0 != nil {
+		return fmt.Errorf("PutViolation >> %s", err0.Error())
+	}
+
+	err1 := r.put(key, violation)
+	if err1 != nil {
+		return fmt.Errorf("PutViolation >> %s", err1.Error())
+	}
+
+	return nil
+}
+
+func (r *Repository) PutHistoricalDate(txID string, historical *Historical_Date) error {
+
+	key, err0 := r.stub.CreateCompositeKey(ObjectType_Historical, []string{txID, historical.Field})
+	if err0 != nil {
+		return fmt.Errorf("PutHistoricalDate >> %s", err0.Error())
+	}
+
+	err1 := r.put(key, historical)
+	if err1 != nil {
+		return fmt.Errorf("PutHistoricalDate >> %s", err1.Error())
+	}
+
+	return nil
+}
+
+// ------------------------------------
+
+// Sale cancellations
+
+func (r *Repository) PutCancellation(serveyNo string, txID string, cancellation *Cancellation) error {
+
+	key, err0 := r.CancellationKey(serveyNo, txID)
+	if err0 != nil {
+		return fmt.Errorf("PutCancellation >> %s", err0.Error())
+	}
+
+	err1 := r.put(key, cancellation)
+	if err1 != nil {
+		return fmt.Errorf("PutCancellation >> %s", err1.Error())
+	}
+
+	return nil
+}
+
+// ------------------------------------
+
+// Banks and liens
+
+func (r *Repository) GetBank(bankCode string) (*Bank, error) {
+
+	key, err0 := r.BankKey(bankCode)
+	if err0 != nil {
+		return nil, fmt.Errorf("GetBank >> %s", err0.Error())
+	}
+
+	bank := new(Bank)
+	err1 := r.get(key, bank)
+	if err1 != nil {
+		return nil, fmt.Errorf("GetBank >> bank %s %s", bankCode, err1.Error())
+	}
+
+	return bank, nil
+}
+
+func (r *Repository) BankExists(bankCode string) (bool, error) {
+
+	key, err0 := r.BankKey(bankCode)
+	if err0 != nil {
+		return false, fmt.Errorf("BankExists >> %s", err0.Error())
+	}
+
+	return r.exists(key)
+}
+
+func (r *Repository) PutBank(bankCode string, bank *Bank) error {
+
+	key, err0 := r.BankKey(bankCode)
+	if err0 != nil {
+		return fmt.Errorf("PutBank >> %s", err0.Error())
+	}
+
+	err1 := r.put(key, bank)
+	if err1 != nil {
+		return fmt.Errorf("PutBank >> %s", err1.Error())
+	}
+
+	return nil
+}
+
+func (r *Repository) GetLien(serveyNo string, lienID string) (*Lien, error) {
+
+	key, err0 := r.LienKey(serveyNo, lienID)
+	if err0 != nil {
+		return nil, fmt.Errorf("GetLien >> %s", err0.Error())
+	}
+
+	lien := new(Lien)
+	err1 := r.get(key, lien)
+	if err1 != nil {
+		return nil, fmt.Errorf("GetLien >> lien %s of %s %s", lienID, serveyNo, err1.Error())
+	}
+
+	return lien, nil
+}
+
+func (r *Repository) PutLien(serveyNo string, lien *Lien) error {
+
+	key, err0 := r.LienKey(serveyNo, lien.ID)
+	if err0 != nil {
+		return fmt.Errorf("PutLien >> %s", err0.Error())
+	}
+
+	err1 := r.put(key, lien)
+	if err1 != nil {
+		return fmt.Errorf("PutLien >> %s", err1.Error())
+	}
+
+	return nil
+}
+
+// every lien of an estate, released or not, in key order
+func (r *Repository) GetLiens(serveyNo string) ([]Lien, error) {
+
+	resultsIterator, err0 := r.stub.GetStateByPartialCompositeKey(ObjectType_Lien, []string{serveyNo})
+	if err0 != nil {
+		return nil, fmt.Errorf("GetLiens >> Failed to read from world state. %s", err0.Error())
+	}
+	defer resultsIterator.Close()
+
+	liens := []Lien{}
+
+	for resultsIterator.HasNext() {
+		queryResponse, err1 := resultsIterator.Next()
+		if err1 != nil {
+			return nil, fmt.Errorf("GetLiens >> %s", err1.Error())
+		}
+
+		lien := Lien{}
+		err2 := json.Unmarshal(queryResponse.Value, &lien)
+		if err2 != nil {
+			return nil, fmt.Errorf("GetLiens >> Can't Unmarshal Data")
+		}
+
+		liens = append(liens, lien)
+	}
+
+	return liens, nil
+}
+
+// ------------------------------------
+
+// Leases
+
+func (r *Repository) GetLease(serveyNo string, leaseID string) (*Lease, error) {
+
+	key, err0 := r.LeaseKey(serveyNo, leaseID)
+	if err0 != nil {
+		return nil, fmt.Errorf("GetLease >> %s", err0.Error())
+	}
+
+	lease := new(Lease)
+	err1 := r.get(key, lease)
+	if err1 != nil {
+		return nil, fmt.Errorf("GetLease >> lease %s of %s %s", leaseID, serveyNo, err1.Error())
+	}
+
+	return lease, nil
+}
+
+func (r *Repository) PutLease(serveyNo string, lease *Lease) error {
+
+	key, err0 := r.LeaseKey(serveyNo, lease.ID)
+	if err0 != nil {
+		return fmt.Errorf("PutLease >> %s", err0.Error())
+	}
+
+	err1 := r.put(key, lease)
+	if err1 != nil {
+		return fmt.Errorf("PutLease >> %s", err1.Error())
+	}
+
+	return nil
+}
+
+// every lease of an estate, in key order
+func (r *Repository) GetLeases(serveyNo string) ([]Lease, error) {
+
+	resultsIterator, err0 := r.stub.GetStateByPartialCompositeKey(ObjectType_Lease, []string{serveyNo})
+	if err0 != nil {
+		return nil, fmt.Errorf("GetLeases >> Failed to read from world state. %s", err0.Error())
+	}
+	defer resultsIterator.Close()
+
+	leases := []Lease{}
+
+	for resultsIterator.HasNext() {
+		queryResponse, err1 := resultsIterator.Next()
+		if err1 != nil {
+			return nil, fmt.Errorf("GetLeases >> %s", err1.Error())
+		}
+
+		lease := Lease{}
+		err2 := json.Unmarshal(queryResponse.Value, &lease)
+		if err2 != nil {
+			return nil, fmt.Errorf("GetLeases >> Can't Unmarshal Data")
+		}
+
+		leases = append(leases, lease)
+	}
+
+	return leases, nil
+}
+
+// ------------------------------------
+
+// Successions
+
+func (r *Repository) GetSuccession(serveyNo string, successionID string) (*Succession, error) {
+
+	key, err0 := r.SuccessionKey(serveyNo, successionID)
+	if err0 != nil {
+		return nil, fmt.Errorf("GetSuccession >> %s", err0.Error())
+	}
+
+	succession := new(Succession)
+	err1 := r.get(key, succession)
+	if err1 != nil {
+		return nil, fmt.Errorf("GetSuccession >> succession %s of %s %s", successionID, serveyNo, err1.Error())
+	}
+
+	return succession, nil
+}
+
+func (r *Repository) PutSuccession(serveyNo string, succession *Succession) error {
+
+	key, err0 := r.SuccessionKey(serveyNo, succession.ID)
+	if err0 != nil {
+		return fmt.Errorf("PutSuccession >> %s", err0.Error())
+	}
+
+	err1 := r.put(key, succession)
+	if err1 != nil {
+		return fmt.Errorf("PutSuccession >> %s", err1.Error())
+	}
+
+	return nil
+}
+
+// ------------------------------------
+
+// Config, defaults when not set yet
+
+func (r *Repository) GetConfig() (*Config, error) {
+
+	key, err0 := r.stub.CreateCompositeKey(ObjectType_Config, []string{})
+	if err0 != nil {
+		return nil, fmt.Errorf("GetConfig >> %s", err0.Error())
+	}
+
+	config := new(Config)
+
+	exists, err1 := r.exists(key)
+	if err1 != nil || !exists {
+		return config, err1
+	}
+
+	err2 := r.get(key, config)
+	if err2 != nil {
+		return nil, fmt.Errorf("GetConfig >> config %s", err2.Error())
+	}
+
+	return config, nil
+}
+
+func (r *Repository) PutConfig(config *Config) error {
+
+	key, err0 := r.stub.CreateCompositeKey(ObjectType_Config, []string{})
+	if err0 != nil {
+		return fmt.Errorf("PutConfig >> %s", err0.Error())
+	}
+
+	err1 := r.put(key, config)
+	if err1 != nil {
+		return fmt.Errorf("PutConfig >> %s", err1.Error())
+	}
+
+	return nil
+}
+
+// ------------------------------------
+
+// Helper Functions - Private
+
+func (r *Repository) get(key string, v interface{}) error {
+
+	dataAsBytes, err0 := r.stub.GetState(key)
+
+	if err0 != nil {
+		return fmt.Errorf("Failed to read from world state. %s", err0.Error())
+	}
+
+	if dataAsBytes == nil {
+		return fmt.Errorf("does not exist")
+	}
+
+	err1 := json.Unmarshal(dataAsBytes, v)
+	if err1 != nil {
+		return fmt.Errorf("Can't Unmarshal Data")
+	}
+
+	return nil
+}
+
+func (r *Repository) exists(key string) (bool, error) {
+
+	dataAsBytes, err0 := r.stub.GetState(key)
+
+	if err0 != nil {
+		return false, fmt.Errorf("Failed to read from world state. %s", err0.Error())
+	}
+
+	return dataAsBytes != nil, nil
+}
+
+func (r *Repository) put(key string, v interface{}) error {
+
+	marshaled_data, _ := json.Marshal(v)
+	err0 := r.stub.PutState(key, marshaled_data)
+	if err0 != nil {
+		return fmt.Errorf("Failed to put to world state. %s", err0.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,575 @@
+package lib
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Succession
+//
+// Title of a deceased co-owner passes to the legal heirs without a purchase
+// request and without a price:
+//
+//  1. an admin marks the owner deceased with RegisterDeath_User, giving the
+//     hash of the death certificate. Deceased users can not act any more.
+//  2. an admin of the estate's office declares the heirs with their shares
+//     of the deceased's share with DeclareHeirs_Estate, the estate is
+//     PendingSuccession until the succession is approved or rejected.
+//  3. until the objection period (Config.ObjectionDays) ends, any verified
+//     user files a claim or objection with Object_Succession. The
+//     sub-registrar dismisses objections found baseless, or rejects the
+//     succession so the heirs can be declared again.
+//  4. once the period ended and every objection is dismissed, the
+//     sub-registrar approves, the share is split among the heirs and the
+//     transfer is recorded as a transaction with reason "inheritance".
+
+const UserStatus_Deceased = 3
+
+const (
+	SuccessionStatus_Pending  = "pending"
+	SuccessionStatus_Approved = "approved"
+	SuccessionStatus_Rejected = "rejected"
+)
+
+// diedOn is an RFC3339 date, empty for the transaction timestamp
+func (s *SmartContract) RegisterDeath_User(ctx contractapi.TransactionContextInterface, uid string, certificateHash string, diedOn string) (User, error) {
+
+	repo := newRepository(ctx)
+
+	user, err1 := repo.GetUser(uid)
+	if err1 != nil {
+		return User{}, fmt.Errorf("RegisterDeath_User >> %s", err1.Error())
+	}
+
+	// admins of the registering office, legacy users have none
+	_, err0 := s.authorizeAdmin(ctx, "RegisterDeath_User", user.OfficeCode)
+	if err0 != nil {
+		return User{}, err0
+	}
+
+	if certificateHash == "" {
+		return User{}, fmt.Errorf("RegisterDeath_User >> certificateHash is required")
+	}
+
+	if user.Status == UserStatus_Deceased {
+		return User{}, fmt.Errorf("RegisterDeath_User >> User %s is already registered as deceased", uid)
+	}
+
+	userKey, err4 := repo.UserKey(uid)
+	if err4 != nil {
+		return User{}, fmt.Errorf("RegisterDeath_User >> %s", err4.Error())
+	}
+
+	temp_dateTime, err2 := s.parseHistoricalDate(ctx, userKey, "deceasedOn", diedOn)
+	if err2 != nil {
+		return User{}, fmt.Errorf("RegisterDeath_User >> %s", err2.Error())
+	}
+
+	if diedOn == "" {
+		temp_dateTime, err2 = getTxDateTime(ctx)
+		if err2 != nil {
+			return User{}, fmt.Errorf("RegisterDeath_User >> %s", err2.Error())
+		}
+	}
+
+	//=====================================
+
+	user.Status = UserStatus_Deceased
+	user.DeceasedOn = temp_dateTime
+	user.DeathCertificate = certificateHash
+
+	err3 := repo.PutUser(uid, user)
+	if err3 != nil {
+		return User{}, fmt.Errorf("RegisterDeath_User >> %s", err3.Error())
+	}
+
+	addEvent(ctx, EventType_UserDeceased, Status_Event{Key: uid, Status: user.Status})
+
+	return *user, nil
+}
+
+// heirs share the deceased's share of the estate, their percents total 100
+func (s *SmartContract) DeclareHeirs_Estate(ctx contractapi.TransactionContextInterface, serveyNo string, deceased string, heirs []Share) (Succession, error) {
+
+	repo := newRepository(ctx)
+
+	estate, err0 := repo.GetEstate(serveyNo)
+	if err0 != nil {
+		return Succession{}, fmt.Errorf("DeclareHeirs_Estate >> %s", err0.Error())
+	}
+
+	caller, err1 := s.authorizeAdmin(ctx, "DeclareHeirs_Estate", estate.OfficeCode)
+	if err1 != nil {
+		return Succession{}, err1
+	}
+
+	//=====================================
+
+	err2 := transitionEstate(ctx, "DeclareHeirs_Estate", serveyNo, estate, Action_DeclareHeirs)
+	if err2 != nil {
+		return Succession{}, err2
+	}
+
+	if !isOwner(estate, deceased) {
+		return Succession{}, &Validation_Error{
+			Code:     ValidationCode_NotOwned,
+			Function: "DeclareHeirs_Estate",
+			ServeyNo: serveyNo,
+			Message:  fmt.Sprintf("Estate is not owned by %s", deceased),
+		}
+	}
+
+	user, err3 := repo.GetUser(deceased)
+	if err3 != nil {
+		return Succession{}, fmt.Errorf("DeclareHeirs_Estate >> %s", err3.Error())
+	}
+
+	if user.Status != UserStatus_Deceased {
+		return Succession{}, fmt.Errorf("DeclareHeirs_Estate >> %s is not registered as deceased, see RegisterDeath_User", deceased)
+	}
+
+	err4 := validateShares(heirs)
+	if err4 != nil {
+		return Succession{}, fmt.Errorf("DeclareHeirs_Estate >> %s", err4.Error())
+	}
+
+	// shares are whole percents of the estate
+	for _, heir := range inheritedShares(ownerShare(estate, deceased), heirs) {
+		if heir.Percent == 0 {
+			return Succession{}, fmt.Errorf("DeclareHeirs_Estate >> %s would inherit less than 1%% of the estate", heir.UID)
+		}
+	}
+
+	for _, heir := range heirs {
+		if heir.UID == deceased {
+			return Succession{}, fmt.Errorf("DeclareHeirs_Estate >> %s can not be an heir of itself", deceased)
+		}
+
+		exists, err5 := repo.UserExists(heir.UID)
+		if err5 != nil {
+			return Succession{}, fmt.Errorf("DeclareHeirs_Estate >> %s", err5.Error())
+		} else if !exists {
+			return Succession{}, fmt.Errorf("DeclareHeirs_Estate >> User %s does not exist", heir.UID)
+		}
+	}
+
+	config, err6 := repo.GetConfig()
+	if err6 != nil {
+		return Succession{}, fmt.Errorf("DeclareHeirs_Estate >> %s", err6.Error())
+	}
+
+	temp_dateTime, err7 := getTxDateTime(ctx)
+	if err7 != nil {
+		return Succession{}, fmt.Errorf("DeclareHeirs_Estate >> %s", err7.Error())
+	}
+
+	//=====================================
+
+	data := Succession{
+		ID:              ctx.GetStub().GetTxID(),
+		Deceased:        deceased,
+		Heirs:           heirs,
+		OfficeCode:      estate.OfficeCode,
+		DeclaredBy:      caller.EnrollmentID,
+		DeclaredOn:      temp_dateTime,
+		ObjectionsUntil: temp_dateTime.AddDate(0, 0, config.ObjectionDays),
+		Objections:      []Objection{},
+		Status:          SuccessionStatus_Pending,
+	}
+
+	estate.Succession = data.ID
+
+	err8 := repo.PutSuccession(serveyNo, &data)
+	if err8 != nil {
+		return Succession{}, fmt.Errorf("DeclareHeirs_Estate >> %s", err8.Error())
+	}
+
+	err9 := repo.PutEstate(serveyNo, estate)
+	if err9 != nil {
+		return Succession{}, fmt.Errorf("DeclareHeirs_Estate >> %s", err9.Error())
+	}
+
+	addEvent(ctx, EventType_HeirsDeclared, Succession_Event{
+		ServeyNo:   serveyNo,
+		Succession: data,
+	})
+
+	return data, nil
+}
+
+// claim or objection against the pending succession of the estate, during
+// the objection period
+func (s *SmartContract) Object_Succession(ctx contractapi.TransactionContextInterface, serveyNo string, reason string) (Succession, error) {
+
+	_, uid, err0 := s.authorizeUser(ctx, "Object_Succession")
+	if err0 != nil {
+		return Succession{}, err0
+	}
+
+	if reason == "" {
+		return Succession{}, fmt.Errorf("Object_Succession >> reason is required")
+	}
+
+	_, succession, err1 := s.getPendingSuccession(ctx, "Object_Succession", serveyNo)
+	if err1 != nil {
+		return Succession{}, err1
+	}
+
+	temp_dateTime, err2 := getTxDateTime(ctx)
+	if err2 != nil {
+		return Succession{}, fmt.Errorf("Object_Succession >> %s", err2.Error())
+	}
+
+	if !temp_dateTime.Before(succession.ObjectionsUntil) {
+		return Succession{}, &Validation_Error{
+			Code:     ValidationCode_Expired,
+			Function: "Object_Succession",
+			ServeyNo: serveyNo,
+			Message:  fmt.Sprintf("Objection period ended on %s", succession.ObjectionsUntil.Format(time.RFC3339)),
+		}
+	}
+
+	if searchObjection(succession.Objections, uid) != -1 {
+		return Succession{}, fmt.Errorf("Object_Succession >> %s already objected, the objection is not dismissed yet", uid)
+	}
+
+	succession.Objections = append(succession.Objections, Objection{
+		Objector: uid,
+		Reason:   reason,
+		FiledOn:  temp_dateTime,
+	})
+
+	err3 := newRepository(ctx).PutSuccession(serveyNo, succession)
+	if err3 != nil {
+		return Succession{}, fmt.Errorf("Object_Succession >> %s", err3.Error())
+	}
+
+	addEvent(ctx, EventType_SuccessionObjected, Succession_Event{
+		ServeyNo:   serveyNo,
+		Succession: *succession,
+	})
+
+	return *succession, nil
+}
+
+// DismissObjection_Succession, ApproveSuccession_Estate and
+// RejectSuccession_Estate, like ApproveSell_Estate, fail and log attempts by
+// the sub-registrar of another office
+
+func (s *SmartContract) DismissObjection_Succession(ctx contractapi.TransactionContextInterface, serveyNo string, objector string) (Succession, error) {
+
+	estate, succession, err0 := s.getPendingSuccession(ctx, "DismissObjection_Succession", serveyNo)
+	if err0 != nil {
+		return Succession{}, err0
+	}
+
+	_, err1 := s.authorizeSubRegistrar(ctx, "DismissObjection_Succession", estate.OfficeCode)
+	if err1 != nil {
+		return Succession{}, s.recordViolation(ctx, err1, serveyNo, estate.OfficeCode)
+	}
+
+	i := searchObjection(succession.Objections, objector)
+	if i == -1 {
+		return Succession{}, &Validation_Error{
+			Code:     ValidationCode_MissingEntry,
+			Function: "DismissObjection_Succession",
+			ServeyNo: serveyNo,
+			Message:  fmt.Sprintf("No objection of %s to dismiss", objector),
+		}
+	}
+
+	temp_dateTime, err2 := getTxDateTime(ctx)
+	if err2 != nil {
+		return Succession{}, fmt.Errorf("DismissObjection_Succession >> %s", err2.Error())
+	}
+
+	succession.Objections[i].DismissedOn = temp_dateTime
+
+	err3 := newRepository(ctx).PutSuccession(serveyNo, succession)
+	if err3 != nil {
+		return Succession{}, fmt.Errorf("DismissObjection_Succession >> %s", err3.Error())
+	}
+
+	addEvent(ctx, EventType_ObjectionDismissed, Succession_Event{
+		ServeyNo:   serveyNo,
+		Succession: *succession,
+	})
+
+	return *succession, nil
+}
+
+func (s *SmartContract) ApproveSuccession_Estate(ctx contractapi.TransactionContextInterface, serveyNo string) (Estate, error) {
+
+	repo := newRepository(ctx)
+
+	estate, succession, err0 := s.getPendingSuccession(ctx, "ApproveSuccession_Estate", serveyNo)
+	if err0 != nil {
+		return Estate{}, err0
+	}
+
+	_, err1 := s.authorizeSubRegistrar(ctx, "ApproveSuccession_Estate", estate.OfficeCode)
+	if err1 != nil {
+		return Estate{}, s.recordViolation(ctx, err1, serveyNo, estate.OfficeCode)
+	}
+
+	//=====================================
+
+	err2 := transitionEstate(ctx, "ApproveSuccession_Estate", serveyNo, estate, Action_ApproveSuccession)
+	if err2 != nil {
+		return Estate{}, err2
+	}
+
+	temp_dateTime, err3 := getTxDateTime(ctx)
+	if err3 != nil {
+		return Estate{}, fmt.Errorf("ApproveSuccession_Estate >> %s", err3.Error())
+	}
+
+	if temp_dateTime.Before(succession.ObjectionsUntil) {
+		return Estate{}, &Validation_Error{
+			Code:     ValidationCode_Objected,
+			Function: "ApproveSuccession_Estate",
+			ServeyNo: serveyNo,
+			Message:  fmt.Sprintf("Objection period ends on %s", succession.ObjectionsUntil.Format(time.RFC3339)),
+		}
+	}
+
+	for _, objection := range succession.Objections {
+		if objection.DismissedOn.IsZero() {
+			return Estate{}, &Validation_Error{
+				Code:     ValidationCode_Objected,
+				Function: "ApproveSuccession_Estate",
+				ServeyNo: serveyNo,
+				Message:  fmt.Sprintf("Objection of %s is not dismissed", objection.Objector),
+			}
+		}
+	}
+
+	share := ownerShare(estate, succession.Deceased)
+	if share == 0 {
+		return Estate{}, &Validation_Error{
+			Code:     ValidationCode_NotOwned,
+			Function: "ApproveSuccession_Estate",
+			ServeyNo: serveyNo,
+			Message:  fmt.Sprintf("Estate is not owned by %s", succession.Deceased),
+		}
+	}
+
+	inherited := inheritedShares(share, succession.Heirs)
+
+	owners := estateOwners(estate)
+	for _, heir := range inherited {
+		var err4 error
+		owners, err4 = transferShares(owners, []Share{{UID: succession.Deceased, Percent: heir.Percent}}, heir.UID)
+		if err4 != nil {
+			return Estate{}, fmt.Errorf("ApproveSuccession_Estate >> %s", err4.Error())
+		}
+	}
+
+	deceased, err5 := repo.GetUser(succession.Deceased)
+	if err5 != nil {
+		return Estate{}, fmt.Errorf("ApproveSuccession_Estate >> %s", err5.Error())
+	}
+
+	deceased.Owned, _ = removeString(deceased.Owned, serveyNo)
+
+	// heirs are verified
+	heirs := []*User{}
+
+	for _, heir := range inherited {
+		user, err6 := repo.GetUser(heir.UID)
+		if err6 != nil {
+			return Estate{}, fmt.Errorf("ApproveSuccession_Estate >> %s", err6.Error())
+		}
+
+		if user.Status != 1 {
+			return Estate{}, &Validation_Error{
+				Code:     ValidationCode_NotVerified,
+				Function: "ApproveSuccession_Estate",
+				ServeyNo: serveyNo,
+				Message:  fmt.Sprintf("Heir %s is not verified", heir.UID),
+			}
+		}
+
+		if searchArray(user.Owned, serveyNo) == -1 {
+			user.Owned = append(user.Owned, serveyNo)
+		}
+
+		heirs = append(heirs, user)
+	}
+
+	admin, err7 := repo.GetAdmin(estate.OfficeCode)
+	if err7 != nil {
+		return Estate{}, fmt.Errorf("ApproveSuccession_Estate >> %s", err7.Error())
+	}
+
+	//=====================================
+
+	num := estate.TransactionsCount + 1
+
+	transaction := Transaction{
+		Seller:              succession.Deceased,
+		Buyer:               "",
+		TransactionDateTime: succession.DeclaredOn,
+		OfficeCode:          estate.OfficeCode,
+		ApprovedBy:          admin.UID,
+		ApprovedDateTime:    temp_dateTime,
+		Price:               0,
+		PriceHash:           "",
+		Conditions:          "",
+		Reason:              "inheritance",
+		Shares:              []Share{{UID: succession.Deceased, Percent: share}},
+		Consents:            []string{},
+		Heirs:               inherited,
+	}
+
+	succession.Status = SuccessionStatus_Approved
+	succession.ApprovedBy = admin.UID
+	succession.ApprovedDateTime = temp_dateTime
+	succession.TransactionCount = num
+
+	estate.Owners = owners
+	estate.Owner = managingOwner(owners, estate.Owner)
+	estate.PurchasedOn = temp_dateTime
+	estate.TransactionsCount = num
+	estate.Succession = ""
+
+	err8 := repo.PutEstate(serveyNo, estate)
+	if err8 != nil {
+		return Estate{}, fmt.Errorf("ApproveSuccession_Estate >> %s", err8.Error())
+	}
+
+	err9 := repo.PutTransaction(serveyNo, num, &transaction)
+	if err9 != nil {
+		return Estate{}, fmt.Errorf("ApproveSuccession_Estate >> %s", err9.Error())
+	}
+
+	err10 := repo.PutSuccession(serveyNo, succession)
+	if err10 != nil {
+		return Estate{}, fmt.Errorf("ApproveSuccession_Estate >> %s", err10.Error())
+	}
+
+	err11 := repo.PutUser(succession.Deceased, deceased)
+	if err11 != nil {
+		return Estate{}, fmt.Errorf("ApproveSuccession_Estate >> %s", err11.Error())
+	}
+
+	for i, heir := range inherited {
+		err12 := repo.PutUser(heir.UID, heirs[i])
+		if err12 != nil {
+			return Estate{}, fmt.Errorf("ApproveSuccession_Estate >> %s", err12.Error())
+		}
+	}
+
+	addEvent(ctx, EventType_SuccessionApproved, Succession_Event{
+		ServeyNo:   serveyNo,
+		Succession: *succession,
+	})
+
+	return *estate, nil
+}
+
+// the estate is Verified again, heirs can be declared anew
+func (s *SmartContract) RejectSuccession_Estate(ctx contractapi.TransactionContextInterface, serveyNo string) (Estate, error) {
+
+	estate, succession, err0 := s.getPendingSuccession(ctx, "RejectSuccession_Estate", serveyNo)
+	if err0 != nil {
+		return Estate{}, err0
+	}
+
+	_, err1 := s.authorizeSubRegistrar(ctx, "RejectSuccession_Estate", estate.OfficeCode)
+	if err1 != nil {
+		return Estate{}, s.recordViolation(ctx, err1, serveyNo, estate.OfficeCode)
+	}
+
+	err2 := transitionEstate(ctx, "RejectSuccession_Estate", serveyNo, estate, Action_RejectSuccession)
+	if err2 != nil {
+		return Estate{}, err2
+	}
+
+	succession.Status = SuccessionStatus_Rejected
+	estate.Succession = ""
+
+	//=====================================
+
+	repo := newRepository(ctx)
+
+	err3 := repo.PutEstate(serveyNo, estate)
+	if err3 != nil {
+		return Estate{}, fmt.Errorf("RejectSuccession_Estate >> %s", err3.Error())
+	}
+
+	err4 := repo.PutSuccession(serveyNo, succession)
+	if err4 != nil {
+		return Estate{}, fmt.Errorf("RejectSuccession_Estate >> %s", err4.Error())
+	}
+
+	addEvent(ctx, EventType_SuccessionRejected, Succession_Event{
+		ServeyNo:   serveyNo,
+		Succession: *succession,
+	})
+
+	return *estate, nil
+}
+
+// ------------------------------------
+
+// Helper Functions - Private
+
+func (s *SmartContract) getPendingSuccession(ctx contractapi.TransactionContextInterface, fname string, serveyNo string) (*Estate, *Succession, error) {
+
+	repo := newRepository(ctx)
+
+	estate, err0 := repo.GetEstate(serveyNo)
+	if err0 != nil {
+		return nil, nil, fmt.Errorf("%s >> %s", fname, err0.Error())
+	}
+
+	if estate.Succession == "" {
+		return nil, nil, &Validation_Error{
+			Code:     ValidationCode_NotPending,
+			Function: fname,
+			ServeyNo: serveyNo,
+			Message:  "Estate has no pending succession",
+		}
+	}
+
+	succession, err1 := repo.GetSuccession(serveyNo, estate.Succession)
+	if err1 != nil {
+		return nil, nil, fmt.Errorf("%s >> %s", fname, err1.Error())
+	}
+
+	return estate, succession, nil
+}
+
+// objection of uid not dismissed yet, -1 if none
+func searchObjection(objections []Objection, uid string) int {
+	for i, objection := range objections {
+		if objection.Objector == uid && objection.DismissedOn.IsZero() {
+			return i
+		}
+	}
+	return -1
+}
+
+// percent of the estate each heir inherits from a share, what is lost to
+// rounding goes to the first heirs
+func inheritedShares(share int, heirs []Share) []Share {
+
+	result := []Share{}
+
+	left := share
+	for _, heir := range heirs {
+		percent := share * heir.Percent / 100
+		result = append(result, Share{UID: heir.UID, Percent: percent})
+		left -= percent
+	}
+
+	for i := 0; left > 0; i = (i + 1) % len(result) {
+		result[i].Percent++
+		left--
+	}
+
+	return result
+}
@@ -0,0 +1,57 @@
+package lib
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInheritedShares(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		share int
+		heirs []Share
+		want  []Share
+	}{
+		{
+			name:  "sole heir",
+			share: 60,
+			heirs: []Share{{UID: "h1", Percent: 100}},
+			want:  []Share{{UID: "h1", Percent: 60}},
+		},
+		{
+			name:  "even split",
+			share: 100,
+			heirs: []Share{{UID: "h1", Percent: 50}, {UID: "h2", Percent: 50}},
+			want:  []Share{{UID: "h1", Percent: 50}, {UID: "h2", Percent: 50}},
+		},
+		{
+			name:  "rounding to the first heirs",
+			share: 50,
+			heirs: []Share{{UID: "h1", Percent: 34}, {UID: "h2", Percent: 33}, {UID: "h3", Percent: 33}},
+			want:  []Share{{UID: "h1", Percent: 18}, {UID: "h2", Percent: 16}, {UID: "h3", Percent: 16}},
+		},
+		{
+			name:  "small share",
+			share: 1,
+			heirs: []Share{{UID: "h1", Percent: 50}, {UID: "h2", Percent: 50}},
+			want:  []Share{{UID: "h1", Percent: 1}, {UID: "h2", Percent: 0}},
+		},
+	}
+
+	for _, test := range tests {
+		got := inheritedShares(test.share, test.heirs)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+
+		// nothing of the share is lost
+		total := 0
+		for _, share := range got {
+			total += share.Percent
+		}
+		if total != test.share {
+			t.Errorf("%s: heirs inherit %d%% of %d%%", test.name, total, test.share)
+		}
+	}
+}
@@ -1,7 +1,6 @@
 package lib
 
 import (
-	"encoding/json"
 	"fmt"
 
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
@@ -9,107 +8,221 @@ import (
 
 // For System
 
-func (s *SmartContract) Create_User(ctx contractapi.TransactionContextInterface, uid string, name string) (User, error) {
+// name is read from the transient map, see privacy.go, and kept by the
+// registering office officeCode
+func (s *SmartContract) Create_User(ctx contractapi.TransactionContextInterface, uid string, officeCode string) (User, error) {
 
-	key := "user" + "_" + uid
+	_, err3 := s.authorizeAdmin(ctx, "Create_User", officeCode)
+	if err3 != nil {
+		return User{}, err3
+	}
+
+	if officeCode == "" {
+		return User{}, fmt.Errorf("Create_User >> officeCode is required")
+	}
+
+	private, err5 := getPrivate(ctx, "Create_User")
+	if err5 != nil {
+		return User{}, err5
+	}
+
+	if private.Name == "" {
+		return User{}, fmt.Errorf("Create_User >> name is required")
+	}
+
+	credentials, err2 := getCredentials(ctx, "Create_User")
+	if err2 != nil {
+		return User{}, err2
+	}
+
+	if len(credentials.NewPassword) < minPasswordLength {
+		return User{}, fmt.Errorf("Create_User >> New password must have at least %d characters", minPasswordLength)
+	}
+
+	repo := newRepository(ctx)
+
+	exists, err4 := repo.UserExists(uid)
+	if err4 != nil {
+		return User{}, fmt.Errorf("Create_User >> %s", err4.Error())
+	} else if exists {
+		return User{}, fmt.Errorf("Create_User >> User with uid %s already exists", uid)
+	}
+
+	hashed, err0 := hashPassword(ctx, "user_"+uid, credentials.NewPassword)
+	if err0 != nil {
+		return User{}, fmt.Errorf("Create_User >> %s", err0.Error())
+	}
+
+	userKey, err6 := repo.UserKey(uid)
+	if err6 != nil {
+		return User{}, fmt.Errorf("Create_User >> %s", err6.Error())
+	}
+
+	// kept by the registering office
+	nameHash, err7 := s.putPrivateData(ctx, officeCode, userKey, Private_Name{Name: private.Name, Salt: private.Salt})
+	if err7 != nil {
+		return User{}, fmt.Errorf("Create_User >> %s", err7.Error())
+	}
+
+	// initial password, has to be changed on first login
 	data := User{
-		Password:  uid,
-		Name:      name,
-		UID:       uid,
-		Status:    0,
-		Owned:     []string{},
-		Requested: []Request_Buyer{},
+		Password:           hashed,
+		MustChangePassword: true,
+		NameHash:           nameHash,
+		OfficeCode:         officeCode,
+		UID:                uid,
+		Status:             0,
+		Owned:              []string{},
+		Requested:          []Request_Buyer{},
 	}
 
-	marshaled_data, _ := json.Marshal(data)
-	err1 := ctx.GetStub().PutState(key, marshaled_data)
+	err1 := repo.PutUser(uid, &data)
 	if err1 != nil {
-		return User{}, fmt.Errorf("Create_User >> Failed to put to world state. %s", err1.Error())
+		return User{}, fmt.Errorf("Create_User >> %s", err1.Error())
 	}
 	return data, nil
 }
 
-func (s *SmartContract) Verify_User(ctx contractapi.TransactionContextInterface, _username string, _password string, status int, newPassword string) error {
-	verified, err0 := s.verifyPassword(ctx, _username, _password)
+// fresh password, read from the transient map, for a user that lost it or
+// still holds the legacy cleartext uid. The identity bound to the user, if
+// any, is released, the user binds again with Migrate_Identity.
+func (s *SmartContract) ResetPassword_User(ctx contractapi.TransactionContextInterface, uid string) error {
+
+	repo := newRepository(ctx)
 
+	user, err2 := repo.GetUser(uid)
+	if err2 != nil {
+		return fmt.Errorf("ResetPassword_User >> %s", err2.Error())
+	}
+
+	// admins of the registering office, legacy users have none
+	_, err0 := s.authorizeAdmin(ctx, "ResetPassword_User", user.OfficeCode)
 	if err0 != nil {
-		return fmt.Errorf("verifyPassword >> Verify password %s", err0.Error())
-	} else if !verified {
-		return fmt.Errorf("Verify_User >> Password Missmatched for %s", _username)
+		return err0
+	}
+
+	credentials, err1 := getCredentials(ctx, "ResetPassword_User")
+	if err1 != nil {
+		return err1
+	}
+
+	if len(credentials.NewPassword) < minPasswordLength {
+		return fmt.Errorf("ResetPassword_User >> New password must have at least %d characters", minPasswordLength)
+	}
+
+	if user.Status == UserStatus_Deceased {
+		return fmt.Errorf("ResetPassword_User >> User %s is registered as deceased", uid)
+	}
+
+	hashed, err3 := hashPassword(ctx, "user_"+uid, credentials.NewPassword)
+	if err3 != nil {
+		return fmt.Errorf("ResetPassword_User >> %s", err3.Error())
 	}
 
 	//=====================================
 
-	key := _username
-	user := new(User)
+	if user.Identity.EnrollmentID != "" {
+		err4 := repo.DelIdentity(user.Identity)
+		if err4 != nil {
+			return fmt.Errorf("ResetPassword_User >> %s", err4.Error())
+		}
+	}
 
-	// get user data
-	dataAsBytes, err1 := ctx.GetStub().GetState(key)
+	// has to be changed on first login, like the initial password
+	user.Password = hashed
+	user.MustChangePassword = true
+	user.Identity = Identity{}
+
+	err5 := repo.PutUser(uid, user)
+	if err5 != nil {
+		return fmt.Errorf("ResetPassword_User >> %s", err5.Error())
+	}
+
+	return nil
+}
+
+func (s *SmartContract) Verify_User(ctx contractapi.TransactionContextInterface, uid string, status int) error {
+
+	repo := newRepository(ctx)
 
+	// get user data
+	user, err1 := repo.GetUser(uid)
 	if err1 != nil {
-		return fmt.Errorf("Verify_User >> Failed to read from world state. %s", err1.Error())
+		return fmt.Errorf("Verify_User >> %s", err1.Error())
 	}
 
-	if dataAsBytes == nil {
-		return fmt.Errorf("Verify_User >> %s does not exist", key)
+	// admins of the registering office, legacy users have none
+	_, err0 := s.authorizeAdmin(ctx, "Verify_User", user.OfficeCode)
+	if err0 != nil {
+		return err0
+	}
+
+	//=====================================
+
+	// only RegisterDeath_User marks users deceased, for good
+	if user.Status == UserStatus_Deceased {
+		return fmt.Errorf("Verify_User >> Status of %s can not be changed to or from deceased", uid)
 	}
 
-	err2 := json.Unmarshal(dataAsBytes, &user)
+	err2 := checkUserStatus("Verify_User", uid, status)
 	if err2 != nil {
-		return fmt.Errorf("Verify_User >> Can't Unmarshal Data")
+		return err2
 	}
 
 	//=====================================
 
 	user.Status = status
-	user.Password = newPassword
 
-	marshaled_data, _ := json.Marshal(user)
-	err3 := ctx.GetStub().PutState(key, marshaled_data)
+	err3 := repo.PutUser(uid, user)
 	if err3 != nil {
-		return fmt.Errorf("Verify_User >> Failed to put to world state. %s", err3.Error())
+		return fmt.Errorf("Verify_User >> %s", err3.Error())
+	}
+
+	if status == 1 {
+		addEvent(ctx, EventType_UserVerified, Status_Event{Key: uid, Status: status})
 	}
 
 	return nil
 }
 
-func (s *SmartContract) Verify_Estate(ctx contractapi.TransactionContextInterface, _username string, _password string, serveyNo string, status int) error {
-	verified, err0 := s.verifyPassword(ctx, _username, _password)
+func (s *SmartContract) Verify_Estate(ctx contractapi.TransactionContextInterface, serveyNo string, status int) error {
 
-	if err0 != nil {
-		return fmt.Errorf("verifyPassword >> Verify password %s", err0.Error())
-	} else if !verified {
-		return fmt.Errorf("Verify_Estate >> Password Missmatched for %s", _username)
-	}
-
-	//=====================================
+	repo := newRepository(ctx)
 
 	// get data
-	key := "estate" + "_" + serveyNo
-	dataAsBytes, err1 := ctx.GetStub().GetState(key)
-
+	estate, err1 := repo.GetEstate(serveyNo)
 	if err1 != nil {
-		return fmt.Errorf("Verify_Estate >> Failed to read from world state. %s", err1.Error())
-	}
-
-	if dataAsBytes == nil {
-		return fmt.Errorf("Verify_Estate >> %s does not exist", key)
+		return fmt.Errorf("Verify_Estate >> %s", err1.Error())
 	}
 
-	estate := new(Estate)
-	err2 := json.Unmarshal(dataAsBytes, &estate)
-	if err2 != nil {
-		return fmt.Errorf("GetValue >> Can't Unmarshal Data")
+	// only admins of the office where estate resides
+	_, err0 := s.authorizeAdmin(ctx, "Verify_Estate", estate.OfficeCode)
+	if err0 != nil {
+		return err0
 	}
 
 	//=====================================
 
-	estate.Status = status
+	// 0/1/2 - Not verified/Verified/Suspended
+	actions := []string{Action_Unverify, Action_Verify, Action_Freeze}
+	if status < 0 || status >= len(actions) {
+		return fmt.Errorf("Verify_Estate >> status must be 0, 1 or 2")
+	}
+
+	err2 := transitionEstate(ctx, "Verify_Estate", serveyNo, estate, actions[status])
+	if err2 != nil {
+		return err2
+	}
 
-	marshaled_data, _ := json.Marshal(estate)
-	err3 := ctx.GetStub().PutState(key, marshaled_data)
+	err3 := repo.PutEstate(serveyNo, estate)
 	if err3 != nil {
-		return fmt.Errorf("Verify_Estate >> Failed to put to world state. %s", err3.Error())
+		return fmt.Errorf("Verify_Estate >> %s", err3.Error())
+	}
+
+	if status == 1 {
+		addEvent(ctx, EventType_EstateVerified, Status_Event{Key: serveyNo, Status: status})
+	} else if status == 2 {
+		addEvent(ctx, EventType_EstateSuspended, Status_Event{Key: serveyNo, Status: status})
 	}
 
 	return nil
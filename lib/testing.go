@@ -1,15 +1,89 @@
+//go:build debug
+
 package lib
 
 import (
 	"encoding/json"
 	"fmt"
+	"log"
+	"strings"
+	"time"
 
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 )
 
 // Testing
+//
+// Raw access to the world state, only compiled in with "go build -tags debug"
+// and registered as a separate contract, DebugContract:GetValue etc.
+// Every call is restricted to the super admin and audited: the call is logged
+// by the peer before it runs and emits a debugAudit sub-event once committed.
+// A failed transaction writes nothing, DeleteValue and DeleteAll log their
+// failures too, so the peer log holds calls that never reached the ledger.
+
+type DebugContract struct {
+	contractapi.Contract
+	registry SmartContract
+}
+
+type Debug_Audit struct {
+	Function string    `json:"function"`
+	Args     []string  `json:"args"`
+	Caller   string    `json:"caller"` // enrollment id
+	DateTime time.Time `json:"dateTime"`
+}
+
+func init() {
+	extraContracts = append(extraContracts, new(DebugContract))
+}
+
+// contractapi hooks, not transactions themselves
+
+func (d *DebugContract) GetBeforeTransaction() interface{} {
+	return d.beforeTransaction
+}
+
+func (d *DebugContract) GetTransactionContextHandler() contractapi.SettableTransactionContextInterface {
+	return new(TransactionContext)
+}
+
+func (d *DebugContract) GetAfterTransaction() interface{} {
+	return flushEvents
+}
+
+func (d *DebugContract) beforeTransaction(ctx contractapi.TransactionContextInterface) error {
+
+	fname, params := ctx.GetStub().GetFunctionAndParameters()
+	fname = fname[strings.LastIndex(fname, ":")+1:]
+
+	caller, err0 := d.registry.authorizeSuperAdmin(ctx, fname)
+	if err0 != nil {
+		return err0
+	}
 
-func (s *SmartContract) GetValue(ctx contractapi.TransactionContextInterface, _key string) (map[string]interface{}, error) {
+	txDateTime, err1 := getTxDateTime(ctx)
+	if err1 != nil {
+		return fmt.Errorf("%s >> %s", fname, err1.Error())
+	}
+
+	data := Debug_Audit{
+		Function: fname,
+		Args:     params,
+		Caller:   caller.EnrollmentID,
+		DateTime: txDateTime,
+	}
+
+	addEvent(ctx, EventType_DebugAudit, data)
+
+	marshaled_data, _ := json.Marshal(data)
+	log.Printf("Debug call in transaction %s: %s", ctx.GetStub().GetTxID(), string(marshaled_data))
+
+	return nil
+}
+
+// ------------------------------------
+
+func (d *DebugContract) GetValue(ctx contractapi.TransactionContextInterface, _key string) (map[string]interface{}, error) {
 
 	// this is to handle the data from unknown/misc structs
 	data := make(map[string]interface{})
@@ -29,26 +103,20 @@ func (s *SmartContract) GetValue(ctx contractapi.TransactionContextInterface, _k
 		return data, fmt.Errorf("GetValue >> Can't Unmarshal Data")
 	}
 
-	fmt.Println(data)
-	fmt.Print("\n")
-
 	return data, nil
 }
 
-func (s *SmartContract) DeleteValue(ctx contractapi.TransactionContextInterface, _key string) error {
+func (d *DebugContract) DeleteValue(ctx contractapi.TransactionContextInterface, _key string) error {
 
 	err0 := ctx.GetStub().DelState(_key)
 	if err0 != nil {
-		return fmt.Errorf("DeleteValue >> Can't Delete Value for %s. %s", _key, err0.Error())
+		return auditFailure(ctx, fmt.Errorf("DeleteValue >> Can't Delete Value for %s. %s", _key, err0.Error()))
 	}
 
-	fmt.Println("Deleted value for key:", _key)
-	fmt.Print("\n")
-
 	return nil
 }
 
-func (s *SmartContract) GetAll(ctx contractapi.TransactionContextInterface, startKey string, endKey string) ([]string, error) {
+func (d *DebugContract) GetAll(ctx contractapi.TransactionContextInterface, startKey string, endKey string) ([]string, error) {
 
 	resultsIterator, err0 := ctx.GetStub().GetStateByRange(startKey, endKey)
 
@@ -74,24 +142,18 @@ func (s *SmartContract) GetAll(ctx contractapi.TransactionContextInterface, star
 
 		data := string(queryResponse.Value)
 
-		fmt.Println("=====================================")
-		fmt.Println("Key: "+queryResponse.Key+", Value: ", data)
-		fmt.Println("=====================================")
-
 		arrMap = append(arrMap, "Key: "+string(queryResponse.Key)+", Value: "+data)
 	}
 
-	fmt.Print("\n")
-
 	return arrMap, nil
 }
 
-func (s *SmartContract) DeleteAll(ctx contractapi.TransactionContextInterface, startKey string, endKey string) error {
+func (d *DebugContract) DeleteAll(ctx contractapi.TransactionContextInterface, startKey string, endKey string) error {
 
 	resultsIterator, err0 := ctx.GetStub().GetStateByRange(startKey, endKey)
 
 	if err0 != nil {
-		return err0
+		return auditFailure(ctx, fmt.Errorf("DeleteAll >> %s", err0.Error()))
 	}
 	defer resultsIterator.Close()
 
@@ -99,7 +161,7 @@ func (s *SmartContract) DeleteAll(ctx contractapi.TransactionContextInterface, s
 		queryResponse, err1 := resultsIterator.Next()
 
 		if err1 != nil {
-			return err1
+			return auditFailure(ctx, fmt.Errorf("DeleteAll >> %s", err1.Error()))
 		}
 
 		key := queryResponse.Key
@@ -107,11 +169,53 @@ func (s *SmartContract) DeleteAll(ctx contractapi.TransactionContextInterface, s
 		err2 := ctx.GetStub().DelState(key)
 
 		if err2 != nil {
-			return err2
+			return auditFailure(ctx, fmt.Errorf("DeleteAll >> Can't Delete Value for %s. %s", key, err2.Error()))
 		}
 	}
 
-	fmt.Print("Deleted all/multple values")
-
 	return nil
 }
+
+// composite keys of one record type, e.g. "estate", see repository.go
+func (d *DebugContract) GetAllByType(ctx contractapi.TransactionContextInterface, objectType string) ([]string, error) {
+
+	resultsIterator, err0 := ctx.GetStub().GetStateByPartialCompositeKey(objectType, []string{})
+
+	arrMap := []string{}
+
+	if err0 != nil {
+		return arrMap, err0
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err1 := resultsIterator.Next()
+
+		if err1 != nil {
+			return arrMap, err1
+		}
+
+		_, attributes, err2 := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err2 != nil {
+			return arrMap, err2
+		}
+
+		data := string(queryResponse.Value)
+
+		arrMap = append(arrMap, "Key: "+objectType+"/"+strings.Join(attributes, "/")+", Value: "+data)
+	}
+
+	return arrMap, nil
+}
+
+// ------------------------------------
+
+// Helper Functions - Private
+
+// the after-hook only runs for calls that succeed, failures are logged here
+func auditFailure(ctx contractapi.TransactionContextInterface, err error) error {
+
+	log.Printf("Debug call in transaction %s failed: %s", ctx.GetStub().GetTxID(), err.Error())
+
+	return err
+}
@@ -0,0 +1,153 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Transient
+//
+// Secrets are never passed as positional arguments, those end up in the
+// proposal and in every block. They are read from the transient map of the
+// proposal instead, under the key "credentials", as JSON:
+//
+//	{"username": "user_<uid>", "password": "...", "newPassword": "..."}
+//
+// username/password - credentials of the caller (Migrate_Identity, ChangePassword)
+// newPassword       - password to set (InitLedger, ChangePassword, CreateOrModify_Admin, Create_User, ResetPassword_User)
+//
+// Sealed bids are read from the key "bid" (CommitBid_Estate):
+//
+//	{"price": 1000, "salt": "..."}
+//
+// Prices and names kept in private data are read from the key "private":
+//
+//	{"price": 1000, "name": "...", "rent": 100, "deposit": 500, "salt": "..."}
+//
+// price        - RequestToBuy_Estate, CounterOffer_Estate, TransferShare_Estate, Add_Transaction, VerifyTransactionPrice
+// name         - Create_User, Modify_User, VerifyUserName
+// rent/deposit - RegisterLease_Estate, RenewLease_Estate
+// salt         - required, hides the value behind its hash in public state, kept with the value
+
+const (
+	transientKey_Credentials = "credentials"
+	transientKey_Bid         = "bid"
+	transientKey_Private     = "private"
+)
+
+type Transient_Credentials struct {
+	Username    string `json:"username"`
+	Password    string `json:"password"`
+	NewPassword string `json:"newPassword"`
+}
+
+type Transient_Private struct {
+	Price   int    `json:"price"`
+	Name    string `json:"name"`
+	Rent    int    `json:"rent"`
+	Deposit int    `json:"deposit"`
+	Salt    string `json:"salt"`
+}
+
+// number of positional arguments of functions that take secrets,
+// calls with more arguments are trying to pass a secret positionally
+var credentialFunctions = map[string]int{
+	"InitLedger":           0,
+	"Migrate_Identity":     0,
+	"ChangePassword":       0,
+	"CreateOrModify_Admin": 3,
+	"Create_User":          2,
+	"ResetPassword_User":   1,
+	"Modify_User":          2,
+	"Add_Transaction":      9,
+	"RequestToBuy_Estate":  2,
+	"CounterOffer_Estate":  3,
+	"CommitBid_Estate":     1,
+	"TransferShare_Estate": 4,
+	"RegisterLease_Estate": 5,
+	"RenewLease_Estate":    3,
+}
+
+// ------------------------------------
+
+// Helper Functions - Private
+
+func checkPositionalSecrets(fname string, params []string) error {
+
+	argsCount, ok := credentialFunctions[fname]
+	if ok && len(params) > argsCount {
+		return fmt.Errorf("%s >> Secrets must be passed in the transient map, not as arguments", fname)
+	}
+
+	return nil
+}
+
+func getCredentials(ctx contractapi.TransactionContextInterface, fname string) (Transient_Credentials, error) {
+
+	transientMap, err0 := ctx.GetStub().GetTransient()
+	if err0 != nil {
+		return Transient_Credentials{}, fmt.Errorf("%s >> Failed to read transient map. %s", fname, err0.Error())
+	}
+
+	dataAsBytes, ok := transientMap[transientKey_Credentials]
+	if !ok {
+		return Transient_Credentials{}, fmt.Errorf("%s >> %s must be passed in the transient map", fname, transientKey_Credentials)
+	}
+
+	credentials := Transient_Credentials{}
+	err1 := json.Unmarshal(dataAsBytes, &credentials)
+	if err1 != nil {
+		return Transient_Credentials{}, fmt.Errorf("%s >> Can't Unmarshal %s", fname, transientKey_Credentials)
+	}
+
+	return credentials, nil
+}
+
+func getBid(ctx contractapi.TransactionContextInterface, fname string) (Private_Bid, error) {
+
+	transientMap, err0 := ctx.GetStub().GetTransient()
+	if err0 != nil {
+		return Private_Bid{}, fmt.Errorf("%s >> Failed to read transient map. %s", fname, err0.Error())
+	}
+
+	dataAsBytes, ok := transientMap[transientKey_Bid]
+	if !ok {
+		return Private_Bid{}, fmt.Errorf("%s >> %s must be passed in the transient map", fname, transientKey_Bid)
+	}
+
+	bid := Private_Bid{}
+	err1 := json.Unmarshal(dataAsBytes, &bid)
+	if err1 != nil {
+		return Private_Bid{}, fmt.Errorf("%s >> Can't Unmarshal %s", fname, transientKey_Bid)
+	}
+
+	return bid, nil
+}
+
+func getPrivate(ctx contractapi.TransactionContextInterface, fname string) (Transient_Private, error) {
+
+	transientMap, err0 := ctx.GetStub().GetTransient()
+	if err0 != nil {
+		return Transient_Private{}, fmt.Errorf("%s >> Failed to read transient map. %s", fname, err0.Error())
+	}
+
+	dataAsBytes, ok := transientMap[transientKey_Private]
+	if !ok {
+		return Transient_Private{}, fmt.Errorf("%s >> %s must be passed in the transient map", fname, transientKey_Private)
+	}
+
+	private := Transient_Private{}
+	err1 := json.Unmarshal(dataAsBytes, &private)
+	if err1 != nil {
+		return Transient_Private{}, fmt.Errorf("%s >> Can't Unmarshal %s", fname, transientKey_Private)
+	}
+
+	// without a salt, hashes of small prices are reversed by trying them all
+	if private.Salt == "" {
+		return Transient_Private{}, fmt.Errorf("%s >> %s must have a salt", fname, transientKey_Private)
+	}
+
+	return private, nil
+}
@@ -1,10 +1,7 @@
 package lib
 
 import (
-	"encoding/json"
 	"fmt"
-	"strconv"
-	"strings"
 	"time"
 
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
@@ -13,73 +10,85 @@ import (
 // User
 
 func (s *SmartContract) ChangeAvail_Estate(ctx contractapi.TransactionContextInterface, serveyNo string, saleAvailability bool) error {
-	// get data
-	key := "estate" + "_" + serveyNo
-	dataAsBytes, err1 := ctx.GetStub().GetState(key)
 
-	if err1 != nil {
-		return fmt.Errorf("ChangeAvail_Estate >> Failed to read from world state. %s", err1.Error())
-	}
+	repo := newRepository(ctx)
 
-	if dataAsBytes == nil {
-		return fmt.Errorf("ChangeAvail_Estate >> %s does not exist", key)
+	// get data
+	estate, err1 := repo.GetEstate(serveyNo)
+	if err1 != nil {
+		return fmt.Errorf("ChangeAvail_Estate >> %s", err1.Error())
 	}
 
-	estate := new(Estate)
-	err2 := json.Unmarshal(dataAsBytes, &estate)
-	if err2 != nil {
-		return fmt.Errorf("GetValue >> Can't Unmarshal Data")
+	_, _, err0 := s.authorizeOwner(ctx, "ChangeAvail_Estate", estate)
+	if err0 != nil {
+		return err0
 	}
 
 	//=====================================
 
-	estate.SaleAvailability = saleAvailability
+	action := Action_Delist
+	if saleAvailability {
+		action = Action_List
+	}
+
+	err2 := transitionEstate(ctx, "ChangeAvail_Estate", serveyNo, estate, action)
+	if err2 != nil {
+		return err2
+	}
 
-	marshaled_data, _ := json.Marshal(estate)
-	err3 := ctx.GetStub().PutState(key, marshaled_data)
+	err3 := repo.PutEstate(serveyNo, estate)
 	if err3 != nil {
-		return fmt.Errorf("ChangeAvail_Estate >> Failed to put to world state. %s", err3.Error())
+		return fmt.Errorf("ChangeAvail_Estate >> %s", err3.Error())
 	}
 
 	return nil
 }
 
-func (s *SmartContract) RequestToBuy_Estate(ctx contractapi.TransactionContextInterface, _buyer string, _name string, serveyNo string, proposedPrice int, dateTime string) (Request, error) {
-	// get data estate
-	key := "estate" + "_" + serveyNo
-	dataAsBytes, err1 := ctx.GetStub().GetState(key)
+// validDays is the number of days the request is valid for, 0 if it does not
+// expire, an update renews it. Price is read from the transient map, see
+// privacy.go
+func (s *SmartContract) RequestToBuy_Estate(ctx contractapi.TransactionContextInterface, serveyNo string, validDays int) (Request, error) {
+	_, _buyer, err0 := s.authorizeUser(ctx, "RequestToBuy_Estate")
+	if err0 != nil {
+		return Request{}, err0
+	}
 
-	if err1 != nil {
-		return Request{}, fmt.Errorf("RequestToBuy_Estate >> Failed to read from world state. %s", err1.Error())
+	private, err9 := getPrivate(ctx, "RequestToBuy_Estate")
+	if err9 != nil {
+		return Request{}, err9
 	}
 
-	if dataAsBytes == nil {
-		return Request{}, fmt.Errorf("RequestToBuy_Estate >> %s does not exist", serveyNo)
+	if private.Price <= 0 {
+		return Request{}, fmt.Errorf("RequestToBuy_Estate >> price must be greater than 0")
 	}
 
-	estate := new(Estate)
-	err2 := json.Unmarshal(dataAsBytes, &estate)
-	if err2 != nil {
-		return Request{}, fmt.Errorf("RequestToBuy_Estate >> Can't Unmarshal Data")
+	if validDays < 0 {
+		return Request{}, fmt.Errorf("RequestToBuy_Estate >> validDays can not be negative")
 	}
 
-	// get data buyer
+	repo := newRepository(ctx)
 
-	key2 := "user" + "_" + _buyer
-	dataAsBytes2, err3 := ctx.GetStub().GetState(key2)
+	// get data estate
+	estate, err1 := repo.GetEstate(serveyNo)
+	if err1 != nil {
+		return Request{}, fmt.Errorf("RequestToBuy_Estate >> %s", err1.Error())
+	}
 
-	if err3 != nil {
-		return Request{}, fmt.Errorf("RequestToBuy_Estate >> Failed to read from world state. %s", err3.Error())
+	if isOwner(estate, _buyer) {
+		return Request{}, fmt.Errorf("RequestToBuy_Estate >> Estate is already owned by %s", _buyer)
 	}
 
-	if dataAsBytes2 == nil {
-		return Request{}, fmt.Errorf("RequestToBuy_Estate >> %s does not exist", key2)
+	// only listed estates take requests
+	err2 := transitionEstate(ctx, "RequestToBuy_Estate", serveyNo, estate, Action_Request)
+	if err2 != nil {
+		return Request{}, err2
 	}
 
-	buyer := new(User)
-	err4 := json.Unmarshal(dataAsBytes2, &buyer)
-	if err4 != nil {
-		return Request{}, fmt.Errorf("RequestToBuy_Estate >> Can't Unmarshal Data")
+	// get data buyer
+
+	buyer, err3 := repo.GetUser(_buyer)
+	if err3 != nil {
+		return Request{}, fmt.Errorf("RequestToBuy_Estate >> %s", err3.Error())
 	}
 
 	//=====================================
@@ -87,29 +96,42 @@ func (s *SmartContract) RequestToBuy_Estate(ctx contractapi.TransactionContextIn
 	// add or update request in estate array
 
 	temp_requests := estate.Requests
-	temp_dateTime, _ := time.Parse(time.RFC3339, dateTime)
-
-	flag := false
-	index := -1
-	for i, r := range temp_requests {
-		if r.Buyer == _buyer {
-			temp_requests[i].ProposedPrice = proposedPrice
-			temp_requests[i].DateTime = temp_dateTime
-			flag = true
-			index = i
-			break
-		}
+	temp_dateTime, err8 := getTxDateTime(ctx)
+	if err8 != nil {
+		return Request{}, fmt.Errorf("RequestToBuy_Estate >> %s", err8.Error())
 	}
 
+	index := searchRequest(temp_requests, _buyer)
+	flag := index != -1
+
 	if !flag {
-		temp_requests = append(temp_requests, Request{
-			Buyer:         _buyer,
-			Name:          _name,
-			ProposedPrice: proposedPrice,
-			DateTime:      temp_dateTime,
-		})
+		temp_requests = append(temp_requests, Request{})
 		index = len(temp_requests) - 1
 	}
+
+	// negotiation of an expired request is not continued
+	if !flag || isExpired(temp_requests[index].ExpiresOn, temp_dateTime) {
+		temp_requests[index] = Request{
+			Buyer: _buyer,
+			ID:    ctx.GetStub().GetTxID(),
+		}
+	}
+
+	// whole seconds in UTC, so the RFC 3339 strings CouchDB compares in
+	// PurgeExpiredRequests have one width and sort in time order
+	temp_expiresOn := time.Time{}
+	if validDays > 0 {
+		temp_expiresOn = temp_dateTime.AddDate(0, 0, validDays).Truncate(time.Second)
+	}
+	temp_requests[index].ExpiresOn = temp_expiresOn
+
+	// every offer of the buyer is a round of the negotiation, conditions of
+	// the latest offer are kept
+	_, err7 := s.addOffer(ctx, serveyNo, estate, &temp_requests[index], OfferKind_Request, Party_Buyer, _buyer, Private_Price{Price: private.Price, Salt: private.Salt}, temp_requests[index].Conditions)
+	if err7 != nil {
+		return Request{}, fmt.Errorf("RequestToBuy_Estate >> %s", err7.Error())
+	}
+
 	estate.Requests = temp_requests
 
 	// add or update request in buyer requested array
@@ -119,8 +141,10 @@ func (s *SmartContract) RequestToBuy_Estate(ctx contractapi.TransactionContextIn
 	flag2 := false
 	for i2, r2 := range temp_requested {
 		if r2.ServeyNo == serveyNo {
-			temp_requested[i2].ProposedPrice = proposedPrice
+			temp_requested[i2].ProposedPrice = 0
+			temp_requested[i2].PriceHash = temp_requests[index].PriceHash
 			temp_requested[i2].DateTime = temp_dateTime
+			temp_requested[i2].ExpiresOn = temp_expiresOn
 			flag2 = true
 			break
 		}
@@ -128,9 +152,10 @@ func (s *SmartContract) RequestToBuy_Estate(ctx contractapi.TransactionContextIn
 
 	if !flag2 {
 		temp_requested = append(temp_requested, Request_Buyer{
-			ServeyNo:      serveyNo,
-			ProposedPrice: proposedPrice,
-			DateTime:      temp_dateTime,
+			ServeyNo:  serveyNo,
+			PriceHash: temp_requests[index].PriceHash,
+			DateTime:  temp_dateTime,
+			ExpiresOn: temp_expiresOn,
 		})
 	}
 	buyer.Requested = temp_requested
@@ -139,220 +164,168 @@ func (s *SmartContract) RequestToBuy_Estate(ctx contractapi.TransactionContextIn
 
 	// update estate requests array
 
-	marshaled_data, _ := json.Marshal(estate)
-	err5 := ctx.GetStub().PutState(key, marshaled_data)
+	err5 := repo.PutEstate(serveyNo, estate)
 	if err5 != nil {
-		return Request{}, fmt.Errorf("RequestToBuy_Estate >> Failed to put to world state. %s", err5.Error())
+		return Request{}, fmt.Errorf("RequestToBuy_Estate >> %s", err5.Error())
 	}
 
 	// update byer requested
 
-	marshaled_data2, _ := json.Marshal(buyer)
-	err6 := ctx.GetStub().PutState(key2, marshaled_data2)
+	err6 := repo.PutUser(_buyer, buyer)
 	if err6 != nil {
-		return Request{}, fmt.Errorf("RequestToBuy_Estate >> Failed to put to world state. %s", err6.Error())
+		return Request{}, fmt.Errorf("RequestToBuy_Estate >> %s", err6.Error())
 	}
 
-	return temp_requests[index], nil
-}
+	eventType := EventType_RequestCreated
+	if flag {
+		eventType = EventType_RequestUpdated
+	}
 
-func (s *SmartContract) AcceptRequest_Estate(ctx contractapi.TransactionContextInterface, _username string, _password string, serveyNo string, buyer string, dateTime string, reason string) (Transaction, error) {
-	verified, err0 := s.verifyPassword(ctx, _username, _password)
+	addEvent(ctx, eventType, Request_Event{
+		ServeyNo: serveyNo,
+		Request:  temp_requests[index],
+	})
 
-	if err0 != nil {
-		return Transaction{}, fmt.Errorf("verifyPassword >> Verify password %s", err0.Error())
-	} else if !verified {
-		return Transaction{}, fmt.Errorf("AcceptRequest_Estate >> Password Missmatched for %s", _username)
-	}
+	return temp_requests[index], nil
+}
 
+func (s *SmartContract) AcceptRequest_Estate(ctx contractapi.TransactionContextInterface, serveyNo string, buyer string, reason string) (Transaction, error) {
 	//=====================================
 
-	// get data
-	key1 := "estate" + "_" + serveyNo
-	dataAsBytes, err1 := ctx.GetStub().GetState(key1)
+	repo := newRepository(ctx)
 
+	// get data
+	estate, err1 := repo.GetEstate(serveyNo)
 	if err1 != nil {
-		return Transaction{}, fmt.Errorf("AcceptRequest_Estate >> Failed to read from world state. %s", err1.Error())
+		return Transaction{}, fmt.Errorf("AcceptRequest_Estate >> %s", err1.Error())
 	}
 
-	if dataAsBytes == nil {
-		return Transaction{}, fmt.Errorf("AcceptRequest_Estate >> %s does not exist", serveyNo)
+	// any co-owner can accept a request, it counts as consent to the sale
+	_, seller, err0 := s.authorizeOwner(ctx, "AcceptRequest_Estate", estate)
+	if err0 != nil {
+		return Transaction{}, err0
 	}
 
-	estate := new(Estate)
-	err2 := json.Unmarshal(dataAsBytes, &estate)
-	if err2 != nil {
-		return Transaction{}, fmt.Errorf("AcceptRequest_Estate >> Can't Unmarshal Data")
+	err15 := checkReason("AcceptRequest_Estate", reason)
+	if err15 != nil {
+		return Transaction{}, err15
 	}
 
 	//=====================================
 
-	// check if being sold already
-	if estate.BeingSold {
-		return Transaction{}, fmt.Errorf("AcceptRequest_Estate >> Estate is already being sold")
+	// stop from accepting other requests, it is updated in later step
+	err2 := transitionEstate(ctx, "AcceptRequest_Estate", serveyNo, estate, Action_Accept)
+	if err2 != nil {
+		return Transaction{}, err2
 	}
 
-	// stop from accepting other requests
-	estate.BeingSold = true
-
-	// it is updated in later step
-
 	//=====================================
 
-	flag := false
-	index := -1
-	for i, r := range estate.Requests {
-		if r.Buyer == buyer {
-			flag = true
-			index = i
-		}
-	}
-
-	if !flag {
+	index := searchRequest(estate.Requests, buyer)
+	if index == -1 {
 		return Transaction{}, fmt.Errorf("AcceptRequest_Estate >> No request found for given buyer: %s", buyer)
 	}
 
-	temp_dateTime, _ := time.Parse(time.RFC3339, dateTime)
-	temp_transaction := Transaction{
-		Seller:              strings.TrimPrefix(_username, "user_"),
-		Buyer:               estate.Requests[index].Buyer,
-		TransactionDateTime: temp_dateTime,
-		OfficeCode:          estate.OfficeCode,
-		ApprovedBy:          "",
-		ApprovedDateTime:    time.Time{},
-		Price:               estate.Requests[index].ProposedPrice,
-		Reason:              reason,
-	}
-
-	key2 := "transaction" + "_" + serveyNo + "_" + strconv.Itoa(estate.TransactionsCount+1)
-	marshaled_data1, _ := json.Marshal(temp_transaction)
-	err4 := ctx.GetStub().PutState(key2, marshaled_data1)
-	if err4 != nil {
-		return Transaction{}, fmt.Errorf("AcceptRequest_Estate >> failed to put to world state. %s", err4.Error())
-	}
-
-	// update being sold flag and delete all requests
-	estate.Requests = []Request{}
+	// latest offer of the buyer is agreed by accepting it, the seller's own
+	// counter-offer has to be accepted by the buyer first
+	request := &estate.Requests[index]
 
-	marshaled_data0, _ := json.Marshal(estate)
-	err3 := ctx.GetStub().PutState(key1, marshaled_data0)
-	if err3 != nil {
-		return Transaction{}, fmt.Errorf("AcceptRequest_Estate >> failed to put to world state. %s", err3.Error())
+	temp_dateTime, err10 := getTxDateTime(ctx)
+	if err10 != nil {
+		return Transaction{}, fmt.Errorf("AcceptRequest_Estate >> %s", err10.Error())
 	}
 
-	//=====================================
-	// add request in toApprove of admin
-
-	key3 := "admin" + "_" + estate.OfficeCode
-	dataAsBytes1, err4 := ctx.GetStub().GetState(key3)
-
-	if err4 != nil {
-		return Transaction{}, fmt.Errorf("AcceptRequest_Estate >> Failed to read from world state. %s", err4.Error())
+	err12 := checkExpiry("AcceptRequest_Estate", serveyNo, request, temp_dateTime)
+	if err12 != nil {
+		return Transaction{}, err12
 	}
 
-	if dataAsBytes1 == nil {
-		return Transaction{}, fmt.Errorf("AcceptRequest_Estate >> %s does not exist", key3)
+	// no sale of an encumbered estate without consent of the lienholder
+	err14 := checkLiens(ctx, "AcceptRequest_Estate", serveyNo, buyer)
+	if err14 != nil {
+		return Transaction{}, err14
 	}
 
-	admin := new(Admin_OfficeCode)
-	err5 := json.Unmarshal(dataAsBytes1, &admin)
-	if err5 != nil {
-		return Transaction{}, fmt.Errorf("AcceptRequest_Estate >> Can't Unmarshal Data")
-	}
-
-	admin.ToApprove = append(admin.ToApprove, key2)
-
-	marshaled_data2, _ := json.Marshal(admin)
-	err6 := ctx.GetStub().PutState(key3, marshaled_data2)
-	if err6 != nil {
-		return Transaction{}, fmt.Errorf("AcceptRequest_Estate >> failed to put to world state. %s", err6.Error())
-	}
-
-	//=====================================
-	// remove request from buyer Requested
-	// get buyer data
-
-	key4 := "user" + "_" + buyer
-
-	dataAsBytes2, err7 := ctx.GetStub().GetState(key4)
-
-	if err7 != nil {
-		return Transaction{}, fmt.Errorf("AcceptRequest_Estate >> Failed to read from world state. %s", err7.Error())
-	}
-
-	if dataAsBytes2 == nil {
-		return Transaction{}, fmt.Errorf("AcceptRequest_Estate >> %s does not exist", key4)
-	}
-
-	buyer_data := new(User)
-	err8 := json.Unmarshal(dataAsBytes2, &buyer_data)
-	if err8 != nil {
-		return Transaction{}, fmt.Errorf("AcceptRequest_Estate >> Can't Unmarshal Data")
+	price, err13 := s.latestPrice(ctx, serveyNo, estate.OfficeCode, request)
+	if err13 != nil {
+		return Transaction{}, fmt.Errorf("AcceptRequest_Estate >> %s", err13.Error())
 	}
 
-	// delete request from buyer requested
-
-	temp_requested := buyer_data.Requested
+	if !request.Agreed {
+		if offeredBy(request) == Party_Seller {
+			return Transaction{}, &Validation_Error{
+				Code:     ValidationCode_NotAgreed,
+				Function: "AcceptRequest_Estate",
+				ServeyNo: serveyNo,
+				Message:  fmt.Sprintf("Counter-offer to %s is not accepted by the buyer", buyer),
+			}
+		}
 
-	flag2 := false
-	index2 := -1
-	for i2, r2 := range temp_requested {
-		if r2.ServeyNo == serveyNo {
-			flag2 = true
-			index2 = i2
+		_, err11 := s.addOffer(ctx, serveyNo, estate, request, OfferKind_Accept, Party_Seller, seller, price, request.Conditions)
+		if err11 != nil {
+			return Transaction{}, fmt.Errorf("AcceptRequest_Estate >> %s", err11.Error())
 		}
 	}
 
-	if !flag2 {
-		return Transaction{}, fmt.Errorf("AcceptRequest_Estate >> No request found for given seveyNo: %s", serveyNo)
+	temp_transaction := Transaction{
+		Seller:              seller,
+		Buyer:               request.Buyer,
+		TransactionDateTime: temp_dateTime,
+		OfficeCode:          estate.OfficeCode,
+		ApprovedBy:          "",
+		ApprovedDateTime:    time.Time{},
+		Conditions:          request.Conditions,
+		Reason:              reason,
+		Shares:              estateOwners(estate),
+		Consents:            []string{seller},
 	}
 
-	buyer_data.Requested = append(temp_requested[:index2], temp_requested[index2+1:]...)
-
-	// update buyer data
-
-	marshaled_data3, _ := json.Marshal(buyer_data)
-	err9 := ctx.GetStub().PutState(key4, marshaled_data3)
-	if err9 != nil {
-		return Transaction{}, fmt.Errorf("AcceptRequest_Estate >> failed to put to world state. %s", err9.Error())
+	num, err4 := s.submitSale(ctx, "AcceptRequest_Estate", serveyNo, estate, &temp_transaction, price)
+	if err4 != nil {
+		return Transaction{}, err4
 	}
 
 	//=====================================
 	// set event
 
-	/*
-		transaction_event := Transaction_Event{
-			ServeyNo:         serveyNo,
-			TransactionCount: estate.TransactionsCount + 1,
-			Transaction:      temp_transaction,
-		}
-
-		marshaled_data3, _ := json.Marshal(transaction_event)
-
-		ctx.GetStub().SetEvent("newEstateSell", marshaled_data3)
-	*/
+	addEvent(ctx, EventType_RequestAccepted, Transaction_Event{
+		ServeyNo:         serveyNo,
+		TransactionCount: num,
+		Transaction:      temp_transaction,
+	})
 
 	return temp_transaction, nil
 }
 
 func (s *SmartContract) ClearRequests_Estate(ctx contractapi.TransactionContextInterface, serveyNo string, buyer string) error {
-	// get estate data
 
-	key1 := "estate" + "_" + serveyNo
-	dataAsBytes1, err1 := ctx.GetStub().GetState(key1)
+	repo := newRepository(ctx)
 
+	// get estate data
+	estate, err1 := repo.GetEstate(serveyNo)
 	if err1 != nil {
-		return fmt.Errorf("ClearRequests_Estate >> Failed to read from world state. %s", err1.Error())
+		return fmt.Errorf("ClearRequests_Estate >> %s", err1.Error())
 	}
 
-	if dataAsBytes1 == nil {
-		return fmt.Errorf("ClearRequests_Estate >> %s does not exist", key1)
+	// co-owners can clear any request, buyer only own request
+	caller, uid, err0 := s.authorizeUser(ctx, "ClearRequests_Estate")
+	if err0 != nil {
+		return err0
+	}
+
+	if !isOwner(estate, uid) && buyer != uid {
+		return &Access_Error{
+			Code:     AccessCode_NotOwner,
+			Function: "ClearRequests_Estate",
+			Caller:   caller.EnrollmentID,
+			Allowed:  []string{Access_Owner, Access_Buyer},
+			Message:  fmt.Sprintf("Neither estate nor request is owned by %s", uid),
+		}
 	}
 
-	estate := new(Estate)
-	err2 := json.Unmarshal(dataAsBytes1, &estate)
+	err2 := transitionEstate(ctx, "ClearRequests_Estate", serveyNo, estate, Action_ClearRequests)
 	if err2 != nil {
-		return fmt.Errorf("ClearRequests_Estate >> Can't Unmarshal Data")
+		return err2
 	}
 
 	//=====================================
@@ -399,59 +372,317 @@ func (s *SmartContract) ClearRequests_Estate(ctx contractapi.TransactionContextI
 
 	}
 
-	marshaled_data1, _ := json.Marshal(estate)
-	err3 := ctx.GetStub().PutState(key1, marshaled_data1)
+	err3 := repo.PutEstate(serveyNo, estate)
 	if err3 != nil {
-		return fmt.Errorf("ClearRequests_Estate >> failed to put to world state. %s", err3.Error())
+		return fmt.Errorf("ClearRequests_Estate >> %s", err3.Error())
 	}
 
 	//=====================================
 
 	// remove requested from buyer/s
 
-	for _, trav_buyer := range buyers_list {
+	err4 := clearRequested(repo, serveyNo, buyers_list)
+	if err4 != nil {
+		return fmt.Errorf("ClearRequests_Estate >> %s", err4.Error())
+	}
+
+	addEvent(ctx, EventType_RequestsCleared, RequestsCleared_Event{
+		ServeyNo: serveyNo,
+		Buyers:   buyers_list,
+	})
 
-		// get buyer data
+	return nil
+}
+
+// WithdrawAcceptance_Estate (seller) and WithdrawOffer_Estate (buyer) cancel an
+// accepted sale until the sub-registrar approves it. The pending transaction is
+// deleted and kept in a Cancellation record, with the penalty set by
+// Set_CancellationPenalty owed to the other party.
+
+func (s *SmartContract) WithdrawAcceptance_Estate(ctx contractapi.TransactionContextInterface, serveyNo string) (Cancellation, error) {
+
+	// get estate data
+	estate, err0 := newRepository(ctx).GetEstate(serveyNo)
+	if err0 != nil {
+		return Cancellation{}, fmt.Errorf("WithdrawAcceptance_Estate >> %s", err0.Error())
+	}
+
+	// any co-owner selling a share
+	_, seller, err1 := s.authorizeOwner(ctx, "WithdrawAcceptance_Estate", estate)
+	if err1 != nil {
+		return Cancellation{}, err1
+	}
+
+	return s.withdrawSale(ctx, "WithdrawAcceptance_Estate", serveyNo, estate, Party_Seller, seller)
+}
+
+func (s *SmartContract) WithdrawOffer_Estate(ctx contractapi.TransactionContextInterface, serveyNo string) (Cancellation, error) {
+
+	// get estate data
+	estate, err0 := newRepository(ctx).GetEstate(serveyNo)
+	if err0 != nil {
+		return Cancellation{}, fmt.Errorf("WithdrawOffer_Estate >> %s", err0.Error())
+	}
+
+	// buyer is checked against the pending transaction
+	_, buyer, err1 := s.authorizeUser(ctx, "WithdrawOffer_Estate")
+	if err1 != nil {
+		return Cancellation{}, err1
+	}
+
+	return s.withdrawSale(ctx, "WithdrawOffer_Estate", serveyNo, estate, Party_Buyer, buyer)
+}
+
+// ------------------------------------
+
+// Helper Functions - Private
+
+// reasons set by their own flows, gift.go, succession.go, parcel.go and
+// auction.go, never passed by a seller
+var reservedReasons = []string{"gift", "inheritance", "partition", "amalgamation", "auction"}
+
+func checkReason(fname string, reason string) error {
+
+	if searchArray(reservedReasons, reason) != -1 {
+		return fmt.Errorf("%s >> reason %q is reserved, it is set by its own transaction", fname, reason)
+	}
+
+	return nil
+}
+
+func (s *SmartContract) withdrawSale(ctx contractapi.TransactionContextInterface, fname string, serveyNo string, estate *Estate, party string, uid string) (Cancellation, error) {
+
+	repo := newRepository(ctx)
+
+	num := estate.TransactionsCount + 1
+	transaction, admin, err0 := s.getPendingTransaction(ctx, fname, serveyNo, estate)
+	if err0 != nil {
+		return Cancellation{}, err0
+	}
+
+	withdrawnBy, payableTo := uid, transaction.Buyer
+	isParty := searchShare(saleShares(transaction), uid) != -1
+	if party == Party_Buyer {
+		payableTo = transaction.Seller
+		isParty = transaction.Buyer == uid
+	}
+
+	if !isParty {
+		caller, _ := s.getCaller(ctx)
+		return Cancellation{}, &Access_Error{
+			Code:     AccessCode_NotOwner,
+			Function: fname,
+			Caller:   caller.EnrollmentID,
+			Allowed:  []string{Access_Owner, Access_Buyer},
+			Message:  fmt.Sprintf("Transaction %d is not made by %s", num, uid),
+		}
+	}
+
+	// back to listed, the owner can accept another request, or to the state
+	// a share transfer or gift started from
+	err1 := revertEstate(ctx, fname, serveyNo, estate, Action_Withdraw, transaction.FromState)
+	if err1 != nil {
+		return Cancellation{}, err1
+	}
+
+	config, err2 := repo.GetConfig()
+	if err2 != nil {
+		return Cancellation{}, fmt.Errorf("%s >> %s", fname, err2.Error())
+	}
+
+	temp_dateTime, err3 := getTxDateTime(ctx)
+	if err3 != nil {
+		return Cancellation{}, fmt.Errorf("%s >> %s", fname, err3.Error())
+	}
+
+	transactionKey, err8 := repo.TransactionKey(serveyNo, num)
+	if err8 != nil {
+		return Cancellation{}, fmt.Errorf("%s >> %s", fname, err8.Error())
+	}
+
+	price, err9 := s.getPrice(ctx, estate.OfficeCode, transactionKey, transaction.PriceHash, transaction.Price)
+	if err9 != nil {
+		return Cancellation{}, fmt.Errorf("%s >> %s", fname, err9.Error())
+	}
+
+	cancellationKey, err10 := repo.CancellationKey(serveyNo, ctx.GetStub().GetTxID())
+	if err10 != nil {
+		return Cancellation{}, fmt.Errorf("%s >> %s", fname, err10.Error())
+	}
+
+	// penalty is private like the price it is taken from
+	penaltyHash, err11 := s.putPrice(ctx, estate.OfficeCode, cancellationKey, Private_Price{
+		Price: price.Price * config.CancellationPenalty / 100,
+		Salt:  price.Salt,
+	}, transactionParties(transaction)...)
+	if err11 != nil {
+		return Cancellation{}, fmt.Errorf("%s >> %s", fname, err11.Error())
+	}
 
-		key2 := "user" + "_" + trav_buyer
+	cancellation := Cancellation{
+		TransactionCount: num,
+		Transaction:      *transaction,
+		WithdrawnBy:      withdrawnBy,
+		Party:            party,
+		PenaltyHash:      penaltyHash,
+		PayableTo:        payableTo,
+		DateTime:         temp_dateTime,
+	}
+
+	admin.ToApprove, _ = removeApproval(admin.ToApprove, serveyNo, num)
+
+	//=====================================
+
+	err4 := repo.PutEstate(serveyNo, estate)
+	if err4 != nil {
+		return Cancellation{}, fmt.Errorf("%s >> %s", fname, err4.Error())
+	}
+
+	err5 := repo.DelTransaction(serveyNo, num)
+	if err5 != nil {
+		return Cancellation{}, fmt.Errorf("%s >> %s", fname, err5.Error())
+	}
+
+	err6 := repo.PutAdmin(estate.OfficeCode, admin)
+	if err6 != nil {
+		return Cancellation{}, fmt.Errorf("%s >> %s", fname, err6.Error())
+	}
 
-		dataAsBytes2, err4 := ctx.GetStub().GetState(key2)
+	err7 := repo.PutCancellation(serveyNo, ctx.GetStub().GetTxID(), &cancellation)
+	if err7 != nil {
+		return Cancellation{}, fmt.Errorf("%s >> %s", fname, err7.Error())
+	}
 
-		if err4 != nil {
-			return fmt.Errorf("ClearRequests_Estate >> Failed to read from world state. %s", err4.Error())
+	eventType := EventType_AcceptanceWithdrawn
+	if party == Party_Buyer {
+		eventType = EventType_OfferWithdrawn
+	}
+
+	addEvent(ctx, eventType, Cancellation_Event{
+		ServeyNo:     serveyNo,
+		Cancellation: cancellation,
+	})
+
+	return cancellation, nil
+}
+
+// puts the pending transaction of an accepted sale with its private price,
+// submits it for registration once the co-owners consented, see
+// ownership.go, returns its number. Liens are checked by the caller, before
+// anything is written
+func (s *SmartContract) submitSale(ctx contractapi.TransactionContextInterface, fname string, serveyNo string, estate *Estate, transaction *Transaction, price Private_Price) (int, error) {
+
+	repo := newRepository(ctx)
+
+	num := estate.TransactionsCount + 1
+
+	transactionKey, err5 := repo.TransactionKey(serveyNo, num)
+	if err5 != nil {
+		return 0, fmt.Errorf("%s >> %s", fname, err5.Error())
+	}
+
+	priceHash, err6 := s.putPrice(ctx, estate.OfficeCode, transactionKey, price, transactionParties(transaction)...)
+	if err6 != nil {
+		return 0, fmt.Errorf("%s >> %s", fname, err6.Error())
+	}
+
+	config, err7 := repo.GetConfig()
+	if err7 != nil {
+		return 0, fmt.Errorf("%s >> %s", fname, err7.Error())
+	}
+
+	transaction.Price = 0
+	transaction.PriceHash = priceHash
+	transaction.StampDuty = stampDuty(estate, transaction, config)
+
+	err0 := repo.PutTransaction(serveyNo, num, transaction)
+	if err0 != nil {
+		return 0, fmt.Errorf("%s >> %s", fname, err0.Error())
+	}
+
+	// a sale of the whole estate deletes all requests, co-owners who did not
+	// accept it consent with ConsentSale_Estate. Share transfers keep them,
+	// the buyers still want the estate
+	if transaction.FromState == "" {
+		buyers := []string{}
+		for _, request := range estate.Requests {
+			buyers = append(buyers, request.Buyer)
 		}
 
-		if dataAsBytes2 == nil {
-			return fmt.Errorf("ClearRequests_Estate >> %s does not exist", key2)
+		err3 := clearRequested(repo, serveyNo, buyers)
+		if err3 != nil {
+			return 0, fmt.Errorf("%s >> %s", fname, err3.Error())
 		}
 
-		buyer_data := new(User)
-		err5 := json.Unmarshal(dataAsBytes2, &buyer_data)
-		if err5 != nil {
-			return fmt.Errorf("ClearRequests_Estate >> Can't Unmarshal Data")
+		estate.Requests = []Request{}
+	}
+
+	if isConsented(transaction, config.ConsentThreshold) {
+		err1 := s.submitForApproval(ctx, fname, serveyNo, estate, num)
+		if err1 != nil {
+			return 0, err1
 		}
+	}
 
-		// find and delete requested entry
+	err2 := repo.PutEstate(serveyNo, estate)
+	if err2 != nil {
+		return 0, fmt.Errorf("%s >> %s", fname, err2.Error())
+	}
 
-		temp_requested := buyer_data.Requested
+	return num, nil
+}
 
-		index2 := -1
+// removes serveyNo from Requested of the buyers, entries already removed are
+// skipped
+func clearRequested(repo *Repository, serveyNo string, buyers []string) error {
 
-		for i0, entry := range temp_requested {
-			if entry.ServeyNo == serveyNo {
-				index2 = i0
-				break
-			}
+	for _, uid := range buyers {
+		buyer, err0 := repo.GetUser(uid)
+		if err0 != nil {
+			return err0
+		}
+
+		requested, found := removeRequested(buyer.Requested, serveyNo)
+		if !found {
+			continue
 		}
 
-		buyer_data.Requested = append(temp_requested[:index2], temp_requested[index2+1:]...)
+		buyer.Requested = requested
 
-		marshaled_data2, _ := json.Marshal(buyer_data)
-		err6 := ctx.GetStub().PutState(key2, marshaled_data2)
-		if err6 != nil {
-			return fmt.Errorf("ClearRequests_Estate >> failed to put to world state. %s", err6.Error())
+		err1 := repo.PutUser(uid, buyer)
+		if err1 != nil {
+			return err1
 		}
 	}
 
 	return nil
 }
+
+// submits transaction num for registration and adds it to toApprove of the
+// admin, the caller still has to put the estate
+func (s *SmartContract) submitForApproval(ctx contractapi.TransactionContextInterface, fname string, serveyNo string, estate *Estate, num int) error {
+
+	err0 := transitionEstate(ctx, fname, serveyNo, estate, Action_Submit)
+	if err0 != nil {
+		return err0
+	}
+
+	repo := newRepository(ctx)
+
+	admin, err1 := repo.GetAdmin(estate.OfficeCode)
+	if err1 != nil {
+		return fmt.Errorf("%s >> %s", fname, err1.Error())
+	}
+
+	admin.ToApprove = append(admin.ToApprove, Approval_Ref{
+		ServeyNo:         serveyNo,
+		TransactionCount: num,
+	})
+
+	err2 := repo.PutAdmin(estate.OfficeCode, admin)
+	if err2 != nil {
+		return fmt.Errorf("%s >> %s", fname, err2.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,103 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Validation
+//
+// Preconditions of a transaction are checked before it writes anything.
+// Failed preconditions are returned as Validation_Error, whose message is
+// JSON like Access_Error, so clients can tell them apart from other failures.
+
+const (
+	ValidationCode_InvalidState   = "INVALID_STATE"   // transition not allowed from the estate's state
+	ValidationCode_NotPending     = "NOT_PENDING"     // transaction is not waiting for approval
+	ValidationCode_NotOwned       = "NOT_OWNED"       // seller does not own the estate
+	ValidationCode_NotVerified    = "NOT_VERIFIED"    // user is not verified
+	ValidationCode_MissingEntry   = "MISSING_ENTRY"   // entry missing from Owned, ToApprove, ...
+	ValidationCode_OfficeMismatch = "OFFICE_MISMATCH" // transaction and estate are in different offices
+	ValidationCode_OutOfTurn      = "OUT_OF_TURN"     // latest offer was made by the caller
+	ValidationCode_NotAgreed      = "NOT_AGREED"      // latest offer is not accepted by the other party
+	ValidationCode_Expired        = "EXPIRED"         // request is past its validity
+	ValidationCode_Encumbered     = "ENCUMBERED"      // active lien without consent of the lienholder
+	ValidationCode_Objected       = "OBJECTED"        // objection period running or objections not dismissed
+	ValidationCode_InvalidStatus  = "INVALID_STATUS"  // status outside the values of its record
+)
+
+type Validation_Error struct {
+	Code     string `json:"code"`
+	Function string `json:"function"`
+	ServeyNo string `json:"serveyNo"`
+	Message  string `json:"message"`
+}
+
+func (e *Validation_Error) Error() string {
+	marshaled_data, _ := json.Marshal(e)
+	return string(marshaled_data)
+}
+
+// ------------------------------------
+
+// Helper Functions - Private
+
+// 0/1/2 - Not verified/Verified/Suspended, deceased is only set by
+// RegisterDeath_User
+func checkUserStatus(fname string, uid string, status int) error {
+
+	if status < 0 || status > 2 {
+		return &Validation_Error{
+			Code:     ValidationCode_InvalidStatus,
+			Function: fname,
+			Message:  fmt.Sprintf("status of %s must be 0, 1 or 2", uid),
+		}
+	}
+
+	return nil
+}
+
+// removes val from arr, false if it is not in arr
+func removeString(arr []string, val string) ([]string, bool) {
+
+	i := searchArray(arr, val)
+	if i == -1 {
+		return arr, false
+	}
+
+	return append(arr[:i], arr[i+1:]...), true
+}
+
+// removes the request for serveyNo, false if it is not in arr
+func removeRequested(arr []Request_Buyer, serveyNo string) ([]Request_Buyer, bool) {
+
+	for i, r := range arr {
+		if r.ServeyNo == serveyNo {
+			return append(arr[:i], arr[i+1:]...), true
+		}
+	}
+
+	return arr, false
+}
+
+// removes the approval of a lease, false if it is not in arr
+func removeLeaseApproval(arr []Approval_Ref, serveyNo string, leaseID string) ([]Approval_Ref, bool) {
+
+	i := searchLeaseApproval(arr, serveyNo, leaseID)
+	if i == -1 {
+		return arr, false
+	}
+
+	return append(arr[:i], arr[i+1:]...), true
+}
+
+// removes the approval of a transaction, false if it is not in arr
+func removeApproval(arr []Approval_Ref, serveyNo string, transactionCount int) ([]Approval_Ref, bool) {
+
+	i := searchApproval(arr, serveyNo, transactionCount)
+	if i == -1 {
+		return arr, false
+	}
+
+	return append(arr[:i], arr[i+1:]...), true
+}
@@ -10,7 +10,7 @@ import (
 
 func main() {
 
-	chaincode, err := contractapi.NewChaincode(new(lib.SmartContract))
+	chaincode, err := contractapi.NewChaincode(lib.Contracts()...)
 
 	if err != nil {
 		fmt.Printf("Error create Real Estate chaincode: %s", err.Error())